@@ -0,0 +1,8 @@
+// 代理池和Cookie池web接口的认证模块:
+// 令牌存储 - token.go
+// 权限、限流和令牌管理接口 - guard.go
+//
+// 每个令牌拥有若干权限(scope)，并可以指定一个命名空间。命名空间对应
+// 独立的存储键，使多个团队可以共用同一个部署而互不影响。
+
+package auth
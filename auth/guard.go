@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type contextKey struct{}
+
+// 请求携带的令牌，没有启用认证时返回nil
+func FromContext(ctx context.Context) *Token {
+	t, _ := ctx.Value(contextKey{}).(*Token)
+	return t
+}
+
+// 请求对应的存储命名空间
+func Namespace(r *http.Request) string {
+	if t := FromContext(r.Context()); t != nil {
+		return t.Namespace
+	}
+	return ""
+}
+
+// 令牌桶限流
+type limiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (l *limiter) allow(now time.Time) bool {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// 检查web接口请求的令牌、权限和频率。Guard为nil时不进行认证
+type Guard struct {
+	Store *Store
+
+	mu       sync.Mutex
+	limiters map[string]*limiter // 每个令牌的限流，令牌删除或者更新时清除
+}
+
+func NewGuard(store *Store) *Guard {
+	return &Guard{Store: store}
+}
+
+// 只从Authorization: Bearer请求头中读取令牌。URL中的令牌会出现在访问日志、代理和Referer中
+func tokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return ""
+}
+
+func (g *Guard) allow(t *Token) bool {
+	if t.RateLimit <= 0 {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	burst := float64(t.Burst)
+	if burst < 1 {
+		burst = t.RateLimit
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	now := time.Now()
+	l, ok := g.limiters[t.Token]
+	if !ok || l.rate != t.RateLimit || l.burst != burst {
+		if g.limiters == nil {
+			g.limiters = map[string]*limiter{}
+		}
+		l = &limiter{rate: t.RateLimit, burst: burst, tokens: burst, last: now}
+		g.limiters[t.Token] = l
+	}
+	return l.allow(now)
+}
+
+// 清除令牌的限流状态
+func (g *Guard) forget(tokens ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, token := range tokens {
+		delete(g.limiters, token)
+	}
+}
+
+// 要求请求的令牌拥有scope权限
+func (g *Guard) Handle(scope string, h http.HandlerFunc) http.HandlerFunc {
+	if g == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromRequest(r)
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("token is required"))
+			return
+		}
+		t, err := g.Store.Get(token)
+		if errors.Is(err, ErrUnknownToken) {
+			// 直接从存储中删除的令牌在这里清除限流状态
+			g.forget(token)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !t.HasScope(scope) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("token has no scope: %s", scope))
+			return
+		}
+		if !g.allow(t) {
+			writeError(w, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded"))
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, t)))
+	}
+}
+
+// 所有令牌使用的命名空间，Guard为nil时没有命名空间
+func (g *Guard) Namespaces() []string {
+	if g == nil {
+		return nil
+	}
+	nss, err := g.Store.Namespaces()
+	if err != nil {
+		log.Printf("get namespaces failed: %v\n", err)
+	}
+	return nss
+}
+
+// 注册令牌管理接口，需要admin权限
+//
+//	GET    /admin/tokens    所有令牌
+//	POST   /admin/tokens    添加令牌，正文为令牌的JSON，token为空时自动生成
+//	DELETE /admin/tokens    删除令牌，参数token
+func (g *Guard) HandleAdmin(servermux *http.ServeMux) {
+	if g == nil {
+		return
+	}
+	servermux.HandleFunc("/admin/tokens", g.Handle(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			tokens, err := g.Store.List()
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, tokens)
+		case http.MethodPost:
+			t := &Token{}
+			if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("decode token failed: %v", err))
+				return
+			}
+			if len(t.Scopes) == 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("scopes are required"))
+				return
+			}
+			if err := g.Store.Set(t); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			g.forget(t.Token)
+			writeJSON(w, http.StatusOK, t)
+		case http.MethodDelete:
+			token := r.FormValue("token")
+			if token == "" {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("token is required"))
+				return
+			}
+			if err := g.Store.Delete(token); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			g.forget(token)
+			writeJSON(w, http.StatusOK, map[string]string{"token": token})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		}
+	}))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response failed: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,139 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"gospider/auth"
+	"gospider/internal/kv"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.db"), "tokens_test")
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v\n", err)
+	}
+	defer store.Close()
+
+	reader := &auth.Token{Name: "reader", Scopes: []string{auth.ScopeReadProxy}, Namespace: "team", RateLimit: 1, Burst: 2}
+	admin := &auth.Token{Name: "admin", Scopes: []string{auth.ScopeAdmin}}
+	for _, tok := range []*auth.Token{reader, admin} {
+		if err := store.Set(tok); err != nil {
+			t.Fatalf("Set token failed: %v\n", err)
+		}
+	}
+	defer store.Delete(reader.Token, admin.Token)
+
+	g := auth.NewGuard(store)
+	var namespace string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		namespace = auth.Namespace(r)
+	}
+	read := g.Handle(auth.ScopeReadProxy, h)
+	report := g.Handle(auth.ScopeReport, h)
+
+	tests := []struct {
+		handler http.HandlerFunc
+		token   string
+		code    int
+	}{
+		{read, "", http.StatusUnauthorized},
+		{read, "unknown", http.StatusUnauthorized},
+		{report, reader.Token, http.StatusForbidden},
+		{report, admin.Token, http.StatusOK},
+		{read, reader.Token, http.StatusOK},
+		{read, reader.Token, http.StatusOK},
+		{read, reader.Token, http.StatusTooManyRequests},
+	}
+	for i, test := range tests {
+		req := httptest.NewRequest("GET", "/random", nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		test.handler(w, req)
+		if w.Code != test.code {
+			t.Fatalf("Guard failed: case(%d) expect(%d) get(%d)\n", i, test.code, w.Code)
+		}
+	}
+	// 不接受URL参数中的令牌
+	req := httptest.NewRequest("GET", "/random?token="+admin.Token, nil)
+	w := httptest.NewRecorder()
+	report(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Guard failed: expect token in url rejected, get %d\n", w.Code)
+	}
+	if namespace != "team" {
+		t.Fatalf("Guard failed: expect namespace team, get %s\n", namespace)
+	}
+}
+
+func TestGuardErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.db")
+	// 损坏的令牌信息
+	kvs, err := kv.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	if err := kvs.HSet("tokens_test", "broken", "{"); err != nil {
+		t.Fatalf("HSet failed: %v\n", err)
+	}
+	kvs.Close()
+
+	store, err := auth.NewFileStore(path, "tokens_test")
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v\n", err)
+	}
+	defer store.Close()
+	limited := &auth.Token{Name: "limited", Scopes: []string{auth.ScopeReadProxy}, RateLimit: 1, Burst: 1}
+	if err := store.Set(limited); err != nil {
+		t.Fatalf("Set token failed: %v\n", err)
+	}
+
+	// 不使用NewGuard建立的Guard同样可以限流
+	g := &auth.Guard{Store: store}
+	read := g.Handle(auth.ScopeReadProxy, func(w http.ResponseWriter, r *http.Request) {})
+	do := func(token string) int {
+		req := httptest.NewRequest("GET", "/random", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		read(w, req)
+		return w.Code
+	}
+	if code := do(limited.Token); code != http.StatusOK {
+		t.Fatalf("Guard failed: expect 200, get %d\n", code)
+	}
+	if code := do(limited.Token); code != http.StatusTooManyRequests {
+		t.Fatalf("Guard failed: expect 429, get %d\n", code)
+	}
+
+	// 更新令牌后重新开始限流
+	admin := &auth.Token{Name: "admin", Scopes: []string{auth.ScopeAdmin}}
+	if err := store.Set(admin); err != nil {
+		t.Fatalf("Set token failed: %v\n", err)
+	}
+	mux := &http.ServeMux{}
+	g.HandleAdmin(mux)
+	b, _ := json.Marshal(limited)
+	req := httptest.NewRequest("POST", "/admin/tokens", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+admin.Token)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update token failed: %d %s\n", w.Code, w.Body.String())
+	}
+	if code := do(limited.Token); code != http.StatusOK {
+		t.Fatalf("Guard failed: expect 200 after updating token, get %d\n", code)
+	}
+
+	// 读取令牌失败不是认证失败
+	if code := do("broken"); code != http.StatusInternalServerError {
+		t.Fatalf("Guard failed: expect 500 for broken token, get %d\n", code)
+	}
+	if code := do("unknown"); code != http.StatusUnauthorized {
+		t.Fatalf("Guard failed: expect 401 for unknown token, get %d\n", code)
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+// 令牌存储模块使用Redis的Hash，键为令牌，值为令牌信息的JSON字符串。
+// 不使用Redis时可以使用本地文件，见NewFileStore。
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"gospider/internal/kv"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ScopeReadProxy     = "proxy:read"     // 获取代理
+	ScopeReport        = "proxy:report"   // 报告代理的使用结果
+	ScopeReadCookie    = "cookie:read"    // 获取Cookies
+	ScopeManageAccount = "account:manage" // 管理账号
+	ScopeAdmin         = "admin"          // 管理令牌，拥有所有权限
+)
+
+type Token struct {
+	Token     string   `json:"token"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Namespace string   `json:"namespace,omitempty"`  // 令牌对应的存储命名空间，为空时使用默认的存储
+	RateLimit float64  `json:"rate_limit,omitempty"` // 每秒请求数，0表示不限制
+	Burst     int      `json:"burst,omitempty"`      // 允许的突发请求数
+	Created   int64    `json:"created"`
+}
+
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// 生成随机令牌
+func GenerateToken() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type Store struct {
+	store kv.Store // 存储后端
+	key   string   // 数据库键
+}
+
+func NewStore(addr string, password string, key string) (*Store, error) {
+	return NewStoreWithOptions(&redis.UniversalOptions{
+		Addrs:    []string{addr},
+		Password: password,
+		DB:       0,
+		PoolSize: 10,
+	}, key)
+}
+
+// 使用完整的Redis配置建立令牌存储
+func NewStoreWithOptions(opts *redis.UniversalOptions, key string) (*Store, error) {
+	store, err := kv.NewRedisWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{store: store, key: key}, nil
+}
+
+// 使用已有的Redis客户端建立令牌存储
+func NewStoreWithClient(rdb redis.UniversalClient, key string) (*Store, error) {
+	store, err := kv.NewRedis(rdb)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{store: store, key: key}, nil
+}
+
+// 使用本地文件建立令牌存储，可以与代理池或者Cookie池使用同一个文件
+func NewFileStore(path string, key string) (*Store, error) {
+	store, err := kv.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{store: store, key: key}, nil
+}
+
+func (s *Store) Close() error {
+	return s.store.Close()
+}
+
+// 添加或者更新令牌，令牌为空时生成随机令牌
+func (s *Store) Set(t *Token) error {
+	if t.Token == "" {
+		t.Token = GenerateToken()
+	}
+	if t.Created == 0 {
+		t.Created = time.Now().Unix()
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.store.HSet(s.key, t.Token, string(b))
+}
+
+// 令牌不存在时返回的错误，其他错误表示存储不可用或者令牌信息损坏
+var ErrUnknownToken = errors.New("unknown token")
+
+// 获取令牌信息，令牌不存在时返回ErrUnknownToken
+func (s *Store) Get(token string) (*Token, error) {
+	v, err := s.store.HGet(s.key, token)
+	if err == kv.Nil {
+		return nil, ErrUnknownToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &Token{}
+	if err := json.Unmarshal([]byte(v), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *Store) Delete(tokens ...string) error {
+	return s.store.HDel(s.key, tokens...)
+}
+
+// 按照创建时间排序的所有令牌
+func (s *Store) List() ([]*Token, error) {
+	vs, err := s.store.HVals(s.key)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*Token, 0, len(vs))
+	for _, v := range vs {
+		t := &Token{}
+		if err := json.Unmarshal([]byte(v), t); err != nil {
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created < tokens[j].Created })
+	return tokens, nil
+}
+
+// 所有令牌使用的非空命名空间
+func (s *Store) Namespaces() ([]string, error) {
+	tokens, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	set := map[string]bool{}
+	var nss []string
+	for _, t := range tokens {
+		if t.Namespace != "" && !set[t.Namespace] {
+			set[t.Namespace] = true
+			nss = append(nss, t.Namespace)
+		}
+	}
+	sort.Strings(nss)
+	return nss, nil
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// web接口返回的错误
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Message)
+}
+
+// 服务端错误和限流可以重试
+func (e *APIError) temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+type Client struct {
+	ProxyURL  string // 代理池web接口地址，如http://localhost:8090
+	CookieURL string // Cookie池web接口地址，如http://localhost:8091
+	Token     string // 接口令牌，为空时不进行认证
+
+	HTTPClient *http.Client  // 访问接口使用的客户端，为nil时使用带连接池的默认客户端
+	MaxRetries int           // 请求失败后的最大重试次数，默认为3，负数时不重试
+	RetryDelay time.Duration // 第一次重试前的等待时间，之后每次加倍，默认为200毫秒
+
+	CacheSize int           // 每次从代理池获取并缓存的代理数目，默认为10
+	CacheTTL  time.Duration // 缓存代理的有效时间，默认为30秒
+
+	once sync.Once
+	hc   *http.Client
+
+	mu    sync.Mutex
+	cache map[ProxyFilter]*proxyCache
+}
+
+func New(proxyURL, cookieURL, token string) *Client {
+	return &Client{ProxyURL: proxyURL, CookieURL: cookieURL, Token: token}
+}
+
+func (c *Client) httpClient() *http.Client {
+	c.once.Do(func() {
+		c.hc = c.HTTPClient
+		if c.hc == nil {
+			c.hc = &http.Client{
+				Timeout: 10 * time.Second,
+				Transport: &http.Transport{
+					Proxy:               http.ProxyFromEnvironment,
+					DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+					MaxIdleConns:        100,
+					MaxIdleConnsPerHost: 20,
+					IdleConnTimeout:     90 * time.Second,
+				},
+			}
+		}
+	})
+	return c.hc
+}
+
+func (c *Client) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return 3
+	}
+	if c.MaxRetries < 0 {
+		return 0
+	}
+	return c.MaxRetries
+}
+
+func (c *Client) retryDelay() time.Duration {
+	if c.RetryDelay > 0 {
+		return c.RetryDelay
+	}
+	return 200 * time.Millisecond
+}
+
+// 发送请求并读取响应正文，失败时按指数退避重试。GET请求在网络错误、服务端错误和限流时重试，
+// 其他请求不是幂等的，只在请求发出之前的连接失败时重试
+func (c *Client) do(method, base, path string, query url.Values) (*http.Response, []byte, error) {
+	if base == "" {
+		return nil, nil, fmt.Errorf("no server address for %s", path)
+	}
+	u := strings.TrimRight(base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	delay := c.retryDelay()
+	var err error
+	for i := 0; ; i++ {
+		var resp *http.Response
+		var body []byte
+		resp, body, err = c.request(method, u)
+		if err == nil {
+			return resp, body, nil
+		}
+		if i >= c.maxRetries() || !retryable(method, err) {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return nil, nil, err
+}
+
+// 发送一次请求
+func (c *Client) request(method, u string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, nil, &APIError{StatusCode: resp.StatusCode, Message: errorMessage(body)}
+	}
+	return resp, body, nil
+}
+
+// 请求是否可以重试
+func retryable(method string, err error) bool {
+	if method != http.MethodGet {
+		return dialError(err)
+	}
+	if e, ok := err.(*APIError); ok {
+		return e.temporary()
+	}
+	return true
+}
+
+// 连接服务端失败，请求还没有发出
+func dialError(err error) bool {
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
+}
+
+// 接口的错误为{"error": "..."}或者纯文本
+func errorMessage(body []byte) string {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		return e.Error
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,197 @@
+package client_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"gospider/client"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRandomProxy(t *testing.T) {
+	var fetches, failures int32
+	var reported string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/proxies":
+			// 第一次请求失败，客户端应该重试
+			if atomic.AddInt32(&failures, 1) == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			atomic.AddInt32(&fetches, 1)
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				t.Errorf("RandomProxy failed: no token in the request\n")
+			}
+			fmt.Fprint(w, `[{"proxy":"1.1.1.1:80","score":100},{"proxy":"2.2.2.2:80","score":100}]`)
+		case "/report":
+			reported = r.FormValue("proxy") + " " + r.FormValue("ok")
+		}
+	}))
+	defer ts.Close()
+
+	c := &client.Client{ProxyURL: ts.URL, Token: "secret", RetryDelay: time.Millisecond}
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		p, err := c.RandomProxy(client.ProxyFilter{})
+		if err != nil {
+			t.Fatalf("RandomProxy failed: %v\n", err)
+		}
+		seen[p] = true
+	}
+	if len(seen) != 2 || fetches != 1 {
+		t.Fatalf("RandomProxy failed: proxies(%v) fetches(%d)\n", seen, fetches)
+	}
+
+	if err := c.Report("1.1.1.1:80", false); err != nil {
+		t.Fatalf("Report failed: %v\n", err)
+	}
+	if reported != "1.1.1.1:80 false" {
+		t.Fatalf("Report failed: get %s\n", reported)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":"token has no scope: proxy:read"}`)
+	}))
+	defer ts.Close()
+
+	c := &client.Client{ProxyURL: ts.URL, RetryDelay: time.Millisecond}
+	_, err := c.RandomProxy(client.ProxyFilter{})
+	e, ok := err.(*client.APIError)
+	if !ok || e.StatusCode != http.StatusForbidden || e.Message != "token has no scope: proxy:read" {
+		t.Fatalf("APIError failed: get %v\n", err)
+	}
+	if requests != 1 {
+		t.Fatalf("APIError failed: client errors should not be retried, requests(%d)\n", requests)
+	}
+}
+
+// 模拟Cookie池接口
+func newCookieServer(released *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/example/checkout":
+			w.Header().Set("X-Username", "alice")
+			w.Header().Set("X-Lease", "lease1")
+			w.Header().Set("X-Lease-Expires", time.Now().Add(time.Hour).Format(time.RFC3339))
+			json.NewEncoder(w).Encode([]*http.Cookie{{Name: "sid", Value: "abc"}})
+		case "/example/release":
+			*released = r.FormValue("username") + " " + r.FormValue("lease") + " " + r.FormValue("ok")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestCheckoutCookie(t *testing.T) {
+	var released string
+	ts := newCookieServer(&released)
+	defer ts.Close()
+
+	c := &client.Client{CookieURL: ts.URL}
+	l, err := c.CheckoutCookie("example", time.Minute)
+	if err != nil {
+		t.Fatalf("CheckoutCookie failed: %v\n", err)
+	}
+	if l.Username != "alice" || l.ID != "lease1" || len(l.Cookies) != 1 || l.Cookies[0].Value != "abc" {
+		t.Fatalf("CheckoutCookie failed: get %+v\n", l)
+	}
+	if err := c.Discard(l); err != nil {
+		t.Fatalf("Discard failed: %v\n", err)
+	}
+	if released != "alice lease1 false" {
+		t.Fatalf("Discard failed: get %s\n", released)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	// 作为HTTP代理的服务器，直接返回请求的主机和Cookies
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.URL.Host, r.Header.Get("Cookie"))
+	}))
+	defer upstream.Close()
+
+	var fetches int32
+	var reported string
+	pool := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/proxies":
+			// 第一个代理无法连接
+			proxy := strings.TrimPrefix(upstream.URL, "http://")
+			if atomic.AddInt32(&fetches, 1) == 1 {
+				proxy = "127.0.0.1:1"
+			}
+			fmt.Fprintf(w, `[{"proxy":%q,"score":100}]`, proxy)
+		case "/report":
+			reported = r.FormValue("proxy") + " " + r.FormValue("ok")
+		}
+	}))
+	defer pool.Close()
+
+	var released string
+	cookies := newCookieServer(&released)
+	defer cookies.Close()
+
+	c := &client.Client{ProxyURL: pool.URL, CookieURL: cookies.URL, CacheSize: 1}
+	tr := &client.Transport{Client: c, Site: "example"}
+	resp, err := (&http.Client{Transport: tr}).Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("Transport failed: %v\n", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "example.com sid=abc" {
+		t.Fatalf("Transport failed: get %s\n", body)
+	}
+	if reported != "127.0.0.1:1 false" {
+		t.Fatalf("Transport failed: failed proxy is not reported, get %s\n", reported)
+	}
+
+	if err := tr.Close(); err != nil {
+		t.Fatalf("Close failed: %v\n", err)
+	}
+	if released != "alice lease1 true" {
+		t.Fatalf("Close failed: get %s\n", released)
+	}
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	var reports, counts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report":
+			atomic.AddInt32(&reports, 1)
+		case "/count":
+			if atomic.AddInt32(&counts, 1) == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, "3")
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c := &client.Client{ProxyURL: ts.URL, RetryDelay: time.Millisecond}
+	// POST请求已经发出，服务端错误时不重试
+	if err := c.Report("1.1.1.1:80", true); err == nil {
+		t.Fatalf("Report failed: expect error\n")
+	}
+	if reports != 1 {
+		t.Fatalf("Report failed: POST should not be retried, requests(%d)\n", reports)
+	}
+	if n, err := c.CountProxy(); err != nil || n != 3 {
+		t.Fatalf("CountProxy failed: %d %v\n", n, err)
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"fmt"
+	"gospider/cookiepool"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 签出的Cookies，使用完后需要归还
+type Lease struct {
+	Site     string
+	ID       string
+	Username string
+	Expires  time.Time
+	Proxy    string // 登录时使用的代理，使用Cookies时应使用同一个代理
+	Cookies  cookiepool.CookieList
+}
+
+// 租约是否在d时间内到期
+func (l *Lease) ExpiresWithin(d time.Duration) bool {
+	return time.Until(l.Expires) < d
+}
+
+// 签出网站的一份Cookies，ttl为租约的有效时间，不大于0时使用服务端的默认值
+func (c *Client) CheckoutCookie(site string, ttl time.Duration) (*Lease, error) {
+	q := url.Values{"format": {cookiepool.FormatJSON}}
+	if ttl > 0 {
+		q.Set("ttl", strconv.Itoa(int((ttl+time.Second-1)/time.Second)))
+	}
+	resp, body, err := c.do(http.MethodPost, c.CookieURL, "/"+site+"/checkout", q)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lease{
+		Site:     site,
+		ID:       resp.Header.Get("X-Lease"),
+		Username: resp.Header.Get("X-Username"),
+		Proxy:    resp.Header.Get("X-Proxy"),
+	}
+	if l.Expires, err = time.Parse(time.RFC3339, resp.Header.Get("X-Lease-Expires")); err != nil {
+		return nil, fmt.Errorf("invalid lease expires: %v", err)
+	}
+	if err := l.Cookies.Decode(body); err != nil {
+		return nil, fmt.Errorf("decode cookies failed: %v", err)
+	}
+	return l, nil
+}
+
+func (c *Client) release(l *Lease, ok bool) error {
+	q := url.Values{"username": {l.Username}, "lease": {l.ID}, "ok": {strconv.FormatBool(ok)}}
+	_, _, err := c.do(http.MethodPost, c.CookieURL, "/"+l.Site+"/release", q)
+	return err
+}
+
+// 归还租约
+func (c *Client) Release(l *Lease) error {
+	return c.release(l, true)
+}
+
+// 归还租约并报告Cookies不可用，服务端会立即验证Cookies
+func (c *Client) Discard(l *Lease) error {
+	return c.release(l, false)
+}
+
+// 随机获取网站的Cookies，不签出租约
+func (c *Client) RandomCookie(site string) (cookiepool.CookieList, error) {
+	q := url.Values{"format": {cookiepool.FormatJSON}}
+	_, body, err := c.do(http.MethodGet, c.CookieURL, "/"+site+"/random", q)
+	if err != nil {
+		return nil, err
+	}
+	cl := cookiepool.CookieList{}
+	if err := cl.Decode(body); err != nil {
+		return nil, fmt.Errorf("decode cookies failed: %v", err)
+	}
+	return cl, nil
+}
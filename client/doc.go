@@ -0,0 +1,7 @@
+// 代理池和Cookie池web接口的客户端:
+// 客户端、重试和错误 - client.go
+// 代理接口和本地缓存 - proxy.go
+// Cookies签出和归还 - cookie.go
+// 自动使用代理和Cookies的http.RoundTripper - transport.go
+
+package client
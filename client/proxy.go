@@ -0,0 +1,129 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"gospider/proxypool"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 获取代理的条件
+type ProxyFilter struct {
+	MinScore float64 // 最低分数
+}
+
+// 一批缓存的代理
+type proxyCache struct {
+	proxies []string
+	expires time.Time
+}
+
+func (c *Client) cacheSize() int {
+	if c.CacheSize > 0 {
+		return c.CacheSize
+	}
+	return 10
+}
+
+func (c *Client) cacheTTL() time.Duration {
+	if c.CacheTTL > 0 {
+		return c.CacheTTL
+	}
+	return 30 * time.Second
+}
+
+// 从代理池获取最多n个代理及其分数
+func (c *Client) Proxies(n int, f ProxyFilter) ([]proxypool.ProxyScore, error) {
+	q := url.Values{"n": {strconv.Itoa(n)}}
+	if f.MinScore > 0 {
+		q.Set("min_score", strconv.FormatFloat(f.MinScore, 'f', -1, 64))
+	}
+	_, body, err := c.do(http.MethodGet, c.ProxyURL, "/proxies", q)
+	if err != nil {
+		return nil, err
+	}
+	var proxies []proxypool.ProxyScore
+	if err := json.Unmarshal(body, &proxies); err != nil {
+		return nil, fmt.Errorf("decode proxies failed: %v", err)
+	}
+	return proxies, nil
+}
+
+// 随机获取符合条件的代理。代理按批获取并缓存在本地，
+// 同一批中的每个代理只返回一次，用完或者过期后重新获取
+func (c *Client) RandomProxy(f ProxyFilter) (string, error) {
+	if proxy, ok := c.cachedProxy(f); ok {
+		return proxy, nil
+	}
+	// 获取代理时不持有锁，不阻塞其他条件的代理和Report
+	proxies, err := c.Proxies(c.cacheSize(), f)
+	if err != nil {
+		return "", err
+	}
+	if len(proxies) == 0 {
+		return "", fmt.Errorf("no proxy in the pool")
+	}
+	pc := &proxyCache{expires: time.Now().Add(c.cacheTTL())}
+	for _, p := range proxies {
+		pc.proxies = append(pc.proxies, p.Proxy)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		c.cache = map[ProxyFilter]*proxyCache{}
+	}
+	c.cache[f] = pc
+	return pc.pop(), nil
+}
+
+// 从缓存中取出一个代理，缓存为空或者过期时返回false
+func (c *Client) cachedProxy(f ProxyFilter) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pc := c.cache[f]
+	if pc == nil || len(pc.proxies) == 0 || time.Now().After(pc.expires) {
+		return "", false
+	}
+	return pc.pop(), true
+}
+
+// 随机取出一个代理
+func (pc *proxyCache) pop() string {
+	i := rand.Intn(len(pc.proxies))
+	proxy := pc.proxies[i]
+	pc.proxies = append(pc.proxies[:i], pc.proxies[i+1:]...)
+	return proxy
+}
+
+// 报告代理的使用结果，不可用的代理同时从本地缓存中删除
+func (c *Client) Report(proxy string, ok bool) error {
+	if !ok {
+		c.mu.Lock()
+		for _, pc := range c.cache {
+			for i, p := range pc.proxies {
+				if p == proxy {
+					pc.proxies = append(pc.proxies[:i], pc.proxies[i+1:]...)
+					break
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+	q := url.Values{"proxy": {proxy}, "ok": {strconv.FormatBool(ok)}}
+	_, _, err := c.do(http.MethodPost, c.ProxyURL, "/report", q)
+	return err
+}
+
+// 代理池中的代理数目
+func (c *Client) CountProxy() (int64, error) {
+	_, body, err := c.do(http.MethodGet, c.ProxyURL, "/count", nil)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(body), 10, 64)
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"gospider/internal/proxyutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 自动为请求使用代理池中的代理和Cookie池中的Cookies的http.RoundTripper。
+// 签出的Cookies在租约快到期时自动续签；如果登录时使用了代理，请求使用同一个代理。
+// 代理连接失败时报告给代理池，并换一个代理重试
+type Transport struct {
+	Client *Client
+
+	Site         string        // 非空时为请求添加该网站的Cookies
+	LeaseTTL     time.Duration // 签出Cookies的租约时间，默认为5分钟
+	Filter       ProxyFilter   // 获取代理的条件
+	DisableProxy bool          // 不使用代理池的代理
+	MaxRetries   int           // 代理连接失败时换代理重试的次数，默认为2，负数时不重试
+
+	mu         sync.Mutex
+	lease      *Lease
+	transports proxyutil.TransportCache
+}
+
+func (t *Transport) leaseTTL() time.Duration {
+	if t.LeaseTTL > 0 {
+		return t.LeaseTTL
+	}
+	return 5 * time.Minute
+}
+
+func (t *Transport) maxRetries() int {
+	if t.MaxRetries == 0 {
+		return 2
+	}
+	if t.MaxRetries < 0 {
+		return 0
+	}
+	return t.MaxRetries
+}
+
+// 当前的租约，快到期时归还并重新签出
+func (t *Transport) currentLease() (*Lease, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lease != nil && !t.lease.ExpiresWithin(10*time.Second) {
+		return t.lease, nil
+	}
+	if t.lease != nil {
+		t.Client.Release(t.lease)
+		t.lease = nil
+	}
+	l, err := t.Client.CheckoutCookie(t.Site, t.leaseTTL())
+	if err != nil {
+		return nil, err
+	}
+	t.lease = l
+	return l, nil
+}
+
+// 每个代理使用一个Transport，复用到该代理的连接。不使用代理时直接连接
+func (t *Transport) transport(proxy string) (http.RoundTripper, error) {
+	if proxy == "" {
+		return http.DefaultTransport, nil
+	}
+	return t.transports.Get(proxy)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var lease *Lease
+	if t.Site != "" {
+		l, err := t.currentLease()
+		if err != nil {
+			return nil, err
+		}
+		lease = l
+	}
+
+	retries := t.maxRetries()
+	// 请求正文无法重新读取时不重试
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		retries = 0
+	}
+
+	var err error
+	for i := 0; i <= retries; i++ {
+		var proxy string
+		pooled := false
+		switch {
+		case lease != nil && lease.Proxy != "":
+			proxy = lease.Proxy
+		case !t.DisableProxy:
+			if proxy, err = t.Client.RandomProxy(t.Filter); err != nil {
+				return nil, err
+			}
+			pooled = true
+		}
+
+		r := req.Clone(req.Context())
+		if i > 0 && req.GetBody != nil {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		if lease != nil {
+			for _, c := range lease.Cookies {
+				r.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+			}
+		}
+
+		tr, err2 := t.transport(proxy)
+		if err2 != nil {
+			return nil, err2
+		}
+		var resp *http.Response
+		resp, err = tr.RoundTrip(r)
+		if err == nil {
+			return resp, nil
+		}
+		if !pooled {
+			return nil, err
+		}
+		t.Client.Report(proxy, false)
+		t.transports.Drop(proxy)
+	}
+	return nil, err
+}
+
+// 归还租约并关闭空闲连接
+func (t *Transport) Close() error {
+	t.transports.CloseIdleConnections()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lease == nil {
+		return nil
+	}
+	err := t.Client.Release(t.lease)
+	t.lease = nil
+	return err
+}
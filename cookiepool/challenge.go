@@ -0,0 +1,209 @@
+package cookiepool
+
+// 登录过程中遇到验证码或者二次验证(短信、TOTP)时，LoginFunc返回StatusNeedsCaptcha
+// 或StatusNeedsOTP以及一个Challenge。Conn先尝试使用Solver自动解决，失败时将其放入
+// 人工处理队列，由人通过web接口提交答案后继续登录。在此期间调度器不会重复登录该账号。
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 人工处理队列中的挑战的有效时间，超时后登录会话通常已经失效
+const ChallengeTTL = 10 * time.Minute
+
+// 需要人工处理
+var ErrManual = errors.New("challenge needs manual resolution")
+
+type Challenge struct {
+	ID       string    `json:"id"`
+	Username string    `json:"username"`
+	Status   int       `json:"status"`           // StatusNeedsCaptcha或StatusNeedsOTP
+	Prompt   string    `json:"prompt,omitempty"` // 提示信息，例如短信发送的手机号
+	Image    []byte    `json:"image,omitempty"`  // 验证码图片
+	Created  time.Time `json:"created"`
+
+	// 提交答案后继续登录，返回新的登录状态
+	Resume func(answer string) *LoginState `json:"-"`
+
+	proxy string // 登录使用的代理
+}
+
+// 自动解决挑战，无法解决时返回错误
+type Solver interface {
+	Solve(ch *Challenge) (string, error)
+}
+
+type SolverFunc func(ch *Challenge) (string, error)
+
+func (f SolverFunc) Solve(ch *Challenge) (string, error) {
+	return f(ch)
+}
+
+// 使用账号附加信息中的OTPSecret生成TOTP
+type TOTPSolver struct {
+	Storage *Storage
+}
+
+func (s TOTPSolver) Solve(ch *Challenge) (string, error) {
+	if ch.Status != StatusNeedsOTP {
+		return "", ErrManual
+	}
+	meta, err := s.Storage.GetMeta(ch.Username)
+	if err != nil {
+		return "", err
+	}
+	if meta.OTPSecret == "" {
+		return "", ErrManual
+	}
+	return TOTP(meta.OTPSecret, time.Now())
+}
+
+// 根据RFC 6238生成6位的TOTP，secret为base32编码，时间步长为30秒
+func TOTP(secret string, t time.Time) (string, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return "", fmt.Errorf("decode otp secret failed: %v", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(t.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
+
+// 等待人工处理的挑战，零值可以直接使用
+type ChallengeQueue struct {
+	mu         sync.Mutex
+	challenges map[string]*Challenge
+}
+
+func (q *ChallengeQueue) Add(ch *Challenge) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.challenges == nil {
+		q.challenges = map[string]*Challenge{}
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	ch.ID = hex.EncodeToString(b)
+	if ch.Created.IsZero() {
+		ch.Created = time.Now()
+	}
+	q.challenges[ch.ID] = ch
+	return ch.ID
+}
+
+// 删除超时的挑战
+func (q *ChallengeQueue) expire() {
+	for id, ch := range q.challenges {
+		if time.Since(ch.Created) > ChallengeTTL {
+			delete(q.challenges, id)
+		}
+	}
+}
+
+// 按照创建时间排序的所有挑战
+func (q *ChallengeQueue) List() []*Challenge {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+	chs := make([]*Challenge, 0, len(q.challenges))
+	for _, ch := range q.challenges {
+		chs = append(chs, ch)
+	}
+	sort.Slice(chs, func(i, j int) bool { return chs[i].Created.Before(chs[j].Created) })
+	return chs
+}
+
+// 取出挑战，挑战不存在或者已经超时时返回false
+func (q *ChallengeQueue) Take(id string) (*Challenge, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+	ch, ok := q.challenges[id]
+	delete(q.challenges, id)
+	return ch, ok
+}
+
+// 账号是否有等待处理的挑战
+func (q *ChallengeQueue) Pending(username string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+	for _, ch := range q.challenges {
+		if ch.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// 最多自动解决的轮数，防止网站不断返回新的挑战
+const maxSolveRounds = 3
+
+// 尝试自动解决挑战，无法解决时放入人工处理队列
+func (conn *Conn) solve(username, proxy string, state *LoginState) *LoginState {
+	solver := conn.Solver
+	if solver == nil {
+		solver = TOTPSolver{Storage: conn.Storage}
+	}
+
+	for i := 0; needsChallenge(state); i++ {
+		ch := state.Challenge
+		ch.Username = username
+		ch.Status = state.Status
+		ch.proxy = proxy
+
+		if i >= maxSolveRounds {
+			conn.Challenges.Add(ch)
+			return state
+		}
+		answer, err := solver.Solve(ch)
+		if err != nil {
+			conn.Challenges.Add(ch)
+			return state
+		}
+		state = ch.Resume(answer)
+	}
+	return state
+}
+
+// 提交人工处理的答案并继续登录
+func (conn *Conn) Resolve(id, answer string) (*LoginState, error) {
+	ch, ok := conn.Challenges.Take(id)
+	if !ok {
+		return nil, fmt.Errorf("no challenge for id: %s", id)
+	}
+	state := ch.Resume(answer)
+	if needsChallenge(state) {
+		state.Challenge.Username = ch.Username
+		state.Challenge.Status = state.Status
+		state.Challenge.proxy = ch.proxy
+		conn.Challenges.Add(state.Challenge)
+	}
+	conn.save(ch.Username, ch.proxy, state)
+	conn.record(ch.Username, state)
+	return state, nil
+}
+
+func needsChallenge(state *LoginState) bool {
+	return (state.Status == StatusNeedsCaptcha || state.Status == StatusNeedsOTP) &&
+		state.Challenge != nil && state.Challenge.Resume != nil
+}
@@ -0,0 +1,88 @@
+package cookiepool_test
+
+import (
+	"gospider/cookiepool"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238附录B的测试向量，取后6位
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, test := range tests {
+		code, err := cookiepool.TOTP(secret, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTP failed: %v\n", err)
+		}
+		if code != test.code {
+			t.Fatalf("TOTP failed: time(%d) expect(%s) get(%s)\n", test.unix, test.code, code)
+		}
+	}
+	if _, err := cookiepool.TOTP("not base32!", time.Now()); err == nil {
+		t.Fatalf("TOTP failed: expect error for invalid secret\n")
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	var answers []string
+	// 先需要验证码，然后需要短信验证码
+	loginfn := func(usr, auth string) *cookiepool.LoginState {
+		return &cookiepool.LoginState{
+			Status: cookiepool.StatusNeedsCaptcha,
+			Challenge: &cookiepool.Challenge{Resume: func(answer string) *cookiepool.LoginState {
+				answers = append(answers, answer)
+				return &cookiepool.LoginState{
+					Status: cookiepool.StatusNeedsOTP,
+					Challenge: &cookiepool.Challenge{Prompt: "138****0000", Resume: func(answer string) *cookiepool.LoginState {
+						answers = append(answers, answer)
+						return &cookiepool.LoginState{Status: cookiepool.StatusLoginFailed}
+					}},
+				}
+			}},
+		}
+	}
+	conn := &cookiepool.Conn{
+		LoginFunc: loginfn,
+		Solver: cookiepool.SolverFunc(func(ch *cookiepool.Challenge) (string, error) {
+			if ch.Status == cookiepool.StatusNeedsCaptcha {
+				return "ab12", nil
+			}
+			return "", cookiepool.ErrManual
+		}),
+	}
+
+	state := conn.Login("usr", "pwd")
+	if state.Status != cookiepool.StatusNeedsOTP {
+		t.Fatalf("Login failed: expect(%s) get(%s)\n", cookiepool.StatusText(cookiepool.StatusNeedsOTP), cookiepool.StatusText(state.Status))
+	}
+	if !conn.Challenges.Pending("usr") {
+		t.Fatalf("Login failed: expect a pending challenge for usr\n")
+	}
+	chs := conn.Challenges.List()
+	if len(chs) != 1 || chs[0].Username != "usr" || chs[0].Prompt != "138****0000" {
+		t.Fatalf("Login failed: unexpected challenges %+v\n", chs)
+	}
+
+	if _, err := conn.Resolve("unknown", "1234"); err == nil {
+		t.Fatalf("Resolve failed: expect error for unknown challenge\n")
+	}
+	state, err := conn.Resolve(chs[0].ID, "123456")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v\n", err)
+	}
+	if state.Status != cookiepool.StatusLoginFailed || conn.Challenges.Pending("usr") {
+		t.Fatalf("Resolve failed: expect(%s) get(%s)\n", cookiepool.StatusText(cookiepool.StatusLoginFailed), cookiepool.StatusText(state.Status))
+	}
+	if len(answers) != 2 || answers[0] != "ab12" || answers[1] != "123456" {
+		t.Fatalf("Resolve failed: unexpected answers %v\n", answers)
+	}
+}
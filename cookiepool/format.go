@@ -0,0 +1,317 @@
+package cookiepool
+
+// Cookies的多种输出格式，满足不同使用者的需要：
+//   json         - CookieList.Encode生成的[]*http.Cookie的JSON，即存储格式
+//   header       - 可以直接使用的"Cookie"请求头，例如"a=1; b=2"
+//   netscape     - Netscape的cookies.txt，curl和wget等工具使用
+//   storagestate - Playwright/Puppeteer的storageState JSON
+//   map          - 名称到值的JSON映射
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatJSON         = "json"
+	FormatHeader       = "header"
+	FormatNetscape     = "netscape"
+	FormatStorageState = "storagestate"
+	FormatMap          = "map"
+)
+
+// 各个格式对应的Content-Type，storagestate和map都是JSON，netscape是纯文本
+var formatContentTypes = map[string]string{
+	FormatJSON:         "application/json",
+	FormatHeader:       "text/plain",
+	FormatNetscape:     "text/plain",
+	FormatStorageState: "application/json",
+	FormatMap:          "application/json",
+}
+
+// Accept请求头只能区分JSON和纯文本，分别对应json和header格式，其他格式需要使用参数format
+var acceptFormats = []string{FormatJSON, FormatHeader}
+
+func FormatContentType(format string) string {
+	if ct, ok := formatContentTypes[format]; ok {
+		return ct + "; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// 根据Accept请求头选择格式，没有匹配的格式时返回空字符串
+func FormatFromAccept(accept string) string {
+	for _, mt := range strings.Split(accept, ",") {
+		mt = strings.TrimSpace(strings.SplitN(mt, ";", 2)[0])
+		for _, format := range acceptFormats {
+			if strings.EqualFold(mt, formatContentTypes[format]) {
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+// 为没有设置Domain的Cookie设置Domain，netscape和storagestate格式需要Domain
+func (cl CookieList) WithDomain(domain string) CookieList {
+	ncl := make(CookieList, 0, len(cl))
+	for _, c := range cl {
+		if c.Domain == "" {
+			nc := *c
+			nc.Domain = domain
+			c = &nc
+		}
+		ncl = append(ncl, c)
+	}
+	return ncl
+}
+
+// "Cookie"请求头
+func (cl CookieList) Header() string {
+	pairs := make([]string, 0, len(cl))
+	for _, c := range cl {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func (cl CookieList) Map() map[string]string {
+	m := make(map[string]string, len(cl))
+	for _, c := range cl {
+		m[c.Name] = c.Value
+	}
+	return m
+}
+
+// Netscape的cookies.txt格式
+func (cl CookieList) Netscape() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("# Netscape HTTP Cookie File\n")
+	for _, c := range cl {
+		domain := c.Domain
+		if c.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		path := c.Path
+		if path == "" {
+			path = "/"
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(strings.HasPrefix(c.Domain, ".")), path,
+			netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+	return buf.Bytes()
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+type storageStateCookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires"` // 单位为秒，-1表示会话Cookie
+	HttpOnly bool    `json:"httpOnly"`
+	Secure   bool    `json:"secure"`
+	SameSite string  `json:"sameSite"`
+}
+
+type storageState struct {
+	Cookies []storageStateCookie `json:"cookies"`
+	Origins []json.RawMessage    `json:"origins"`
+}
+
+// Playwright/Puppeteer的storageState格式
+func (cl CookieList) StorageState() ([]byte, error) {
+	state := storageState{
+		Cookies: make([]storageStateCookie, 0, len(cl)),
+		Origins: []json.RawMessage{},
+	}
+	for _, c := range cl {
+		sc := storageStateCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  -1,
+			HttpOnly: c.HttpOnly,
+			Secure:   c.Secure,
+			SameSite: "Lax",
+		}
+		if sc.Path == "" {
+			sc.Path = "/"
+		}
+		if !c.Expires.IsZero() {
+			sc.Expires = float64(c.Expires.Unix())
+		}
+		switch c.SameSite {
+		case http.SameSiteStrictMode:
+			sc.SameSite = "Strict"
+		case http.SameSiteNoneMode:
+			sc.SameSite = "None"
+		}
+		state.Cookies = append(state.Cookies, sc)
+	}
+	return json.Marshal(state)
+}
+
+// 按照给定的格式编码
+func (cl CookieList) EncodeFormat(format string) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		return cl.Encode()
+	case FormatHeader:
+		return []byte(cl.Header()), nil
+	case FormatNetscape:
+		return cl.Netscape(), nil
+	case FormatStorageState:
+		return cl.StorageState()
+	case FormatMap:
+		return json.Marshal(cl.Map())
+	}
+	return nil, fmt.Errorf("unknown cookie format: %s", format)
+}
+
+// 按照给定的格式解码
+func (cl *CookieList) DecodeFormat(format string, data []byte) error {
+	switch format {
+	case FormatJSON, "":
+		return cl.Decode(data)
+	case FormatHeader:
+		return cl.DecodeHeader(string(data))
+	case FormatNetscape:
+		return cl.DecodeNetscape(data)
+	case FormatStorageState:
+		return cl.DecodeStorageState(data)
+	case FormatMap:
+		m := map[string]string{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		cl.DecodeMap(m)
+		return nil
+	}
+	return fmt.Errorf("unknown cookie format: %s", format)
+}
+
+func (cl *CookieList) DecodeHeader(header string) error {
+	header = strings.TrimSpace(header)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Cookie:") {
+		header = strings.TrimSpace(header[7:])
+	}
+	req := &http.Request{Header: http.Header{"Cookie": {header}}}
+	cookies := req.Cookies()
+	if len(cookies) == 0 {
+		return fmt.Errorf("no cookie in header: %s", header)
+	}
+	*cl = cookies
+	return nil
+}
+
+func (cl *CookieList) DecodeNetscape(data []byte) error {
+	var cookies CookieList
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		httponly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httponly = true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return fmt.Errorf("invalid netscape cookie at line %d: %s", n, line)
+		}
+		c := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    strings.Join(fields[6:], "\t"),
+			HttpOnly: httponly,
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid netscape cookie expires at line %d: %v", n, err)
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	*cl = cookies
+	return nil
+}
+
+func (cl *CookieList) DecodeStorageState(data []byte) error {
+	state := storageState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	cookies := make(CookieList, 0, len(state.Cookies))
+	for _, sc := range state.Cookies {
+		c := &http.Cookie{
+			Name:     sc.Name,
+			Value:    sc.Value,
+			Domain:   sc.Domain,
+			Path:     sc.Path,
+			HttpOnly: sc.HttpOnly,
+			Secure:   sc.Secure,
+		}
+		if sc.Expires > 0 {
+			sec, frac := math.Modf(sc.Expires)
+			c.Expires = time.Unix(int64(sec), int64(frac*1e9))
+		}
+		switch strings.ToLower(sc.SameSite) {
+		case "strict":
+			c.SameSite = http.SameSiteStrictMode
+		case "lax":
+			c.SameSite = http.SameSiteLaxMode
+		case "none":
+			c.SameSite = http.SameSiteNoneMode
+		}
+		cookies = append(cookies, c)
+	}
+	*cl = cookies
+	return nil
+}
+
+func (cl *CookieList) DecodeMap(m map[string]string) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cookies := make(CookieList, 0, len(m))
+	for _, name := range names {
+		cookies = append(cookies, &http.Cookie{Name: name, Value: m[name]})
+	}
+	*cl = cookies
+}
@@ -0,0 +1,63 @@
+package cookiepool_test
+
+import (
+	"gospider/cookiepool"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCookieFormat(t *testing.T) {
+	expires := time.Unix(1893456000, 0)
+	cl := cookiepool.CookieList{
+		{Name: "session", Value: "abc", Domain: ".example.com", Path: "/", Expires: expires, HttpOnly: true, Secure: true},
+		{Name: "lang", Value: "zh-CN", Domain: "example.com", Path: "/", SameSite: http.SameSiteStrictMode},
+	}
+
+	if h := cl.Header(); h != "session=abc; lang=zh-CN" {
+		t.Fatalf("Header failed: get %s\n", h)
+	}
+
+	formats := []string{
+		cookiepool.FormatJSON,
+		cookiepool.FormatHeader,
+		cookiepool.FormatNetscape,
+		cookiepool.FormatStorageState,
+		cookiepool.FormatMap,
+	}
+	for _, format := range formats {
+		b, err := cl.EncodeFormat(format)
+		if err != nil {
+			t.Fatalf("Encode failed: format(%s) %v\n", format, err)
+		}
+		ncl := cookiepool.CookieList{}
+		if err := ncl.DecodeFormat(format, b); err != nil {
+			t.Fatalf("Decode failed: format(%s) %v\n", format, err)
+		}
+		if len(ncl) != len(cl) {
+			t.Fatalf("Decode failed: format(%s) expect %d cookies, get %d\n", format, len(cl), len(ncl))
+		}
+		m := ncl.Map()
+		for _, c := range cl {
+			if m[c.Name] != c.Value {
+				t.Fatalf("Decode failed: format(%s) cookie(%s) expect(%s) get(%s)\n", format, c.Name, c.Value, m[c.Name])
+			}
+		}
+		switch format {
+		case cookiepool.FormatNetscape, cookiepool.FormatStorageState:
+			if ncl[0].Domain != cl[0].Domain || !ncl[0].Expires.Equal(expires) || !ncl[0].Secure || !ncl[0].HttpOnly {
+				t.Fatalf("Decode failed: format(%s) attributes of %+v are lost\n", format, ncl[0])
+			}
+		}
+	}
+
+	if format := cookiepool.FormatFromAccept("text/html, text/plain;q=0.9"); format != cookiepool.FormatHeader {
+		t.Fatalf("FormatFromAccept failed: expect %s, get %s\n", cookiepool.FormatHeader, format)
+	}
+	if format := cookiepool.FormatFromAccept("application/json"); format != cookiepool.FormatJSON {
+		t.Fatalf("FormatFromAccept failed: expect %s, get %s\n", cookiepool.FormatJSON, format)
+	}
+	if ct := cookiepool.FormatContentType(cookiepool.FormatMap); ct != "application/json; charset=utf-8" {
+		t.Fatalf("FormatContentType failed: get %s\n", ct)
+	}
+}
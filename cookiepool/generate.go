@@ -2,7 +2,11 @@ package cookiepool
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 )
 
 type CookieList []*http.Cookie
@@ -27,11 +31,31 @@ const (
 	StatusPasswordERR = iota
 	StatusLoginFailed
 	StatusLoginSuccessful
+	StatusNeedsCaptcha // 需要验证码，LoginState.Challenge不能为nil
+	StatusNeedsOTP     // 需要短信或者TOTP等二次验证，LoginState.Challenge不能为nil
 )
 
+// 登录状态的文字描述
+func StatusText(status int) string {
+	switch status {
+	case StatusPasswordERR:
+		return "password error"
+	case StatusLoginFailed:
+		return "login failed"
+	case StatusLoginSuccessful:
+		return "login successful"
+	case StatusNeedsCaptcha:
+		return "needs captcha"
+	case StatusNeedsOTP:
+		return "needs otp"
+	}
+	return "unknown"
+}
+
 type LoginState struct {
 	CookieList CookieList
-	Status     int // status必须为上述状态
+	Status     int        // status必须为上述状态
+	Challenge  *Challenge // 需要验证码或者二次验证时，用于继续登录
 }
 
 type LoginFunc func(usr, auth string) *LoginState
@@ -44,6 +68,176 @@ type Conn struct {
 	URL       string
 	Storage   *Storage
 	LoginFunc LoginFunc
+	Validator Validator // 为nil时要求URL返回http.StatusOK
+
+	// 设置ProxySource后，登录和验证通过其中的代理进行。ProxyLoginFunc不为nil时
+	// 代替LoginFunc登录，登录使用的代理会记录在账号的附加信息中
+	ProxySource    ProxySource
+	ProxyLoginFunc ProxyLoginFunc
+
+	// 自动解决验证码和二次验证，为nil时使用TOTPSolver。无法解决的挑战放入Challenges等待人工处理
+	Solver     Solver
+	Challenges ChallengeQueue
+
+	Retry       *RetryPolicy  // 登录失败的重试策略，为nil时使用DefaultRetryPolicy
+	Concurrency int           // 同时登录的账号数目，默认为1
+	Interval    time.Duration // 相邻两次登录的时间间隔，默认为1秒
+	MinCookies  int           // 有效Cookies数目的下限，低于该值时立即登录，0表示不检查
+
+	retries  retryStates
+	queue    *workQueue             // 调度器的工作队列
+	timers   map[string]*time.Timer // Cookies过期计时器
+	timersMu sync.Mutex
+
+	namespaces map[string]*Conn // 各个命名空间的Conn
+	nsMu       sync.Mutex
+
+	loginLimit *loginLimiter // 登录限制，见beginLogin
+	limitMu    sync.Mutex
+}
+
+// 返回使用命名空间ns的存储的Conn，其余设置与conn相同。ns为空时返回conn
+func (conn *Conn) Namespace(ns string) *Conn {
+	if ns == "" {
+		return conn
+	}
+	conn.nsMu.Lock()
+	defer conn.nsMu.Unlock()
+	if c, ok := conn.namespaces[ns]; ok {
+		return c
+	}
+	if conn.namespaces == nil {
+		conn.namespaces = map[string]*Conn{}
+	}
+	c := &Conn{
+		URL:            conn.URL,
+		Storage:        conn.Storage.Namespace(ns),
+		LoginFunc:      conn.LoginFunc,
+		Validator:      conn.Validator,
+		ProxySource:    conn.ProxySource,
+		ProxyLoginFunc: conn.ProxyLoginFunc,
+		Solver:         conn.Solver,
+		Retry:          conn.Retry,
+		Concurrency:    conn.Concurrency,
+		Interval:       conn.Interval,
+		MinCookies:     conn.MinCookies,
+		queue:          conn.queue,
+		loginLimit:     conn.limiter(),
+	}
+	conn.namespaces[ns] = c
+	return c
+}
+
+// 验证Cookies是否仍然有效，proxy为空时从ProxySource中获取代理。
+// 无法获取代理或者请求失败时返回TransportError，此时Cookies的有效性未知
+func (conn *Conn) Validate(cookies CookieList, proxy string) (bool, error) {
+	v := conn.Validator
+	if v == nil {
+		v = StatusCode(http.StatusOK)
+	}
+	if proxy == "" {
+		p, err := conn.proxy()
+		if err != nil {
+			return false, &TransportError{Err: fmt.Errorf("get proxy failed: %v", err)}
+		}
+		proxy = p
+	}
+	c, err := NewProxyClient(proxy)
+	if err != nil {
+		return false, &TransportError{Proxy: proxy, Err: err}
+	}
+	ok, err := Validate(c, conn.URL, []*http.Cookie(cookies), v)
+	if te, isTransport := err.(*TransportError); isTransport {
+		te.Proxy = proxy
+	}
+	return ok, err
+}
+
+// 使用账号登录并保存结果。密码错误时删除账号，登录成功时保存Cookies
+func (conn *Conn) Login(username, auth string) *LoginState {
+	var proxy string
+	var state *LoginState
+	if conn.ProxyLoginFunc != nil {
+		p, err := conn.proxy()
+		if err != nil {
+			log.Printf("get proxy failed: %v\n", err)
+			return &LoginState{Status: StatusLoginFailed}
+		}
+		proxy = p
+		state = conn.ProxyLoginFunc.Login(username, auth, proxy)
+	} else {
+		state = conn.LoginFunc.Login(username, auth)
+	}
+
+	state = conn.solve(username, proxy, state)
+	conn.save(username, proxy, state)
+	conn.record(username, state)
+	return state
+}
+
+// 保存登录结果
+func (conn *Conn) save(username, proxy string, state *LoginState) {
+	switch state.Status {
+	case StatusPasswordERR:
+		conn.Storage.DeleteAccount(username)
+	case StatusLoginSuccessful:
+		if s, err := state.CookieList.WriteToString(); err == nil {
+			if meta, err := conn.Storage.GetMeta(username); err == nil && meta.Proxy != proxy {
+				meta.Proxy = proxy
+				conn.Storage.SetMeta(username, meta)
+			}
+			conn.Storage.SetCookie(username, s)
+			conn.watchExpiry(username, state.CookieList)
+		}
+	}
+}
+
+// 验证账号的Cookies，无效时删除Cookies。TransportError时无法判断，保留Cookies
+func (conn *Conn) ValidCookie(username string) (bool, error) {
+	c, err := conn.Storage.GetCookie(username)
+	if err != nil {
+		return false, err
+	}
+	cl := CookieList{}
+	if err := cl.Decode([]byte(c)); err != nil {
+		conn.DropCookie(username)
+		return false, fmt.Errorf("decode cookies failed: %v", err)
+	}
+	meta, err := conn.Storage.GetMeta(username)
+	if err != nil {
+		return false, err
+	}
+	b, err := conn.Validate(cl, meta.Proxy)
+	if !b && !IsTransportError(err) {
+		conn.DropCookie(username)
+	}
+	return b, err
+}
+
+// 删除无效的Cookies。手动导入的Cookies无法自动重新登录，需要重新导入
+func (conn *Conn) DropCookie(username string) error {
+	meta, err := conn.Storage.GetMeta(username)
+	if err != nil {
+		return err
+	}
+	if meta.Manual {
+		log.Printf("manual cookies of %s are invalid, please import them again.\n", username)
+		// 只清除导入标记，保留TOTP密钥、代理等其他附加信息
+		meta.Manual = false
+		meta.ImportedAt = 0
+		if err := conn.Storage.SetMeta(username, meta); err != nil {
+			return err
+		}
+	}
+	if err := conn.Storage.DeleteCookie(username); err != nil {
+		return err
+	}
+	if meta.Manual {
+		conn.checkWatermark()
+	} else {
+		conn.invalidated(username)
+	}
+	return nil
 }
 
 type ConnMap map[string]*Conn
@@ -56,7 +250,7 @@ func (c ConnMap) Remove(web string) {
 	delete(c, web)
 }
 
-var defaultConnMap ConnMap
+var defaultConnMap = ConnMap{}
 
 func RegisterStorage(web string, url string, storage *Storage, loginfn LoginFunc) {
 	defaultConnMap.Add(web, url, storage, loginfn)
@@ -0,0 +1,196 @@
+package cookiepool
+
+// 导入手动登录得到的Cookies。除了format.go中的格式外，还支持浏览器插件
+// (EditThisCookie、Cookie-Editor等)导出的JSON和浏览器开发者工具导出的HAR文件。
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	FormatBrowser = "browser"
+	FormatHAR     = "har"
+)
+
+type browserCookie struct {
+	Domain         string  `json:"domain"`
+	ExpirationDate float64 `json:"expirationDate"`
+	HostOnly       bool    `json:"hostOnly"`
+	HttpOnly       bool    `json:"httpOnly"`
+	Name           string  `json:"name"`
+	Path           string  `json:"path"`
+	SameSite       string  `json:"sameSite"`
+	Secure         bool    `json:"secure"`
+	Session        bool    `json:"session"`
+	Value          string  `json:"value"`
+}
+
+// 解析浏览器插件导出的JSON
+func (cl *CookieList) DecodeBrowser(data []byte) error {
+	var bcs []browserCookie
+	if err := json.Unmarshal(data, &bcs); err != nil {
+		return err
+	}
+	cookies := make(CookieList, 0, len(bcs))
+	for _, bc := range bcs {
+		c := &http.Cookie{
+			Name:     bc.Name,
+			Value:    bc.Value,
+			Domain:   bc.Domain,
+			Path:     bc.Path,
+			HttpOnly: bc.HttpOnly,
+			Secure:   bc.Secure,
+		}
+		if !bc.Session && bc.ExpirationDate > 0 {
+			sec, frac := math.Modf(bc.ExpirationDate)
+			c.Expires = time.Unix(int64(sec), int64(frac*1e9))
+		}
+		switch strings.ToLower(bc.SameSite) {
+		case "strict":
+			c.SameSite = http.SameSiteStrictMode
+		case "lax":
+			c.SameSite = http.SameSiteLaxMode
+		case "no_restriction", "none":
+			c.SameSite = http.SameSiteNoneMode
+		}
+		cookies = append(cookies, c)
+	}
+	*cl = cookies
+	return nil
+}
+
+type harCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path"`
+	Domain   string `json:"domain"`
+	Expires  string `json:"expires"`
+	HttpOnly bool   `json:"httpOnly"`
+	Secure   bool   `json:"secure"`
+}
+
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				URL     string      `json:"url"`
+				Cookies []harCookie `json:"cookies"`
+			} `json:"request"`
+			Response struct {
+				Cookies []harCookie `json:"cookies"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// 解析HAR文件，按照请求的顺序收集请求和响应中的Cookies，同名Cookies以最后出现的为准
+func (cl *CookieList) DecodeHAR(data []byte) error {
+	har := harFile{}
+	if err := json.Unmarshal(data, &har); err != nil {
+		return err
+	}
+
+	var cookies CookieList
+	index := map[string]int{}
+	add := func(hc harCookie, domain string) {
+		if hc.Domain != "" {
+			domain = hc.Domain
+		}
+		c := &http.Cookie{
+			Name:     hc.Name,
+			Value:    hc.Value,
+			Domain:   domain,
+			Path:     hc.Path,
+			HttpOnly: hc.HttpOnly,
+			Secure:   hc.Secure,
+		}
+		if hc.Expires != "" {
+			if t, err := time.Parse(time.RFC3339, hc.Expires); err == nil {
+				c.Expires = t
+			}
+		}
+		key := c.Name + "\x00" + c.Domain + "\x00" + c.Path
+		if i, ok := index[key]; ok {
+			cookies[i] = c
+			return
+		}
+		index[key] = len(cookies)
+		cookies = append(cookies, c)
+	}
+
+	for _, entry := range har.Log.Entries {
+		var domain string
+		if u, err := url.Parse(entry.Request.URL); err == nil {
+			domain = u.Hostname()
+		}
+		for _, hc := range entry.Request.Cookies {
+			add(hc, domain)
+		}
+		for _, hc := range entry.Response.Cookies {
+			add(hc, domain)
+		}
+	}
+	if len(cookies) == 0 {
+		return fmt.Errorf("no cookie in har file")
+	}
+	*cl = cookies
+	return nil
+}
+
+// 根据内容猜测Cookies的格式
+func DetectFormat(data []byte) string {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0:
+		return ""
+	case data[0] == '[':
+		// []*http.Cookie编码的字段名首字母大写
+		if bytes.Contains(data, []byte(`"Name"`)) {
+			return FormatJSON
+		}
+		return FormatBrowser
+	case data[0] == '{':
+		if bytes.Contains(data, []byte(`"log"`)) {
+			return FormatHAR
+		}
+		if bytes.Contains(data, []byte(`"cookies"`)) {
+			return FormatStorageState
+		}
+		return FormatMap
+	case bytes.Contains(data, []byte{'\t'}) || bytes.HasPrefix(data, []byte("# Netscape")):
+		return FormatNetscape
+	}
+	return FormatHeader
+}
+
+// 解析导入的Cookies，format为空时自动判断格式
+func ParseCookies(format string, data []byte) (CookieList, error) {
+	if format == "" {
+		format = DetectFormat(data)
+	}
+
+	cl := CookieList{}
+	var err error
+	switch format {
+	case FormatBrowser:
+		err = cl.DecodeBrowser(data)
+	case FormatHAR:
+		err = cl.DecodeHAR(data)
+	default:
+		err = cl.DecodeFormat(format, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse cookies failed: format(%s) %v", format, err)
+	}
+	if len(cl) == 0 {
+		return nil, fmt.Errorf("parse cookies failed: format(%s) no cookie", format)
+	}
+	return cl, nil
+}
@@ -0,0 +1,103 @@
+package cookiepool_test
+
+import (
+	"gospider/cookiepool"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCookies(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+		values map[string]string
+	}{
+		{
+			cookiepool.FormatNetscape,
+			"# Netscape HTTP Cookie File\n" +
+				"#HttpOnly_.example.com\tTRUE\t/\tTRUE\t1893456000\tsession\tabc\n" +
+				"example.com\tFALSE\t/\tFALSE\t0\tlang\tzh-CN\n",
+			map[string]string{"session": "abc", "lang": "zh-CN"},
+		},
+		{
+			cookiepool.FormatBrowser,
+			`[{"domain": ".example.com", "expirationDate": 1893456000.5, "hostOnly": false, "httpOnly": true,
+			   "name": "session", "path": "/", "sameSite": "no_restriction", "secure": true, "session": false, "value": "abc"}]`,
+			map[string]string{"session": "abc"},
+		},
+		{
+			cookiepool.FormatHAR,
+			`{"log": {"entries": [
+				{"request": {"url": "https://example.com/login", "cookies": [{"name": "csrf", "value": "1"}]},
+				 "response": {"cookies": [{"name": "session", "value": "old", "path": "/"}]}},
+				{"request": {"url": "https://example.com/home", "cookies": []},
+				 "response": {"cookies": [{"name": "session", "value": "abc", "path": "/", "expires": "2030-01-01T00:00:00Z"}]}}
+			]}}`,
+			map[string]string{"csrf": "1", "session": "abc"},
+		},
+		{
+			cookiepool.FormatHeader,
+			"Cookie: session=abc; lang=zh-CN",
+			map[string]string{"session": "abc", "lang": "zh-CN"},
+		},
+	}
+
+	for _, test := range tests {
+		if format := cookiepool.DetectFormat([]byte(test.data)); format != test.format {
+			t.Fatalf("DetectFormat failed: expect %s, get %s\n", test.format, format)
+		}
+		cl, err := cookiepool.ParseCookies("", []byte(test.data))
+		if err != nil {
+			t.Fatalf("ParseCookies failed: format(%s) %v\n", test.format, err)
+		}
+		m := cl.Map()
+		if len(m) != len(test.values) {
+			t.Fatalf("ParseCookies failed: format(%s) expect %v, get %v\n", test.format, test.values, m)
+		}
+		for k, v := range test.values {
+			if m[k] != v {
+				t.Fatalf("ParseCookies failed: format(%s) expect %v, get %v\n", test.format, test.values, m)
+			}
+		}
+	}
+
+	if _, err := cookiepool.ParseCookies(cookiepool.FormatHAR, []byte(`{"log": {"entries": []}}`)); err == nil {
+		t.Fatalf("ParseCookies failed: expect error for empty har file\n")
+	}
+}
+
+func TestDropImportedCookie(t *testing.T) {
+	storage, err := cookiepool.NewFileStorage(filepath.Join(t.TempDir(), "cookie.db"), "import_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	const secret = "JBSWY3DPEHPK3PXP"
+	if err := storage.SetMeta("alice", &cookiepool.Meta{OTPSecret: secret, Proxy: "http://1.1.1.1:80"}); err != nil {
+		t.Fatalf("SetMeta failed: %v\n", err)
+	}
+	cl, err := cookiepool.ParseCookies(cookiepool.FormatHeader, []byte("Cookie: session=abc"))
+	if err != nil {
+		t.Fatalf("ParseCookies failed: %v\n", err)
+	}
+	if err := storage.ImportCookies("alice", cl); err != nil {
+		t.Fatalf("ImportCookies failed: %v\n", err)
+	}
+
+	// 删除手动导入的Cookies只清除导入标记
+	conn := &cookiepool.Conn{Storage: storage}
+	if err := conn.DropCookie("alice"); err != nil {
+		t.Fatalf("DropCookie failed: %v\n", err)
+	}
+	if ok, _ := storage.ExistsCookie("alice"); ok {
+		t.Fatalf("DropCookie failed: cookies still exist\n")
+	}
+	meta, err := storage.GetMeta("alice")
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v\n", err)
+	}
+	if meta.Manual || meta.ImportedAt != 0 || meta.OTPSecret != secret || meta.Proxy != "http://1.1.1.1:80" {
+		t.Fatalf("DropCookie failed: get meta %+v\n", meta)
+	}
+}
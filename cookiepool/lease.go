@@ -0,0 +1,119 @@
+package cookiepool
+
+// Cookies的租约。使用者签出(checkout)一份Cookies后，在租约到期或者归还之前，
+// 其他使用者不会签出同一份Cookies，避免同一个账号被并发使用。
+// 租约保存在Redis的Hash中，键为用户名，值为"到期时间(毫秒):租约ID"。
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"gospider/internal/kv"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Lease struct {
+	ID       string    `json:"id"`
+	Username string    `json:"username"`
+	Expires  time.Time `json:"expires"`
+}
+
+// 没有租约或者租约已经到期时设置新的租约
+var acquireScript = kv.NewScript(`
+local v = redis.call('HGET', KEYS[1], ARGV[1])
+if v then
+	local expires = tonumber(string.match(v, '^(%d+):'))
+	if expires and expires > tonumber(ARGV[3]) then
+		return 0
+	end
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return 1
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	v, err := ops.HGet(keys[0], args[0])
+	if err == nil {
+		now, _ := strconv.ParseInt(args[2], 10, 64)
+		if expires, ok := leaseExpires(v); ok && expires > now {
+			return int64(0), nil
+		}
+	}
+	ops.HSet(keys[0], args[0], args[1])
+	return int64(1), nil
+})
+
+// 租约ID匹配时删除租约
+var releaseScript = kv.NewScript(`
+local v = redis.call('HGET', KEYS[1], ARGV[1])
+if v and string.match(v, ':(.*)$') == ARGV[2] then
+	return redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return 0
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	v, err := ops.HGet(keys[0], args[0])
+	if err != nil {
+		return int64(0), nil
+	}
+	if _, id, ok := strings.Cut(v, ":"); !ok || id != args[1] {
+		return int64(0), nil
+	}
+	ops.HDel(keys[0], args[0])
+	return int64(1), nil
+})
+
+// 解析租约的到期时间
+func leaseExpires(v string) (int64, bool) {
+	ms, _, ok := strings.Cut(v, ":")
+	if !ok {
+		return 0, false
+	}
+	expires, err := strconv.ParseInt(ms, 10, 64)
+	return expires, err == nil
+}
+
+func newLeaseID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 随机签出一份没有被租用的Cookies，返回租约和Cookies的JSON字符串
+func (s *Storage) Checkout(ttl time.Duration) (*Lease, string, error) {
+	cs, err := s.getall(s.cookieKey)
+	if err != nil {
+		return nil, "", err
+	}
+	usernames := make([]string, 0, len(cs))
+	for u := range cs {
+		usernames = append(usernames, u)
+	}
+	mrand.Shuffle(len(usernames), func(i, j int) { usernames[i], usernames[j] = usernames[j], usernames[i] })
+
+	now := time.Now()
+	for _, u := range usernames {
+		l := &Lease{ID: newLeaseID(), Username: u, Expires: now.Add(ttl)}
+		v := fmt.Sprintf("%d:%s", l.Expires.UnixMilli(), l.ID)
+		ok, err := kv.Int(s.store.Run(acquireScript, []string{s.leaseKey}, u, v, now.UnixMilli()))
+		if err != nil {
+			return nil, "", err
+		}
+		if ok == 1 {
+			return l, cs[u], nil
+		}
+	}
+	return nil, "", fmt.Errorf("no free cookie for key: %s", s.cookieKey)
+}
+
+// 归还租约，租约不存在或者已经被其他使用者接管时返回错误
+func (s *Storage) Release(username, id string) error {
+	n, err := kv.Int(s.store.Run(releaseScript, []string{s.leaseKey}, username, id))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("no lease %s for username: %s", id, username)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package cookiepool_test
+
+import (
+	"gospider/cookiepool"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookie.db")
+	storage, err := cookiepool.NewFileStorage(path, "lease_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	storage.SetCookie("alice", "[]")
+	l, v, err := storage.Checkout(time.Minute)
+	if err != nil || l.Username != "alice" || v != "[]" {
+		t.Fatalf("Checkout failed: %v %v\n", l, err)
+	}
+	if _, _, err := storage.Checkout(time.Minute); err == nil {
+		t.Fatalf("Checkout failed: leased cookies are checked out again\n")
+	}
+	if err := storage.Release("alice", "other"); err == nil {
+		t.Fatalf("Release failed: wrong lease id is accepted\n")
+	}
+	if err := storage.Release("alice", l.ID); err != nil {
+		t.Fatalf("Release failed: %v\n", err)
+	}
+
+	// 到期的租约可以被接管
+	if _, _, err := storage.Checkout(-time.Second); err != nil {
+		t.Fatalf("Checkout failed: %v\n", err)
+	}
+	l, _, err = storage.Checkout(time.Minute)
+	if err != nil {
+		t.Fatalf("Checkout expired lease failed: %v\n", err)
+	}
+
+	// 重新打开文件后租约仍然有效
+	storage.Close()
+	storage, err = cookiepool.NewFileStorage(path, "lease_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	if err := storage.Release("alice", l.ID); err != nil {
+		t.Fatalf("Release after reopen failed: %v\n", err)
+	}
+}
@@ -0,0 +1,371 @@
+package cookiepool
+
+// 编写LoginFunc的工具。Session使用cookiejar保存登录过程中的Cookies，
+// FormLogin和JSONLogin分别完成表单登录和JSON接口登录，并根据LoginCheck
+// 将结果转换为StatusPasswordERR、StatusLoginFailed和StatusLoginSuccessful。
+//
+//	form := &cookiepool.FormLogin{
+//		URL:           "https://example.com/login",
+//		UserField:     "username",
+//		PassField:     "password",
+//		Success:       cookiepool.CookiePresent("sessionid"),
+//		PasswordError: cookiepool.CheckValidator(cookiepool.BodyContains("密码错误")),
+//	}
+//	conns.Add("example", "https://example.com/home", storage, form.Login)
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gospider"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anaskhan96/soup"
+)
+
+// 登录会话，所有请求都带有gospider.UserAgent，并共享同一个cookiejar
+type Session struct {
+	Client *http.Client
+	Header http.Header // 每个请求都会带有的请求头
+}
+
+// 创建登录会话，proxy为空时直接连接。同一个代理的会话共用Transport
+func NewSession(proxy string) (*Session, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &http.Client{Jar: jar, Timeout: 10 * time.Second}
+	if proxy != "" {
+		tr, err := proxyTransports.Get(proxy)
+		if err != nil {
+			return nil, err
+		}
+		c.Transport = tr
+	}
+	return &Session{Client: c, Header: http.Header{}}, nil
+}
+
+// 发送请求并读取响应正文
+func (s *Session) Do(req *http.Request) (*http.Response, []byte, error) {
+	for k, vs := range s.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", gospider.UserAgent)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
+
+func (s *Session) Get(url string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.Do(req)
+}
+
+func (s *Session) PostForm(url string, data url.Values, header http.Header) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.Do(req)
+}
+
+func (s *Session) PostJSON(url string, v any, header http.Header) (*http.Response, []byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return s.Do(req)
+}
+
+// 会话中发送给rawurl的Cookies。cookiejar不保存Cookie的属性，Domain设为rawurl的主机名
+func (s *Session) Cookies(rawurl string) CookieList {
+	u, err := url.Parse(rawurl)
+	if err != nil || s.Client.Jar == nil {
+		return nil
+	}
+	var cl CookieList
+	for _, c := range s.Client.Jar.Cookies(u) {
+		cl = append(cl, &http.Cookie{Name: c.Name, Value: c.Value, Domain: u.Hostname(), Path: "/"})
+	}
+	return cl
+}
+
+// 登录结果的判断条件
+type LoginCheck func(s *Session, resp *http.Response, body []byte) bool
+
+// 请求被重定向到包含substr的地址
+func RedirectTo(substr string) LoginCheck {
+	return func(s *Session, resp *http.Response, body []byte) bool {
+		if loc := resp.Header.Get("Location"); loc != "" && strings.Contains(loc, substr) {
+			return true
+		}
+		// 跟随重定向时，每个请求的Response为引起该请求的重定向响应
+		for req := resp.Request; req != nil; {
+			if req.Response == nil {
+				break
+			}
+			if strings.Contains(req.URL.String(), substr) {
+				return true
+			}
+			req = req.Response.Request
+		}
+		return false
+	}
+}
+
+// 会话中存在名为name的Cookie
+func CookiePresent(name string) LoginCheck {
+	return func(s *Session, resp *http.Response, body []byte) bool {
+		if s.Client.Jar == nil || resp.Request == nil {
+			return false
+		}
+		for _, c := range s.Client.Jar.Cookies(resp.Request.URL) {
+			if c.Name == name && c.Value != "" {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// 使用验证器判断登录结果，例如CheckValidator(JSONField("code", 0))
+func CheckValidator(v Validator) LoginCheck {
+	return func(s *Session, resp *http.Response, body []byte) bool {
+		ok, _ := v.Validate(resp, body)
+		return ok
+	}
+}
+
+// 根据判断条件生成登录状态，cookieURL为需要保存的Cookies所属的地址
+func (s *Session) State(resp *http.Response, body []byte, success, passwordError LoginCheck, cookieURL string) *LoginState {
+	if passwordError != nil && passwordError(s, resp, body) {
+		return &LoginState{Status: StatusPasswordERR}
+	}
+	if success != nil && success(s, resp, body) {
+		return &LoginState{Status: StatusLoginSuccessful, CookieList: s.Cookies(cookieURL)}
+	}
+	return &LoginState{Status: StatusLoginFailed}
+}
+
+// 解析页面中的表单，返回绝对的提交地址和隐藏字段。formID为空时使用第一个包含密码输入框的表单
+func ParseForm(pageURL, html, formID string) (string, url.Values, error) {
+	doc := soup.HTMLParse(html)
+	if doc.Error != nil {
+		return "", nil, doc.Error
+	}
+
+	var form soup.Root
+	if formID != "" {
+		form = doc.FindStrict("form", "id", formID)
+	} else {
+		for _, f := range doc.FindAll("form") {
+			if f.Find("input", "type", "password").Error == nil {
+				form = f
+				break
+			}
+		}
+		if form.Pointer == nil {
+			form = doc.Find("form")
+		}
+	}
+	if form.Pointer == nil || form.Error != nil {
+		return "", nil, fmt.Errorf("no login form in %s", pageURL)
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", nil, err
+	}
+	action := base
+	if a := strings.TrimSpace(form.Attrs()["action"]); a != "" {
+		ref, err := url.Parse(a)
+		if err != nil {
+			return "", nil, err
+		}
+		action = base.ResolveReference(ref)
+	}
+
+	values := url.Values{}
+	for _, input := range form.FindAll("input") {
+		attrs := input.Attrs()
+		if strings.ToLower(attrs["type"]) == "hidden" && attrs["name"] != "" {
+			values.Set(attrs["name"], attrs["value"])
+		}
+	}
+	return action.String(), values, nil
+}
+
+// 读取页面中<meta name="name" content="...">的CSRF token
+func MetaContent(html, name string) string {
+	doc := soup.HTMLParse(html)
+	if doc.Error != nil {
+		return ""
+	}
+	meta := doc.FindStrict("meta", "name", name)
+	if meta.Error != nil {
+		return ""
+	}
+	return meta.Attrs()["content"]
+}
+
+// 表单登录：获取登录页面，提取隐藏字段和CSRF token，然后提交用户名和密码
+type FormLogin struct {
+	URL       string            // 登录页面
+	Form      string            // 表单的id，为空时使用第一个包含密码输入框的表单
+	Action    string            // 提交地址，为空时使用表单的action
+	UserField string            // 用户名字段
+	PassField string            // 密码字段
+	Fields    map[string]string // 额外的字段
+
+	CSRFMeta   string // 从<meta name=CSRFMeta>中读取CSRF token，例如"csrf-token"
+	CSRFHeader string // CSRF token所在的请求头，例如"X-CSRF-Token"
+
+	CookieURL     string // 需要保存的Cookies所属的地址，为空时使用URL
+	Success       LoginCheck
+	PasswordError LoginCheck
+}
+
+func (f *FormLogin) Login(usr, auth string) *LoginState {
+	return f.LoginProxy(usr, auth, "")
+}
+
+func (f *FormLogin) LoginProxy(usr, auth, proxy string) *LoginState {
+	s, err := NewSession(proxy)
+	if err != nil {
+		log.Printf("create session failed: %v\n", err)
+		return &LoginState{Status: StatusLoginFailed}
+	}
+
+	_, page, err := s.Get(f.URL)
+	if err != nil {
+		log.Printf("get login page failed: %v\n", err)
+		return &LoginState{Status: StatusLoginFailed}
+	}
+	action, values, err := ParseForm(f.URL, string(page), f.Form)
+	if err != nil {
+		log.Printf("parse login form failed: %v\n", err)
+		return &LoginState{Status: StatusLoginFailed}
+	}
+	if f.Action != "" {
+		action = f.Action
+	}
+	for k, v := range f.Fields {
+		values.Set(k, v)
+	}
+	values.Set(f.UserField, usr)
+	values.Set(f.PassField, auth)
+
+	header := http.Header{}
+	header.Set("Referer", f.URL)
+	if f.CSRFMeta != "" && f.CSRFHeader != "" {
+		header.Set(f.CSRFHeader, MetaContent(string(page), f.CSRFMeta))
+	}
+
+	resp, body, err := s.PostForm(action, values, header)
+	if err != nil {
+		log.Printf("post login form failed: %v\n", err)
+		return &LoginState{Status: StatusLoginFailed}
+	}
+
+	cookieURL := f.CookieURL
+	if cookieURL == "" {
+		cookieURL = f.URL
+	}
+	return s.State(resp, body, f.Success, f.PasswordError, cookieURL)
+}
+
+// JSON接口登录：向URL提交包含用户名和密码的JSON
+type JSONLogin struct {
+	URL       string         // 登录接口
+	PageURL   string         // 登录前访问的页面，用于获取初始Cookies和CSRF token，可以为空
+	UserField string         // 用户名字段
+	PassField string         // 密码字段
+	Fields    map[string]any // 额外的字段
+	Header    http.Header    // 额外的请求头
+
+	CSRFMeta   string // 从PageURL页面的<meta name=CSRFMeta>中读取CSRF token
+	CSRFHeader string // CSRF token所在的请求头
+
+	CookieURL     string // 需要保存的Cookies所属的地址，为空时使用URL
+	Success       LoginCheck
+	PasswordError LoginCheck
+}
+
+func (j *JSONLogin) Login(usr, auth string) *LoginState {
+	return j.LoginProxy(usr, auth, "")
+}
+
+func (j *JSONLogin) LoginProxy(usr, auth, proxy string) *LoginState {
+	s, err := NewSession(proxy)
+	if err != nil {
+		log.Printf("create session failed: %v\n", err)
+		return &LoginState{Status: StatusLoginFailed}
+	}
+
+	header := http.Header{}
+	for k, vs := range j.Header {
+		header[k] = vs
+	}
+	if j.PageURL != "" {
+		_, page, err := s.Get(j.PageURL)
+		if err != nil {
+			log.Printf("get login page failed: %v\n", err)
+			return &LoginState{Status: StatusLoginFailed}
+		}
+		if j.CSRFMeta != "" && j.CSRFHeader != "" {
+			header.Set(j.CSRFHeader, MetaContent(string(page), j.CSRFMeta))
+		}
+	}
+
+	payload := map[string]any{}
+	for k, v := range j.Fields {
+		payload[k] = v
+	}
+	payload[j.UserField] = usr
+	payload[j.PassField] = auth
+
+	resp, body, err := s.PostJSON(j.URL, payload, header)
+	if err != nil {
+		log.Printf("post login json failed: %v\n", err)
+		return &LoginState{Status: StatusLoginFailed}
+	}
+
+	cookieURL := j.CookieURL
+	if cookieURL == "" {
+		cookieURL = j.URL
+	}
+	return s.State(resp, body, j.Success, j.PasswordError, cookieURL)
+}
@@ -0,0 +1,104 @@
+package cookiepool_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"gospider/cookiepool"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFormLogin(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "csrftoken", Value: "token"})
+		fmt.Fprint(w, `<html><head><meta name="csrf-token" content="meta-token"></head><body>
+			<form id="search" action="/search"><input name="q"></form>
+			<form action="/auth" method="post">
+				<input type="hidden" name="csrf" value="token">
+				<input name="username"><input type="password" name="password">
+			</form></body></html>`)
+	})
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("csrf") != "token" || r.Header.Get("X-CSRF-Token") != "meta-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.PostFormValue("password") != "pwd" {
+			fmt.Fprint(w, "密码错误")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sessionid", Value: "abc", Path: "/"})
+		http.Redirect(w, r, "/home", http.StatusFound)
+	})
+	mux.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "welcome")
+	})
+	mux.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload["password"] != "pwd" {
+			fmt.Fprint(w, `{"code": 1}`)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "sessionid", Value: "abc", Path: "/"})
+		fmt.Fprint(w, `{"code": 0}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	form := &cookiepool.FormLogin{
+		URL:           server.URL + "/login",
+		UserField:     "username",
+		PassField:     "password",
+		CSRFMeta:      "csrf-token",
+		CSRFHeader:    "X-CSRF-Token",
+		Success:       cookiepool.RedirectTo("/home"),
+		PasswordError: cookiepool.CheckValidator(cookiepool.BodyContains("密码错误")),
+	}
+	api := &cookiepool.JSONLogin{
+		URL:           server.URL + "/api/login",
+		UserField:     "username",
+		PassField:     "password",
+		Success:       cookiepool.CookiePresent("sessionid"),
+		PasswordError: cookiepool.CheckValidator(cookiepool.JSONField("code", 1)),
+	}
+
+	tests := []struct {
+		login  cookiepool.LoginFunc
+		auth   string
+		status int
+	}{
+		{form.Login, "pwd", cookiepool.StatusLoginSuccessful},
+		{form.Login, "wrong", cookiepool.StatusPasswordERR},
+		{api.Login, "pwd", cookiepool.StatusLoginSuccessful},
+		{api.Login, "wrong", cookiepool.StatusPasswordERR},
+	}
+	for i, test := range tests {
+		state := test.login.Login("usr", test.auth)
+		if state.Status != test.status {
+			t.Fatalf("Login failed: case(%d) expect(%s) get(%s)\n", i, cookiepool.StatusText(test.status), cookiepool.StatusText(state.Status))
+		}
+		if state.Status == cookiepool.StatusLoginSuccessful && state.CookieList.Map()["sessionid"] != "abc" {
+			t.Fatalf("Login failed: case(%d) expect cookie sessionid, get %v\n", i, state.CookieList.Map())
+		}
+	}
+
+	form.Success = nil
+	if state := form.Login("usr", "pwd"); state.Status != cookiepool.StatusLoginFailed {
+		t.Fatalf("Login failed: expect(%s) get(%s)\n", cookiepool.StatusText(cookiepool.StatusLoginFailed), cookiepool.StatusText(state.Status))
+	}
+}
+
+func TestSessionTransport(t *testing.T) {
+	// 同一个代理的会话共用Transport，各自使用自己的cookiejar
+	s1, err := cookiepool.NewSession("127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("NewSession failed: %v\n", err)
+	}
+	s2, _ := cookiepool.NewSession("127.0.0.1:1")
+	if s1.Client.Transport != s2.Client.Transport || s1.Client.Jar == s2.Client.Jar {
+		t.Fatalf("NewSession failed: expect shared transport and separate jars\n")
+	}
+}
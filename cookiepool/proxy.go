@@ -0,0 +1,102 @@
+package cookiepool
+
+// 登录和验证可以通过代理池中的代理进行，避免服务器自身的IP被封禁。
+// 登录使用的代理会记录在账号的附加信息中，之后的验证以及Cookies的使用者
+// 都可以使用同一个出口IP。
+
+import (
+	"errors"
+	"fmt"
+	"gospider/internal/proxyutil"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 代理来源，*proxypool.Storage满足该接口
+type ProxySource interface {
+	Random() (string, error)
+}
+
+// 代理池的web接口，例如"http://localhost:8090/random"
+type ProxyAPI string
+
+func (api ProxyAPI) Random() (string, error) {
+	c := &http.Client{Timeout: 5 * time.Second}
+	resp, err := c.Get(string(api))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get proxy from %s failed: status code %d", string(api), resp.StatusCode)
+	}
+	proxy := strings.TrimSpace(string(b))
+	if proxy == "" {
+		return "", fmt.Errorf("get proxy from %s failed: no proxy", string(api))
+	}
+	return proxy, nil
+}
+
+// 使用代理登录，proxy为空时直接连接
+type ProxyLoginFunc func(usr, auth, proxy string) *LoginState
+
+func (f ProxyLoginFunc) Login(usr, auth, proxy string) *LoginState {
+	return f(usr, auth, proxy)
+}
+
+// 登录和验证使用的代理Transport
+var proxyTransports proxyutil.TransportCache
+
+// 创建不跟随重定向的客户端，proxy为空时直接连接。同一个代理的客户端共用Transport
+func NewProxyClient(proxy string) (*http.Client, error) {
+	c := &http.Client{
+		Timeout:       5 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	if proxy == "" {
+		return c, nil
+	}
+	tr, err := proxyTransports.Get(proxy)
+	if err != nil {
+		return nil, err
+	}
+	c.Transport = tr
+	return c, nil
+}
+
+// 从代理来源中获取代理，没有代理来源时返回空字符串
+func (conn *Conn) proxy() (string, error) {
+	if conn.ProxySource == nil {
+		return "", nil
+	}
+	return conn.ProxySource.Random()
+}
+
+// 获取代理或者通过代理连接失败，无法判断Cookies是否有效
+type TransportError struct {
+	Proxy string
+	Err   error
+}
+
+func (e *TransportError) Error() string {
+	if e.Proxy == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("proxy %s: %v", e.Proxy, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// 错误是否为TransportError。此时Cookies的有效性未知，不应删除
+func IsTransportError(err error) bool {
+	var te *TransportError
+	return errors.As(err, &te)
+}
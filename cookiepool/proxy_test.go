@@ -0,0 +1,92 @@
+package cookiepool_test
+
+import (
+	"fmt"
+	"gospider/cookiepool"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProxyValidate(t *testing.T) {
+	var hits int32
+	// 代理服务器收到的请求的URL为完整的目标地址
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.URL.Host != "login.example.com" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if _, err := r.Cookie("session"); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer proxy.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.TrimPrefix(proxy.URL, "http://"))
+	}))
+	defer api.Close()
+
+	conn := &cookiepool.Conn{
+		URL:         "http://login.example.com/home",
+		ProxySource: cookiepool.ProxyAPI(api.URL + "/random"),
+	}
+
+	cl := cookiepool.CookieList{{Name: "session", Value: "abc"}}
+	if ok, err := conn.Validate(cl, ""); !ok {
+		t.Fatalf("Validate through proxy failed: %v\n", err)
+	}
+	if ok, _ := conn.Validate(nil, proxy.URL); ok {
+		t.Fatalf("Validate through proxy failed: expect cookies invalid\n")
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("Validate through proxy failed: expect 2 requests through proxy, get %d\n", n)
+	}
+}
+
+func TestProxyOutage(t *testing.T) {
+	storage, err := cookiepool.NewFileStorage(filepath.Join(t.TempDir(), "cookie.db"), "outage_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	// 代理池不可用
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer api.Close()
+
+	conn := &cookiepool.Conn{
+		URL:         "http://login.example.com/home",
+		Storage:     storage,
+		ProxySource: cookiepool.ProxyAPI(api.URL + "/random"),
+	}
+	storage.SetCookie("usr", `[{"Name":"session","Value":"abc"}]`)
+
+	if _, err := conn.ValidCookie("usr"); !cookiepool.IsTransportError(err) {
+		t.Fatalf("ValidCookie failed: expect transport error, get %v\n", err)
+	}
+	// 登录时使用的代理失效
+	meta, _ := storage.GetMeta("usr")
+	meta.Proxy = "127.0.0.1:1"
+	storage.SetMeta("usr", meta)
+	if _, err := conn.ValidCookie("usr"); !cookiepool.IsTransportError(err) {
+		t.Fatalf("ValidCookie failed: expect transport error for dead proxy, get %v\n", err)
+	}
+	if ok, _ := storage.ExistsCookie("usr"); !ok {
+		t.Fatalf("ValidCookie failed: cookies are deleted when proxies are unavailable\n")
+	}
+
+	// 同一个代理的客户端共用Transport
+	c1, _ := cookiepool.NewProxyClient("127.0.0.1:1")
+	c2, _ := cookiepool.NewProxyClient("127.0.0.1:1")
+	if c1.Transport != c2.Transport {
+		t.Fatalf("NewProxyClient failed: expect shared transport for the same proxy\n")
+	}
+}
@@ -0,0 +1,201 @@
+package cookiepool
+
+// 调度器内部的工作队列。Cookies失效或者过期时立即重新登录对应的账号，
+// 网站有效的Cookies数目低于MinCookies时立即登录没有Cookies的账号，
+// 不必等待下一次LoginCycle。周期性的全量登录仍然作为兜底。
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type taskKind int
+
+const (
+	taskLogin    taskKind = iota // 登录账号
+	taskValidate                 // 验证账号的Cookies
+)
+
+type task struct {
+	conn     *Conn
+	username string
+	kind     taskKind
+}
+
+// 去重的无界队列
+type workQueue struct {
+	mu      sync.Mutex
+	tasks   []task
+	pending map[task]bool
+	notify  chan struct{}
+}
+
+func newWorkQueue() *workQueue {
+	return &workQueue{
+		pending: map[task]bool{},
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+func (q *workQueue) push(t task) {
+	q.mu.Lock()
+	if !q.pending[t] {
+		q.pending[t] = true
+		q.tasks = append(q.tasks, t)
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// 取出任务，abort关闭时返回false
+func (q *workQueue) pop(abort <-chan struct{}) (task, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.tasks) > 0 {
+			t := q.tasks[0]
+			q.tasks = q.tasks[1:]
+			delete(q.pending, t)
+			more := len(q.tasks) > 0
+			q.mu.Unlock()
+			// 唤醒其他等待的worker
+			if more {
+				select {
+				case q.notify <- struct{}{}:
+				default:
+				}
+			}
+			return t, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-abort:
+			return task{}, false
+		case <-q.notify:
+		}
+	}
+}
+
+// 执行任务，登录经过网站的登录限制，abort关闭时放弃等待
+func (t task) run(abort <-chan struct{}) {
+	conn := t.conn
+	switch t.kind {
+	case taskLogin:
+		if !conn.shouldLogin(t.username) {
+			return
+		}
+		auth, err := conn.Storage.GetAccount(t.username)
+		if err != nil {
+			return
+		}
+		if !conn.beginLogin(abort) {
+			return
+		}
+		defer conn.endLogin()
+		// 等待期间可能已经由周期性登录完成
+		if !conn.shouldLogin(t.username) {
+			return
+		}
+		state := conn.Login(t.username, auth)
+		log.Printf("relogin %s: %s\n", t.username, StatusText(state.Status))
+	case taskValidate:
+		if b, err := conn.ValidCookie(t.username); IsTransportError(err) {
+			log.Printf("validate cookies of %s failed: %v\n", t.username, err)
+		} else if !b {
+			log.Printf("cookies of %s are invalid: %v\n", t.username, err)
+		}
+	}
+}
+
+// 将任务放入调度器的工作队列，不在调度器中运行时忽略
+func (conn *Conn) enqueue(kind taskKind, username string) {
+	if conn.queue == nil {
+		return
+	}
+	conn.queue.push(task{conn: conn, username: username, kind: kind})
+}
+
+// Cookies失效后立即重新登录，并检查Cookies数目是否低于下限
+func (conn *Conn) invalidated(username string) {
+	conn.enqueue(taskLogin, username)
+	conn.checkWatermark()
+}
+
+// 有效的Cookies数目低于MinCookies时，登录所有没有Cookies的账号
+func (conn *Conn) checkWatermark() {
+	if conn.queue == nil || conn.MinCookies <= 0 {
+		return
+	}
+	n, err := conn.Storage.CountCookie()
+	if err != nil || int(n) >= conn.MinCookies {
+		return
+	}
+	usernames, err := conn.Storage.Usernames()
+	if err != nil {
+		return
+	}
+	log.Printf("only %d cookies left, below the low watermark %d.\n", n, conn.MinCookies)
+	for _, u := range usernames {
+		if ok, err := conn.Storage.ExistsCookie(u); err == nil && !ok {
+			conn.enqueue(taskLogin, u)
+		}
+	}
+}
+
+// 在Cookies最早过期时验证Cookies
+func (conn *Conn) watchExpiry(username string, cl CookieList) {
+	if conn.queue == nil {
+		return
+	}
+	var expires time.Time
+	for _, c := range cl {
+		if !c.Expires.IsZero() && (expires.IsZero() || c.Expires.Before(expires)) {
+			expires = c.Expires
+		}
+	}
+
+	conn.timersMu.Lock()
+	defer conn.timersMu.Unlock()
+	if t, ok := conn.timers[username]; ok {
+		t.Stop()
+		delete(conn.timers, username)
+	}
+	if expires.IsZero() {
+		return
+	}
+	if conn.timers == nil {
+		conn.timers = map[string]*time.Timer{}
+	}
+	conn.timers[username] = time.AfterFunc(time.Until(expires), func() {
+		conn.timersMu.Lock()
+		delete(conn.timers, username)
+		conn.timersMu.Unlock()
+		conn.enqueue(taskValidate, username)
+	})
+}
+
+// 停止所有的过期计时器
+func (conn *Conn) stopTimers() {
+	conn.timersMu.Lock()
+	defer conn.timersMu.Unlock()
+	for u, t := range conn.timers {
+		t.Stop()
+		delete(conn.timers, u)
+	}
+}
+
+// 删除账号后停止其过期计时器并清除重试状态，避免账号再次加入登录队列
+func (conn *Conn) forget(username string) {
+	conn.timersMu.Lock()
+	if t, ok := conn.timers[username]; ok {
+		t.Stop()
+		delete(conn.timers, username)
+	}
+	conn.timersMu.Unlock()
+	conn.retries.reset(username)
+}
@@ -0,0 +1,124 @@
+package cookiepool
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWorkQueue(t *testing.T) {
+	conn := &Conn{}
+	a := task{conn: conn, username: "a", kind: taskLogin}
+	b := task{conn: conn, username: "b", kind: taskLogin}
+	av := task{conn: conn, username: "a", kind: taskValidate}
+
+	abort := make(chan struct{})
+	q := newWorkQueue()
+
+	// 先进先出，等待中的相同任务只保留一个
+	q.push(a)
+	q.push(b)
+	q.push(a)
+	q.push(av)
+	for _, expect := range []task{a, b, av} {
+		got, ok := q.pop(abort)
+		if !ok || got != expect {
+			t.Fatalf("workQueue failed: expect %+v, get %+v\n", expect, got)
+		}
+	}
+
+	// 取出后可以再次加入
+	q.push(a)
+	if got, ok := q.pop(abort); !ok || got != a {
+		t.Fatalf("workQueue failed: expect %+v after pop, get %+v\n", a, got)
+	}
+
+	// 等待中的pop在加入任务后返回
+	popped := make(chan task)
+	go func() {
+		got, _ := q.pop(abort)
+		popped <- got
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.push(b)
+	select {
+	case got := <-popped:
+		if got != b {
+			t.Fatalf("workQueue failed: expect %+v, get %+v\n", b, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("workQueue failed: pop is not woken by push\n")
+	}
+
+	// abort关闭后等待中的pop返回false
+	done := make(chan bool)
+	go func() {
+		_, ok := q.pop(abort)
+		done <- ok
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(abort)
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatalf("workQueue failed: expect pop to fail after abort\n")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("workQueue failed: pop is not woken by abort\n")
+	}
+}
+
+func TestLoginLimiter(t *testing.T) {
+	storage, err := NewFileStorage(filepath.Join(t.TempDir(), "cookie.db"), "limiter_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	conn := &Conn{Storage: storage, Concurrency: 1, Interval: 50 * time.Millisecond}
+	ns := conn.Namespace("ns")
+	abort := make(chan struct{})
+
+	start := time.Now()
+	if !conn.beginLogin(abort) {
+		t.Fatalf("beginLogin failed\n")
+	}
+	// 命名空间共用限制，同时只能有一个登录
+	second := make(chan time.Time)
+	go func() {
+		ns.beginLogin(abort)
+		second <- time.Now()
+		ns.endLogin()
+	}()
+	select {
+	case <-second:
+		t.Fatalf("beginLogin failed: expect to wait for the running login\n")
+	case <-time.After(100 * time.Millisecond):
+	}
+	conn.endLogin()
+	if d := (<-second).Sub(start); d < 50*time.Millisecond {
+		t.Fatalf("beginLogin failed: logins are only %v apart\n", d)
+	}
+
+	// abort关闭时放弃等待
+	conn.beginLogin(abort)
+	close(abort)
+	if conn.beginLogin(abort) {
+		t.Fatalf("beginLogin failed: expect false after abort\n")
+	}
+}
+
+func TestForget(t *testing.T) {
+	conn := &Conn{queue: newWorkQueue()}
+	conn.watchExpiry("a", CookieList{{Name: "session", Value: "abc", Expires: time.Now().Add(time.Hour)}})
+	conn.retries.fail("a", &DefaultRetryPolicy)
+	if len(conn.timers) != 1 || conn.retries.ready("a") {
+		t.Fatalf("forget failed: expect a timer and a pending retry before forget\n")
+	}
+
+	// 删除账号后没有计时器和重试状态
+	conn.forget("a")
+	if len(conn.timers) != 0 || !conn.retries.ready("a") {
+		t.Fatalf("forget failed: timers %v, ready %v\n", conn.timers, conn.retries.ready("a"))
+	}
+}
@@ -0,0 +1,197 @@
+package cookiepool
+
+// 登录失败的账号按照指数退避重试，连续失败达到最大次数后停用(parked)，
+// 停用的账号需要通过relogin接口或者更新账号恢复。
+// 调度器对同一个网站的所有登录都经过同一个限制，避免短时间内大量登录。
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type RetryPolicy struct {
+	BaseDelay   time.Duration // 第一次失败后的等待时间
+	MaxDelay    time.Duration // 最长等待时间
+	Jitter      float64       // 随机抖动的比例，范围[0, 1]
+	MaxAttempts int           // 连续失败达到该次数后停用账号，0或负数时不停用
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	BaseDelay:   1 * time.Minute,
+	MaxDelay:    6 * time.Hour,
+	Jitter:      0.2,
+	MaxAttempts: 10,
+}
+
+// 连续失败failures次后的等待时间
+func (p *RetryPolicy) Delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	d := float64(p.BaseDelay) * math.Pow(2, float64(failures-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+type retryState struct {
+	failures int
+	next     time.Time
+}
+
+// 账号的重试状态，零值可以直接使用
+type retryStates struct {
+	mu     sync.Mutex
+	states map[string]*retryState
+}
+
+func (rs *retryStates) ready(username string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	st, ok := rs.states[username]
+	return !ok || !time.Now().Before(st.next)
+}
+
+// 记录一次失败，返回连续失败的次数
+func (rs *retryStates) fail(username string, p *RetryPolicy) int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.states == nil {
+		rs.states = map[string]*retryState{}
+	}
+	st, ok := rs.states[username]
+	if !ok {
+		st = &retryState{}
+		rs.states[username] = st
+	}
+	st.failures++
+	st.next = time.Now().Add(p.Delay(st.failures))
+	return st.failures
+}
+
+func (rs *retryStates) reset(username string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.states, username)
+}
+
+func (conn *Conn) retryPolicy() *RetryPolicy {
+	if conn.Retry != nil {
+		return conn.Retry
+	}
+	return &DefaultRetryPolicy
+}
+
+// 判断调度器是否应该登录该账号。手动导入、已停用、等待人工处理、
+// Cookies仍然有效以及处于退避等待中的账号不需要登录
+func (conn *Conn) shouldLogin(username string) bool {
+	meta, err := conn.Storage.GetMeta(username)
+	if err != nil || meta.Manual || meta.Parked {
+		return false
+	}
+	if conn.Challenges.Pending(username) {
+		return false
+	}
+	if ok, err := conn.Storage.ExistsCookie(username); err != nil || ok {
+		return false
+	}
+	return conn.retries.ready(username)
+}
+
+// 根据登录结果更新重试状态
+func (conn *Conn) record(username string, state *LoginState) {
+	switch state.Status {
+	case StatusLoginFailed:
+		p := conn.retryPolicy()
+		n := conn.retries.fail(username, p)
+		if p.MaxAttempts > 0 && n >= p.MaxAttempts {
+			log.Printf("login %s failed %d times, the account is parked.\n", username, n)
+			conn.park(username, true)
+		}
+	case StatusNeedsCaptcha, StatusNeedsOTP:
+		// 等待人工处理，不计入失败次数
+	default:
+		conn.retries.reset(username)
+	}
+}
+
+// 停用或者恢复账号
+func (conn *Conn) park(username string, parked bool) error {
+	meta, err := conn.Storage.GetMeta(username)
+	if err != nil {
+		return err
+	}
+	if meta.Parked == parked {
+		return nil
+	}
+	meta.Parked = parked
+	if !parked {
+		conn.retries.reset(username)
+	}
+	return conn.Storage.SetMeta(username, meta)
+}
+
+// 同一个网站的登录限制：同时登录的账号不超过Conn.Concurrency个，相邻两次登录至少间隔Conn.Interval。
+// 周期性的登录和工作队列中的登录共用一个限制，各个命名空间的Conn也共用
+type loginLimiter struct {
+	sem  chan struct{}
+	mu   sync.Mutex
+	next time.Time // 下一次登录最早开始的时间
+}
+
+func (conn *Conn) limiter() *loginLimiter {
+	conn.limitMu.Lock()
+	defer conn.limitMu.Unlock()
+	if conn.loginLimit == nil {
+		n := conn.Concurrency
+		if n <= 0 {
+			n = 1
+		}
+		conn.loginLimit = &loginLimiter{sem: make(chan struct{}, n)}
+	}
+	return conn.loginLimit
+}
+
+func (conn *Conn) interval() time.Duration {
+	if conn.Interval > 0 {
+		return conn.Interval
+	}
+	return 1 * time.Second
+}
+
+// 等待登录的许可，abort关闭时返回false。返回true时登录结束后需要调用endLogin
+func (conn *Conn) beginLogin(abort <-chan struct{}) bool {
+	l := conn.limiter()
+	select {
+	case <-abort:
+		return false
+	case l.sem <- struct{}{}:
+	}
+
+	l.mu.Lock()
+	start := time.Now()
+	if start.Before(l.next) {
+		start = l.next
+	}
+	l.next = start.Add(conn.interval())
+	l.mu.Unlock()
+
+	select {
+	case <-abort:
+		<-l.sem
+		return false
+	case <-time.After(time.Until(start)):
+		return true
+	}
+}
+
+func (conn *Conn) endLogin() {
+	<-conn.limiter().sem
+}
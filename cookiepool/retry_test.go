@@ -0,0 +1,34 @@
+package cookiepool_test
+
+import (
+	"gospider/cookiepool"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := &cookiepool.RetryPolicy{BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+	for _, test := range tests {
+		if d := p.Delay(test.failures); d != test.delay {
+			t.Fatalf("Delay failed: failures(%d) expect(%v) get(%v)\n", test.failures, test.delay, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Delay(2); d < time.Minute || d > 3*time.Minute {
+			t.Fatalf("Delay failed: expect delay in [%v, %v], get %v\n", time.Minute, 3*time.Minute, d)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package cookiepool
 
 import (
+	"gospider"
+	"gospider/auth"
 	"log"
 	"net/http"
 	"sync"
@@ -10,17 +12,34 @@ import (
 type Scheduler struct {
 	ConnMap ConnMap
 	WebAddr string
+	Auth    *auth.Guard         // web接口的认证，为nil时不进行认证
+	TLS     *gospider.TLSConfig // web接口的TLS配置，为nil时使用HTTP
 
 	ValidCycle int
 	LoginCycle int
 
+	Concurrency int // 同时登录或者验证的网站数目，默认为10
+
 	webserver *http.Server
+	queue     *workQueue
 	abort     chan struct{}
 	wg        sync.WaitGroup
 }
 
 func (sch *Scheduler) Serve() {
 	sch.abort = make(chan struct{})
+	sch.queue = newWorkQueue()
+	for _, conn := range sch.conns() {
+		conn.queue = sch.queue
+	}
+
+	for i := 0; i < sch.concurrency(); i++ {
+		sch.wg.Add(1)
+		go func() {
+			defer sch.wg.Done()
+			sch.work()
+		}()
+	}
 
 	sch.wg.Add(1)
 	go func() {
@@ -66,14 +85,48 @@ func (sch *Scheduler) Serve() {
 
 func (sch *Scheduler) Close() {
 	close(sch.abort)
+	for _, conn := range sch.conns() {
+		conn.stopTimers()
+	}
+}
+
+// 处理工作队列中的任务
+func (sch *Scheduler) work() {
+	for {
+		t, ok := sch.queue.pop(sch.abort)
+		if !ok {
+			return
+		}
+		t.run(sch.abort)
+	}
+}
+
+// 所有网站的Conn以及它们在各个令牌命名空间中的Conn
+func (sch *Scheduler) conns() []*Conn {
+	nss := sch.Auth.Namespaces()
+	var conns []*Conn
+	for _, conn := range sch.ConnMap {
+		conns = append(conns, conn)
+		for _, ns := range nss {
+			conns = append(conns, conn.Namespace(ns))
+		}
+	}
+	return conns
+}
+
+func (sch *Scheduler) concurrency() int {
+	if sch.Concurrency > 0 {
+		return sch.Concurrency
+	}
+	return 10
 }
 
 func (sch *Scheduler) login() {
 	var wg sync.WaitGroup
-	workCh := make(chan struct{}, 10)
+	workCh := make(chan struct{}, sch.concurrency())
 
 connloop:
-	for _, conn := range sch.ConnMap {
+	for _, conn := range sch.conns() {
 		select {
 		case <-sch.abort:
 			break connloop
@@ -91,23 +144,24 @@ connloop:
 					return
 				}
 
-			nameloop:
+				var wga sync.WaitGroup
 				for u, p := range accounts {
-					select {
-					case <-sch.abort:
-						break nameloop
-					case <-time.After(1 * time.Second):
-						state := conn.LoginFunc.Login(u, p)
-						switch state.Status {
-						case StatusPasswordERR:
-							conn.Storage.DeleteAccount(u)
-						case StatusLoginSuccessful:
-							if s, err := state.CookieList.WriteToString(); err == nil {
-								conn.Storage.SetCookie(u, s)
-							}
-						}
+					if !conn.shouldLogin(u) {
+						continue
+					}
+					if !conn.beginLogin(sch.abort) {
+						break
 					}
+					wga.Add(1)
+					go func(u, p string) {
+						defer func() {
+							wga.Done()
+							conn.endLogin()
+						}()
+						conn.Login(u, p)
+					}(u, p)
 				}
+				wga.Wait()
 			}(conn)
 		}
 	}
@@ -117,10 +171,10 @@ connloop:
 
 func (sch *Scheduler) valid() {
 	var wg sync.WaitGroup
-	workCh := make(chan struct{}, 10)
+	workCh := make(chan struct{}, sch.concurrency())
 
 connloop:
-	for _, conn := range sch.ConnMap {
+	for _, conn := range sch.conns() {
 		select {
 		case <-sch.abort:
 			break connloop
@@ -149,7 +203,7 @@ connloop:
 						case <-sch.abort:
 							return
 						default:
-							conn.Storage.DeleteCookie(name)
+							conn.DropCookie(name)
 						}
 					}
 				}()
@@ -161,26 +215,27 @@ connloop:
 						break nameloop
 					case <-time.After(1 * time.Second):
 						cl := CookieList{}
-						if cl.Decode([]byte(c)) != nil {
+						if err := cl.Decode([]byte(c)); err != nil {
 							log.Printf("decode cookies failed: %v\n", err)
 							select {
 							case nameCh <- u:
 							case <-sch.abort:
 								break nameloop
 							}
-
+							continue
 						}
-						b, err := ValidLogin(conn.URL, []*http.Cookie(cl), http.StatusOK)
-						if err != nil {
-							log.Printf("valid cookies failed: %v\n", err)
-							select {
-							case nameCh <- u:
-							case <-sch.abort:
-								break nameloop
-							}
+						var proxy string
+						if meta, err := conn.Storage.GetMeta(u); err == nil {
+							proxy = meta.Proxy
+						}
+						b, err := conn.Validate(cl, proxy)
+						// 代理或者网络的问题无法判断Cookies是否有效，保留Cookies
+						if IsTransportError(err) {
+							log.Printf("valid cookies of %s failed: %v\n", u, err)
+							continue
 						}
 						if !b {
-							log.Printf("cookies are expired\n")
+							log.Printf("cookies are expired: %v\n", err)
 							select {
 							case nameCh <- u:
 							case <-sch.abort:
@@ -193,6 +248,7 @@ connloop:
 				close(nameCh)
 
 				wgn.Wait()
+				conn.checkWatermark()
 			}(conn)
 		}
 	}
@@ -202,7 +258,7 @@ connloop:
 
 func (sch *Scheduler) webserve() error {
 	if sch.webserver == nil {
-		sch.webserver = NewWebServer(sch.ConnMap, sch.WebAddr)
+		sch.webserver = NewAuthWebServer(sch.ConnMap, sch.WebAddr, sch.Auth)
 	}
 
 	go func() {
@@ -210,5 +266,5 @@ func (sch *Scheduler) webserve() error {
 		sch.webserver.Close()
 	}()
 
-	return sch.webserver.ListenAndServe()
+	return sch.TLS.ListenAndServe(sch.webserver)
 }
@@ -3,11 +3,13 @@ package cookiepool
 // 应用中，需要使用Cookie池的应用是登录应用。存储模块包括账号信息和Cookies信息。
 // 账号由用户名和密码两部分组成，我们可以存成用户名和密码的映射。Cookies可以存成
 // JSON字符串，并根据账号来生成Cookies。生成的时候我们需要账号是否已经生成了Cookies。
-// 我们应用Redis的Hash存储账号信息和Cookies。
+// 我们应用Redis的Hash存储账号信息和Cookies。不使用Redis时可以使用本地文件，
+// 见NewFileStorage。
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
+	"gospider/internal/kv"
 	"math/rand"
 	"time"
 
@@ -15,73 +17,134 @@ import (
 )
 
 type Storage struct {
-	rdb *redis.Client // redis客户端
+	store kv.Store // 存储后端
 
 	accountKey string // Redis的AccountKey
 	cookieKey  string // Redis的CookieKey
+	metaKey    string // Redis的MetaKey，保存账号的附加信息
+	leaseKey   string // Redis的LeaseKey，保存Cookies的租约
+}
+
+// 账号的附加信息，以JSON字符串存储
+type Meta struct {
+	Manual     bool   `json:"manual,omitempty"`      // 手动导入的Cookies，只验证不自动登录
+	ImportedAt int64  `json:"imported_at,omitempty"` // 导入时间
+	Proxy      string `json:"proxy,omitempty"`       // 登录使用的代理，使用Cookies时应使用同一个代理
+	OTPSecret  string `json:"otp_secret,omitempty"`  // base32编码的TOTP密钥
+	Parked     bool   `json:"parked,omitempty"`      // 连续登录失败而停用
 }
 
 func NewStorage(addr string, password string, keys ...string) (*Storage, error) {
-	var accountKey string
-	var cookieKey string
+	return NewStorageWithOptions(&redis.UniversalOptions{
+		Addrs:    []string{addr},
+		Password: password,
+		DB:       0,
+		PoolSize: 100,
+	}, keys...)
+}
 
-	switch len(keys) {
-	case 1:
-		accountKey = fmt.Sprintf("account:%s", keys[0])
-		cookieKey = fmt.Sprintf("cookie:%s", keys[0])
-	case 2:
-		accountKey = fmt.Sprintf("account:%s", keys[0])
-		cookieKey = fmt.Sprintf("cookie:%s", keys[1])
-	default:
-		return nil, fmt.Errorf("the length of keys must be 1 or 2: get len(keys) = %d", len(keys))
+// 使用完整的Redis配置建立存储。opts.Addrs包含多个地址时使用集群，
+// 设置opts.MasterName时使用哨兵
+func NewStorageWithOptions(opts *redis.UniversalOptions, keys ...string) (*Storage, error) {
+	if err := checkKeys(keys); err != nil {
+		return nil, err
 	}
-
-	s := Storage{
-		rdb: redis.NewClient(&redis.Options{
-			Addr:     addr,
-			Password: password,
-			DB:       0,
-			PoolSize: 100,
-		}),
-
-		accountKey: accountKey,
-		cookieKey:  cookieKey,
+	store, err := kv.NewRedisWithOptions(opts)
+	if err != nil {
+		return nil, err
 	}
+	return newStorage(store, keys...), nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// 使用已有的Redis客户端建立存储，集群模式下键会加上哈希标签
+func NewStorageWithClient(rdb redis.UniversalClient, keys ...string) (*Storage, error) {
+	if err := checkKeys(keys); err != nil {
+		return nil, err
+	}
+	store, err := kv.NewRedis(rdb)
+	if err != nil {
+		return nil, err
+	}
+	return newStorage(store, keys...), nil
+}
 
-	_, err := s.rdb.Ping(ctx).Result()
+// 使用本地文件建立存储，不需要Redis。同一个进程中的多个存储可以使用同一个文件
+func NewFileStorage(path string, keys ...string) (*Storage, error) {
+	if err := checkKeys(keys); err != nil {
+		return nil, err
+	}
+	store, err := kv.OpenFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return newStorage(store, keys...), nil
+}
+
+func checkKeys(keys []string) error {
+	if len(keys) != 1 && len(keys) != 2 {
+		return fmt.Errorf("the length of keys must be 1 or 2: get len(keys) = %d", len(keys))
+	}
+	return nil
+}
+
+func newStorage(store kv.Store, keys ...string) *Storage {
+	accountName := store.HashTag(keys[0])
+	cookieName := accountName
+	if len(keys) == 2 {
+		cookieName = store.HashTag(keys[1])
+	}
+
+	return &Storage{
+		store: store,
+
+		accountKey: fmt.Sprintf("account:%s", accountName),
+		cookieKey:  fmt.Sprintf("cookie:%s", cookieName),
+		metaKey:    fmt.Sprintf("meta:%s", cookieName),
+		leaseKey:   fmt.Sprintf("lease:%s", cookieName),
+	}
+}
+
+// 关闭存储后端，使用已有Redis客户端建立的存储不会关闭该客户端
+func (s *Storage) Close() error {
+	return s.store.Close()
+}
 
-	return &s, nil
+// 返回使用命名空间ns的存储，与s共用同一个后端。ns为空时返回s
+func (s *Storage) Namespace(ns string) *Storage {
+	if ns == "" {
+		return s
+	}
+	return &Storage{
+		store:      s.store,
+		accountKey: s.accountKey + ":" + ns,
+		cookieKey:  s.cookieKey + ":" + ns,
+		metaKey:    s.metaKey + ":" + ns,
+		leaseKey:   s.leaseKey + ":" + ns,
+	}
 }
 
-func (s *Storage) set(key string, values ...any) error {
-	ctx := context.Background()
-	return s.rdb.HSet(ctx, key, values...).Err()
+func (s *Storage) set(key string, fieldValues ...string) error {
+	return s.store.HSet(key, fieldValues...)
 }
 
 func (s *Storage) get(key, field string) (string, error) {
-	ctx := context.Background()
-	return s.rdb.HGet(ctx, key, field).Result()
+	return s.store.HGet(key, field)
 }
 
 func (s *Storage) delete(key string, fields ...string) error {
-	ctx := context.Background()
-	return s.rdb.HDel(ctx, key, fields...).Err()
+	return s.store.HDel(key, fields...)
+}
+
+func (s *Storage) exists(key, field string) (bool, error) {
+	return s.store.HExists(key, field)
 }
 
 func (s *Storage) count(key string) (int64, error) {
-	ctx := context.Background()
-	return s.rdb.HLen(ctx, key).Result()
+	return s.store.HLen(key)
 }
 
 func (s *Storage) getall(key string) (map[string]string, error) {
-	ctx := context.Background()
-	return s.rdb.HGetAll(ctx, key).Result()
+	return s.store.HGetAll(key)
 }
 
 func (s *Storage) SetAccount(username, value string) error {
@@ -104,10 +167,22 @@ func (s *Storage) DeleteAccount(usernames ...string) error {
 	return s.delete(s.accountKey, usernames...)
 }
 
+// 删除Cookies及其租约
 func (s *Storage) DeleteCookie(usernames ...string) error {
+	if err := s.delete(s.leaseKey, usernames...); err != nil {
+		return err
+	}
 	return s.delete(s.cookieKey, usernames...)
 }
 
+func (s *Storage) ExistsAccount(username string) (bool, error) {
+	return s.exists(s.accountKey, username)
+}
+
+func (s *Storage) ExistsCookie(username string) (bool, error) {
+	return s.exists(s.cookieKey, username)
+}
+
 func (s *Storage) CountAccount() (int64, error) {
 	return s.count(s.accountKey)
 }
@@ -118,8 +193,7 @@ func (s *Storage) CountCookie() (int64, error) {
 
 // 随机获取网站Cookie
 func (s *Storage) Random() (string, error) {
-	ctx := context.Background()
-	strs, err := s.rdb.HVals(ctx, s.cookieKey).Result()
+	strs, err := s.store.HVals(s.cookieKey)
 	if err != nil {
 		return "", err
 	}
@@ -129,6 +203,69 @@ func (s *Storage) Random() (string, error) {
 	return "", fmt.Errorf("no cookie for key: %s", s.cookieKey)
 }
 
+func (s *Storage) SetMeta(username string, meta *Meta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.set(s.metaKey, username, string(b))
+}
+
+// 获取账号的附加信息，没有附加信息时返回零值
+func (s *Storage) GetMeta(username string) (*Meta, error) {
+	meta := &Meta{}
+	v, err := s.get(s.metaKey, username)
+	if err == kv.Nil {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(v), meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (s *Storage) DeleteMeta(usernames ...string) error {
+	return s.delete(s.metaKey, usernames...)
+}
+
+// 导入手动登录得到的Cookies，这些Cookies会被验证，但不会自动重新登录
+func (s *Storage) ImportCookies(username string, cl CookieList) error {
+	v, err := cl.WriteToString()
+	if err != nil {
+		return err
+	}
+	meta, err := s.GetMeta(username)
+	if err != nil {
+		return err
+	}
+	meta.Manual = true
+	meta.ImportedAt = time.Now().Unix()
+	if err := s.SetMeta(username, meta); err != nil {
+		return err
+	}
+	return s.SetCookie(username, v)
+}
+
+// 随机获取网站Cookie，同时返回用户名
+func (s *Storage) RandomEntry() (string, string, error) {
+	cs, err := s.getall(s.cookieKey)
+	if err != nil {
+		return "", "", err
+	}
+	if len(cs) == 0 {
+		return "", "", fmt.Errorf("no cookie for key: %s", s.cookieKey)
+	}
+	usernames := make([]string, 0, len(cs))
+	for u := range cs {
+		usernames = append(usernames, u)
+	}
+	u := usernames[rand.Intn(len(usernames))]
+	return u, cs[u], nil
+}
+
 func (s *Storage) GetAllAccount() (map[string]string, error) {
 	return s.getall(s.accountKey)
 }
@@ -138,6 +275,5 @@ func (s *Storage) GetAllCookie() (map[string]string, error) {
 }
 
 func (s *Storage) Usernames() ([]string, error) {
-	ctx := context.Background()
-	return s.rdb.HKeys(ctx, s.accountKey).Result()
+	return s.store.HKeys(s.accountKey)
 }
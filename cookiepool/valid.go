@@ -1,17 +1,131 @@
 package cookiepool
 
 import (
+	"encoding/json"
 	"fmt"
 	"gospider"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// 验证Cookies是否有用
-func ValidLogin(url string, cookies []*http.Cookie, expectcode int) (bool, error) {
-	c := &http.Client{
-		Timeout:       5 * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+// 验证器根据携带Cookies请求得到的响应，判断登录状态是否仍然有效。
+// 不同网站对"仍处于登录状态"的定义不同，例如会话过期时返回200和登录页面，
+// 因此每个网站可以在Conn中设置自己的验证器。
+type Validator interface {
+	Validate(resp *http.Response, body []byte) (bool, error)
+}
+
+type ValidatorFunc func(resp *http.Response, body []byte) (bool, error)
+
+func (f ValidatorFunc) Validate(resp *http.Response, body []byte) (bool, error) {
+	return f(resp, body)
+}
+
+// 状态码必须为codes之一
+func StatusCode(codes ...int) Validator {
+	return ValidatorFunc(func(resp *http.Response, body []byte) (bool, error) {
+		for _, code := range codes {
+			if resp.StatusCode == code {
+				return true, nil
+			}
+		}
+		if len(codes) == 1 {
+			return false, fmt.Errorf("status codes are different: expect %d, get %d", codes[0], resp.StatusCode)
+		}
+		return false, fmt.Errorf("status codes are different: expect %v, get %d", codes, resp.StatusCode)
+	})
+}
+
+// 响应重定向到包含substr的地址时(例如登录页面)，视为登录失效
+func RedirectLocation(substr string) Validator {
+	return ValidatorFunc(func(resp *http.Response, body []byte) (bool, error) {
+		loc := resp.Header.Get("Location")
+		if loc != "" && strings.Contains(loc, substr) {
+			return false, fmt.Errorf("redirect to %s", loc)
+		}
+		return true, nil
+	})
+}
+
+// 响应正文必须包含substr
+func BodyContains(substr string) Validator {
+	return ValidatorFunc(func(resp *http.Response, body []byte) (bool, error) {
+		if !strings.Contains(string(body), substr) {
+			return false, fmt.Errorf("body does not contain %q", substr)
+		}
+		return true, nil
+	})
+}
+
+// 响应正文不能包含substr
+func BodyNotContains(substr string) Validator {
+	return ValidatorFunc(func(resp *http.Response, body []byte) (bool, error) {
+		if strings.Contains(string(body), substr) {
+			return false, fmt.Errorf("body contains %q", substr)
+		}
+		return true, nil
+	})
+}
+
+// 响应正文为JSON，path为以"."分隔的字段路径，数组元素用下标表示，例如"data.items.0.id"。
+// expect为nil时只要求字段存在，否则要求字段值与expect的字符串形式相同。
+func JSONField(path string, expect any) Validator {
+	return ValidatorFunc(func(resp *http.Response, body []byte) (bool, error) {
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return false, fmt.Errorf("decode json failed: %v", err)
+		}
+		for _, field := range strings.Split(path, ".") {
+			switch node := v.(type) {
+			case map[string]any:
+				value, ok := node[field]
+				if !ok {
+					return false, fmt.Errorf("json field %s not found", path)
+				}
+				v = value
+			case []any:
+				i, err := strconv.Atoi(field)
+				if err != nil || i < 0 || i >= len(node) {
+					return false, fmt.Errorf("json field %s not found", path)
+				}
+				v = node[i]
+			default:
+				return false, fmt.Errorf("json field %s not found", path)
+			}
+		}
+		if expect == nil {
+			return true, nil
+		}
+		if fmt.Sprint(v) != fmt.Sprint(expect) {
+			return false, fmt.Errorf("json field %s is different: expect %v, get %v", path, expect, v)
+		}
+		return true, nil
+	})
+}
+
+// 所有验证器均通过时才有效
+func All(validators ...Validator) Validator {
+	return ValidatorFunc(func(resp *http.Response, body []byte) (bool, error) {
+		for _, v := range validators {
+			if ok, err := v.Validate(resp, body); !ok || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// 使用验证器验证Cookies是否有用。请求不跟随重定向，c为nil时使用默认的客户端。
+// 请求失败时返回TransportError
+func Validate(c *http.Client, url string, cookies []*http.Cookie, v Validator) (bool, error) {
+	if c == nil {
+		c = &http.Client{
+			Timeout:       5 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+		}
 	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -23,11 +137,17 @@ func ValidLogin(url string, cookies []*http.Cookie, expectcode int) (bool, error
 	req.Header.Set("User-Agent", gospider.UserAgent)
 	resp, err := c.Do(req)
 	if err != nil {
-		return false, err
+		return false, &TransportError{Err: err}
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != expectcode {
-		return false, fmt.Errorf("status codes are different: expect %d, get %d", expectcode, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, &TransportError{Err: err}
 	}
-	return true, nil
+	return v.Validate(resp, body)
+}
+
+// 验证Cookies是否有用
+func ValidLogin(url string, cookies []*http.Cookie, expectcode int) (bool, error) {
+	return Validate(nil, url, cookies, StatusCode(expectcode))
 }
@@ -0,0 +1,59 @@
+package cookiepool_test
+
+import (
+	"fmt"
+	"gospider/cookiepool"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err != nil {
+			http.Redirect(w, r, "/login?next=/home", http.StatusFound)
+			return
+		}
+		switch r.URL.Path {
+		case "/expired":
+			fmt.Fprint(w, "<form id=\"login\"></form>")
+		case "/api":
+			fmt.Fprint(w, `{"code": 0, "data": {"user": {"id": 12}, "roles": ["admin"]}}`)
+		default:
+			fmt.Fprint(w, "<h2>Welcome</h2>")
+		}
+	}))
+	defer server.Close()
+
+	cookies := []*http.Cookie{{Name: "session", Value: "abc"}}
+
+	tests := []struct {
+		path      string
+		cookies   []*http.Cookie
+		validator cookiepool.Validator
+		valid     bool
+	}{
+		{"/home", cookies, cookiepool.StatusCode(http.StatusOK), true},
+		{"/home", nil, cookiepool.StatusCode(http.StatusOK), false},
+		{"/home", nil, cookiepool.RedirectLocation("/login"), false},
+		{"/home", cookies, cookiepool.RedirectLocation("/login"), true},
+		{"/home", cookies, cookiepool.BodyContains("Welcome"), true},
+		{"/expired", cookies, cookiepool.BodyContains("Welcome"), false},
+		{"/expired", cookies, cookiepool.BodyNotContains("id=\"login\""), false},
+		{"/api", cookies, cookiepool.JSONField("data.user.id", 12), true},
+		{"/api", cookies, cookiepool.JSONField("data.roles.0", "admin"), true},
+		{"/api", cookies, cookiepool.JSONField("data.user.name", nil), false},
+		{"/api", cookies, cookiepool.JSONField("code", 1), false},
+		{"/api", cookies, cookiepool.All(cookiepool.StatusCode(http.StatusOK), cookiepool.JSONField("code", 0)), true},
+		{"/home", cookies, cookiepool.ValidatorFunc(func(resp *http.Response, body []byte) (bool, error) {
+			return resp.Header.Get("Content-Type") == "application/json", nil
+		}), false},
+	}
+
+	for i, test := range tests {
+		valid, _ := cookiepool.Validate(nil, server.URL+test.path, test.cookies, test.validator)
+		if valid != test.valid {
+			t.Fatalf("Validate failed: case(%d) path(%s) expect(%v) get(%v)\n", i, test.path, test.valid, valid)
+		}
+	}
+}
@@ -1,12 +1,49 @@
 package cookiepool
 
 import (
+	"encoding/json"
 	"fmt"
+	"gospider/auth"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// 建立web服务，提供获取代理的功能
+// 建立web服务，提供获取Cookies和管理账号的功能
+//
+//	GET    /sites                 所有网站及其账号和Cookies数目
+//	GET    /{web}/random          随机获取网站Cookies
+//	GET    /{web}/count           网站的账号和Cookies数目
+//	GET    /{web}/cookie          获取指定用户名的Cookies，参数username
+//	POST   /{web}/checkout        签出一份没有被租用的Cookies，参数ttl(秒，默认60)
+//	POST   /{web}/release         归还租约，参数username、lease和可选的ok，ok=false时验证Cookies
+//	GET    /{web}/accounts        网站所有的用户名
+//	POST   /{web}/account         添加账号，参数username、password和可选的otp_secret
+//	PUT    /{web}/account         更新账号，参数username、password和可选的otp_secret
+//	DELETE /{web}/account         删除账号及其Cookies，只有Cookies的用户同样可以删除，参数username
+//	POST   /{web}/import          导入手动登录的Cookies，参数username和format，正文为Cookies
+//	POST   /{web}/relogin         强制重新登录，参数username
+//	POST   /{web}/validate        强制验证Cookies，参数username
+//	GET    /{web}/challenges      等待人工处理的验证码和二次验证
+//	POST   /{web}/challenges/resolve  提交答案并继续登录，参数id和answer
+//
+// Cookies的输出格式由参数format或者Accept请求头决定，见format.go，默认为json。
+// 如果登录时使用了代理，响应头X-Proxy为该代理，使用Cookies时应使用同一个代理。
+// 签出时响应头X-Lease和X-Lease-Expires为租约ID和到期时间(RFC3339)。
+//
+// 使用NewAuthWebServer时，获取Cookies的接口需要cookie:read权限，其余接口需要
+// account:manage权限，请求使用令牌对应命名空间的存储。
 func NewWebServer(c ConnMap, addr string) *http.Server {
+	return NewAuthWebServer(c, addr, nil)
+}
+
+// 建立需要认证的web服务，g为nil时不进行认证
+func NewAuthWebServer(c ConnMap, addr string, g *auth.Guard) *http.Server {
 	servermux := &http.ServeMux{}
 	servermux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -15,14 +52,452 @@ func NewWebServer(c ConnMap, addr string) *http.Server {
 	if c == nil {
 		c = defaultConnMap
 	}
+	servermux.HandleFunc("/sites", g.Handle(auth.ScopeReadCookie, sitesHandler(c)))
 	for web, conn := range c {
-		servermux.HandleFunc("/"+web+"/random", func(w http.ResponseWriter, r *http.Request) {
-			v, _ := conn.Storage.Random()
-			fmt.Fprintf(w, "%v", v)
-		})
+		handleConn(servermux, web, conn, g)
 	}
+	g.HandleAdmin(servermux)
 
 	server := &http.Server{Addr: addr, Handler: servermux}
 
 	return server
 }
+
+func handleConn(servermux *http.ServeMux, web string, conn *Conn, g *auth.Guard) {
+	prefix := "/" + web
+
+	// 检查令牌的权限，并使用令牌命名空间中的Conn处理请求
+	handle := func(path, scope string, h func(w http.ResponseWriter, r *http.Request, conn *Conn)) {
+		servermux.HandleFunc(prefix+path, g.Handle(scope, func(w http.ResponseWriter, r *http.Request) {
+			h(w, r, conn.Namespace(auth.Namespace(r)))
+		}))
+	}
+
+	handle("/random", auth.ScopeReadCookie, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		u, v, err := conn.Storage.RandomEntry()
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeCookie(w, r, conn, u, v)
+	})
+
+	handle("/count", auth.ScopeReadCookie, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		info, err := siteInfo(web, conn)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+
+	handle("/cookie", auth.ScopeReadCookie, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		username := r.FormValue("username")
+		if username == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("username is required"))
+			return
+		}
+		v, err := conn.Storage.GetCookie(username)
+		if err != nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no cookie for username: %s", username))
+			return
+		}
+		writeCookie(w, r, conn, username, v)
+	})
+
+	handle("/checkout", auth.ScopeReadCookie, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+			return
+		}
+		ttl := 60 * time.Second
+		if v := r.FormValue("ttl"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ttl: %s", v))
+				return
+			}
+			ttl = time.Duration(n) * time.Second
+		}
+		l, v, err := conn.Storage.Checkout(ttl)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.Header().Set("X-Lease", l.ID)
+		w.Header().Set("X-Lease-Expires", l.Expires.Format(time.RFC3339))
+		writeCookie(w, r, conn, l.Username, v)
+	})
+
+	handle("/release", auth.ScopeReadCookie, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+			return
+		}
+		username, id := r.FormValue("username"), r.FormValue("lease")
+		if username == "" || id == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("username and lease are required"))
+			return
+		}
+		if err := conn.Storage.Release(username, id); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		// 使用者报告Cookies不可用时立即验证。在调度器中运行时经过去重的工作队列，否则同步验证
+		if ok, err := strconv.ParseBool(r.FormValue("ok")); err == nil && !ok {
+			if conn.queue != nil {
+				conn.enqueue(taskValidate, username)
+			} else if _, err := conn.ValidCookie(username); err != nil {
+				log.Printf("validate cookies of %s failed: %v\n", username, err)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"username": username})
+	})
+
+	handle("/accounts", auth.ScopeManageAccount, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		usernames, err := conn.Storage.Usernames()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		cookies, err := conn.Storage.GetAllCookie()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		// 手动导入Cookies的用户没有账号
+		accounts := make(map[string]bool, len(usernames))
+		for _, u := range usernames {
+			accounts[u] = true
+		}
+		for u := range cookies {
+			if !accounts[u] {
+				usernames = append(usernames, u)
+			}
+		}
+		sort.Strings(usernames)
+		type account struct {
+			Username string `json:"username"`
+			Cookie   bool   `json:"cookie"`
+			Manual   bool   `json:"manual"`
+			Proxy    string `json:"proxy,omitempty"`
+			Parked   bool   `json:"parked"`
+		}
+		res := make([]account, 0, len(usernames))
+		for _, u := range usernames {
+			a := account{Username: u}
+			_, a.Cookie = cookies[u]
+			if meta, err := conn.Storage.GetMeta(u); err == nil {
+				a.Manual = meta.Manual
+				a.Proxy = meta.Proxy
+				a.Parked = meta.Parked
+			}
+			res = append(res, a)
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+
+	handle("/import", auth.ScopeManageAccount, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+			return
+		}
+		username := r.URL.Query().Get("username")
+		if username == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("username is required"))
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		cl, err := ParseCookies(r.URL.Query().Get("format"), data)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := conn.Storage.ImportCookies(username, cl); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"username": username, "cookies": len(cl)})
+	})
+
+	handle("/account", auth.ScopeManageAccount, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		a, err := readAccount(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		exists, err := conn.Storage.ExistsAccount(a.Username)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			if r.Method == http.MethodPost && exists {
+				writeError(w, http.StatusConflict, fmt.Errorf("account already exists: %s", a.Username))
+				return
+			}
+			if r.Method == http.MethodPut && !exists {
+				writeError(w, http.StatusNotFound, fmt.Errorf("no account for username: %s", a.Username))
+				return
+			}
+			if a.Password == "" {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("password is required"))
+				return
+			}
+			if a.OTPSecret != "" {
+				if _, err := TOTP(a.OTPSecret, time.Now()); err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+				meta, err := conn.Storage.GetMeta(a.Username)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+				meta.OTPSecret = a.OTPSecret
+				if err := conn.Storage.SetMeta(a.Username, meta); err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+			}
+			if err := conn.Storage.SetAccount(a.Username, a.Password); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			// 更新账号后恢复停用的账号
+			if err := conn.park(a.Username, false); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"username": a.Username})
+		case http.MethodDelete:
+			// 只导入了Cookies的用户没有账号，同样可以删除
+			hasCookie, err := conn.Storage.ExistsCookie(a.Username)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if !exists && !hasCookie {
+				writeError(w, http.StatusNotFound, fmt.Errorf("no account or cookie for username: %s", a.Username))
+				return
+			}
+			if err := conn.Storage.DeleteAccount(a.Username); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if err := conn.Storage.DeleteCookie(a.Username); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			if err := conn.Storage.DeleteMeta(a.Username); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			conn.forget(a.Username)
+			writeJSON(w, http.StatusOK, map[string]string{"username": a.Username})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+		}
+	})
+
+	handle("/relogin", auth.ScopeManageAccount, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+			return
+		}
+		username := r.FormValue("username")
+		if username == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("username is required"))
+			return
+		}
+		if meta, err := conn.Storage.GetMeta(username); err == nil && meta.Manual {
+			writeError(w, http.StatusConflict, fmt.Errorf("cookies of %s are imported manually", username))
+			return
+		}
+		auth, err := conn.Storage.GetAccount(username)
+		if err != nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no account for username: %s", username))
+			return
+		}
+		// 强制登录会恢复停用的账号
+		if err := conn.park(username, false); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		state := conn.Login(username, auth)
+		res := map[string]any{
+			"username": username,
+			"status":   StatusText(state.Status),
+		}
+		if needsChallenge(state) {
+			res["challenge"] = state.Challenge
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+
+	handle("/challenges", auth.ScopeManageAccount, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		writeJSON(w, http.StatusOK, conn.Challenges.List())
+	})
+
+	handle("/challenges/resolve", auth.ScopeManageAccount, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+			return
+		}
+		id, answer := r.FormValue("id"), r.FormValue("answer")
+		if id == "" || answer == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("id and answer are required"))
+			return
+		}
+		state, err := conn.Resolve(id, answer)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		res := map[string]any{"status": StatusText(state.Status)}
+		if needsChallenge(state) {
+			res["challenge"] = state.Challenge
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+
+	handle("/validate", auth.ScopeManageAccount, func(w http.ResponseWriter, r *http.Request, conn *Conn) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+			return
+		}
+		username := r.FormValue("username")
+		if username == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("username is required"))
+			return
+		}
+		if _, err := conn.Storage.GetCookie(username); err != nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no cookie for username: %s", username))
+			return
+		}
+		res := map[string]any{"username": username}
+		valid, err := conn.ValidCookie(username)
+		res["valid"] = valid
+		if err != nil {
+			res["error"] = err.Error()
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
+
+// 按照请求的格式输出Cookies，v为存储中的JSON字符串。
+// 响应头X-Username为Cookies所属的用户名，X-Proxy为登录使用的代理
+func writeCookie(w http.ResponseWriter, r *http.Request, conn *Conn, username, v string) {
+	w.Header().Set("X-Username", username)
+	if meta, err := conn.Storage.GetMeta(username); err == nil && meta.Proxy != "" {
+		w.Header().Set("X-Proxy", meta.Proxy)
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = FormatFromAccept(r.Header.Get("Accept"))
+	}
+	if format == "" || format == FormatJSON {
+		w.Header().Set("Content-Type", FormatContentType(FormatJSON))
+		fmt.Fprintf(w, "%v", v)
+		return
+	}
+
+	cl := CookieList{}
+	if err := cl.Decode([]byte(v)); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("decode cookies failed: %v", err))
+		return
+	}
+	if u, err := url.Parse(conn.URL); err == nil {
+		cl = cl.WithDomain(u.Hostname())
+	}
+	b, err := cl.EncodeFormat(format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", FormatContentType(format))
+	w.Write(b)
+}
+
+type siteInfoType struct {
+	Site     string `json:"site"`
+	URL      string `json:"url"`
+	Accounts int64  `json:"accounts"`
+	Cookies  int64  `json:"cookies"`
+}
+
+func siteInfo(web string, conn *Conn) (*siteInfoType, error) {
+	na, err := conn.Storage.CountAccount()
+	if err != nil {
+		return nil, err
+	}
+	nc, err := conn.Storage.CountCookie()
+	if err != nil {
+		return nil, err
+	}
+	return &siteInfoType{Site: web, URL: conn.URL, Accounts: na, Cookies: nc}, nil
+}
+
+func sitesHandler(c ConnMap) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webs := make([]string, 0, len(c))
+		for web := range c {
+			webs = append(webs, web)
+		}
+		sort.Strings(webs)
+
+		sites := make([]*siteInfoType, 0, len(webs))
+		for _, web := range webs {
+			info, err := siteInfo(web, c[web].Namespace(auth.Namespace(r)))
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			sites = append(sites, info)
+		}
+		writeJSON(w, http.StatusOK, sites)
+	}
+}
+
+type accountType struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	OTPSecret string `json:"otp_secret"`
+}
+
+// 从JSON正文或者表单中读取账号信息
+func readAccount(r *http.Request) (*accountType, error) {
+	a := &accountType{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(a); err != nil {
+			return nil, fmt.Errorf("decode account failed: %v", err)
+		}
+	} else {
+		a.Username = r.FormValue("username")
+		a.Password = r.FormValue("password")
+		a.OTPSecret = r.FormValue("otp_secret")
+	}
+	if a.Username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	return a, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response failed: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
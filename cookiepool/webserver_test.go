@@ -0,0 +1,92 @@
+package cookiepool_test
+
+import (
+	"encoding/json"
+	"gospider/cookiepool"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebServer(t *testing.T) {
+	const (
+		addr     = "localhost:6379"
+		password = ""
+		website  = "website_test"
+	)
+
+	storage, err := cookiepool.NewStorage(addr, password, website)
+	if err != nil {
+		t.Fatalf("Connect Redis Client failed: addr(%s) password(%s), website(%s)\n", addr, password, website)
+	}
+	defer storage.DeleteAccount("usr1", "usr2")
+	defer storage.DeleteCookie("usr1", "usr2", "usr3")
+
+	storage.SetAccount("usr1", "pwd1")
+	storage.SetCookie("usr1", `[{"Name":"session","Value":"abc"}]`)
+	// 只有手动导入的Cookies，没有账号
+	storage.SetCookie("usr3", `[{"Name":"session","Value":"def"}]`)
+
+	loginfn := func(usr, auth string) *cookiepool.LoginState {
+		return &cookiepool.LoginState{Status: cookiepool.StatusPasswordERR}
+	}
+	c := cookiepool.ConnMap{}
+	c.Add(website, "http://localhost", storage, loginfn)
+
+	webaddr := "localhost:8091"
+	server := cookiepool.NewWebServer(c, webaddr)
+	go server.ListenAndServe()
+	defer server.Close()
+
+	base := "http://" + webaddr + "/" + website
+
+	tests := []struct {
+		method string
+		api    string
+		form   url.Values
+		code   int
+	}{
+		{"GET", "/cookie?username=usr1", nil, http.StatusOK},
+		{"GET", "/cookie?username=usr2", nil, http.StatusNotFound},
+		{"POST", "/account", url.Values{"username": {"usr1"}, "password": {"pwd"}}, http.StatusConflict},
+		{"POST", "/account", url.Values{"username": {"usr2"}, "password": {"pwd2"}}, http.StatusOK},
+		{"PUT", "/account", url.Values{"username": {"usr2"}, "password": {"pwd3"}}, http.StatusOK},
+		{"DELETE", "/account?username=usr2", nil, http.StatusOK},
+		{"DELETE", "/account?username=usr2", nil, http.StatusNotFound},
+		{"DELETE", "/account?username=usr3", nil, http.StatusOK},
+		{"DELETE", "/account?username=usr3", nil, http.StatusNotFound},
+		{"GET", "/relogin?username=usr1", nil, http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, base+test.api, strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Fatalf("Web server failed: %s api(%s) expect(%d) get(%d)\n", test.method, test.api, test.code, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(base + "/count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var count struct {
+		Accounts int `json:"accounts"`
+		Cookies  int `json:"cookies"`
+	}
+	if err := json.Unmarshal(b, &count); err != nil {
+		t.Fatalf("Web server failed: decode count %s: %v\n", b, err)
+	}
+	if count.Accounts != 1 || count.Cookies != 1 {
+		t.Fatalf("Web server failed: expect 1 account and 1 cookie, get %s\n", b)
+	}
+}
@@ -3,10 +3,13 @@ module gospider
 go 1.18
 
 require (
-	github.com/anaskhan96/soup v1.2.5 // indirect
+	github.com/anaskhan96/soup v1.2.5
+	github.com/go-redis/redis/v8 v8.11.5
+)
+
+require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/go-redis/redis/v8 v8.11.5 // indirect
 	github.com/yuin/goldmark v1.4.12 // indirect
 	golang.org/x/net v0.0.0-20210428140749-89ef3d95e781 // indirect
 	golang.org/x/text v0.3.6 // indirect
@@ -0,0 +1,355 @@
+package kv
+
+// 文件后端把所有数据保存在内存中，每个操作的修改作为一行JSON追加写入文件并同步到磁盘。
+// 写入中途崩溃时文件末尾不完整的一行在下次打开时被丢弃，写入失败时截断日志并撤销内存中的修改，
+// 因此每个操作要么完整生效，要么完全没有生效。文件中间的行损坏时无法恢复，打开时返回错误。
+// 日志中的修改远多于当前数据时，将当前数据写入临时文件后替换日志。
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 日志中的修改至少达到该数目后才会压缩
+const compactMin = 10000
+
+type fileStore struct {
+	mu     sync.Mutex
+	mem    *mem
+	path   string
+	f      *os.File
+	logged int // 日志中的修改数目
+	refs   int
+}
+
+var (
+	filesMu sync.Mutex
+	files   = map[string]*fileStore{}
+)
+
+// 打开文件存储，文件不存在时创建。同一个进程中多次打开同一个文件时共用同一个存储，
+// 代理池、Cookie池和令牌可以保存在同一个文件中
+func OpenFile(path string) (Store, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	filesMu.Lock()
+	defer filesMu.Unlock()
+	if s, ok := files[abs]; ok {
+		s.mu.Lock()
+		s.refs++
+		s.mu.Unlock()
+		return s, nil
+	}
+
+	s := &fileStore{mem: newMem(), path: abs, refs: 1}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	files[abs] = s
+	return s, nil
+}
+
+// 读取日志，丢弃末尾没有写完的一行。完整的行无法解析时说明文件损坏，返回错误
+func (s *fileStore) load() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		var cs []change
+		if err := json.Unmarshal(line, &cs); err != nil {
+			f.Close()
+			return fmt.Errorf("load %s failed: corrupted at offset %d: %v", s.path, offset, err)
+		}
+		for _, c := range cs {
+			s.mem.apply(c)
+		}
+		s.logged += len(cs)
+		offset += int64(len(line))
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+
+	if s.logged > 2*s.mem.size() {
+		s.tryCompact()
+	}
+	return nil
+}
+
+func encodeChanges(w io.Writer, cs []change) error {
+	b, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// 将当前数据写入临时文件，然后替换日志
+func (s *fileStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	cs := s.mem.snapshot()
+	w := bufio.NewWriter(f)
+	for i := 0; i < len(cs); i += 1000 {
+		end := i + 1000
+		if end > len(cs) {
+			end = len(cs)
+		}
+		if err := encodeChanges(w, cs[i:end]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		f.Close()
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f = f
+	s.logged = len(cs)
+	return nil
+}
+
+// 压缩日志。数据已经写入日志，压缩失败时只记录日志，下次写入时再次尝试
+func (s *fileStore) tryCompact() {
+	if err := s.compact(); err != nil {
+		log.Printf("compact %s failed: %v\n", s.path, err)
+	}
+}
+
+// 同步目录，使重命名持久化
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// 将内存中记录的修改写入日志。写入失败时把日志截断到写入之前，并撤销内存中的修改
+func (s *fileStore) commit() error {
+	if len(s.mem.changes) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := encodeChanges(&buf, s.mem.changes); err != nil {
+		s.mem.rollback(0)
+		return err
+	}
+	offset, err := s.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		s.mem.rollback(0)
+		return fmt.Errorf("write %s failed: %v", s.path, err)
+	}
+	if _, err := s.f.Write(buf.Bytes()); err != nil {
+		s.abort(offset)
+		return fmt.Errorf("write %s failed: %v", s.path, err)
+	}
+	if err := s.f.Sync(); err != nil {
+		s.abort(offset)
+		return fmt.Errorf("sync %s failed: %v", s.path, err)
+	}
+	s.logged += len(s.mem.flush())
+	if s.logged > compactMin && s.logged > 4*s.mem.size() {
+		s.tryCompact()
+	}
+	return nil
+}
+
+// 写入失败后截断日志中不完整的修改，撤销内存中的修改
+func (s *fileStore) abort(offset int64) {
+	s.f.Truncate(offset)
+	s.f.Seek(offset, io.SeekStart)
+	s.mem.rollback(0)
+}
+
+func (s *fileStore) HSet(key string, fieldValues ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.HSet(key, fieldValues...)
+	return s.commit()
+}
+
+func (s *fileStore) HGet(key, field string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.HGet(key, field)
+}
+
+func (s *fileStore) HDel(key string, fields ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.HDel(key, fields...)
+	return s.commit()
+}
+
+func (s *fileStore) HExists(key, field string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.HExists(key, field)
+}
+
+func (s *fileStore) HLen(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.HLen(key)
+}
+
+func (s *fileStore) HGetAll(key string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.HGetAll(key)
+}
+
+func (s *fileStore) HKeys(key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.HKeys(key)
+}
+
+func (s *fileStore) HVals(key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.HVals(key)
+}
+
+func (s *fileStore) ZAdd(key string, members ...Z) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.ZAdd(key, members...)
+	return s.commit()
+}
+
+func (s *fileStore) ZScore(key, member string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.ZScore(key, member)
+}
+
+func (s *fileStore) ZIncrBy(key string, incr float64, member string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	score, _ := s.mem.ZIncrBy(key, incr, member)
+	return score, s.commit()
+}
+
+func (s *fileStore) ZRem(key string, members ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.ZRem(key, members...)
+	return s.commit()
+}
+
+func (s *fileStore) ZCard(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.ZCard(key)
+}
+
+func (s *fileStore) ZRangeByScore(key string, min, max float64) ([]Z, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.ZRangeByScore(key, min, max)
+}
+
+func (s *fileStore) ZRevRange(key string, start, stop int64) ([]Z, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mem.ZRevRange(key, start, stop)
+}
+
+// 持有锁执行脚本的Go函数，脚本的所有修改作为一行写入日志。脚本返回Nil以外的错误时撤销它的修改
+func (s *fileStore) Run(sc *Script, keys []string, args ...any) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := sc.fn(s.mem, keys, stringArgs(args))
+	if err != nil && err != Nil {
+		s.mem.rollback(0)
+		return nil, err
+	}
+	if cerr := s.commit(); cerr != nil {
+		return nil, cerr
+	}
+	return v, err
+}
+
+// 持有锁执行所有脚本，所有修改作为一行写入日志。出错的脚本的修改被撤销，不影响其他脚本
+func (s *fileStore) Batch(sc *Script, keys []string, argsList [][]any) ([]Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]Result, len(argsList))
+	for i, args := range argsList {
+		mark := s.mem.mark()
+		v, err := sc.fn(s.mem, keys, stringArgs(args))
+		if err != nil && err != Nil {
+			s.mem.rollback(mark)
+			v = nil
+		}
+		res[i] = Result{Val: v, Err: err}
+	}
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *fileStore) HashTag(key string) string {
+	return key
+}
+
+// 最后一个使用者关闭时关闭文件
+func (s *fileStore) Close() error {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	delete(files, s.path)
+	return s.f.Close()
+}
@@ -0,0 +1,65 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.db")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	s := st.(*fileStore)
+	s.HSet("h", "f", "1")
+	s.ZAdd("z", Z{Member: "a", Score: 1})
+
+	// 模拟写入失败，内存中的修改被撤销
+	f := s.f
+	f.Close()
+	if err := s.HSet("h", "f", "2", "g", "3"); err == nil {
+		t.Fatalf("HSet failed: expect write error\n")
+	}
+	if _, err := s.ZIncrBy("z", 5, "a"); err == nil {
+		t.Fatalf("ZIncrBy failed: expect write error\n")
+	}
+	if all, _ := s.HGetAll("h"); len(all) != 1 || all["f"] != "1" {
+		t.Fatalf("HSet failed: memory is changed after write error, get %v\n", all)
+	}
+	if score, _ := s.ZScore("z", "a"); score != 1 {
+		t.Fatalf("ZIncrBy failed: memory is changed after write error, get %v\n", score)
+	}
+	if len(s.mem.changes) != 0 || len(s.mem.undo) != 0 {
+		t.Fatalf("commit failed: changes are left after write error\n")
+	}
+}
+
+func TestFileStoreCompactFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.db")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	s := st.(*fileStore)
+
+	// 临时文件的路径是目录时压缩失败，但是已经写入日志的修改仍然成功
+	if err := os.Mkdir(path+".tmp", 0700); err != nil {
+		t.Fatalf("Mkdir failed: %v\n", err)
+	}
+	s.logged = compactMin + 1
+	if err := s.HSet("h", "f", "1"); err != nil {
+		t.Fatalf("HSet failed: compaction error is reported, %v\n", err)
+	}
+	s.Close()
+
+	st, err = OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	defer st.Close()
+	if v, err := st.HGet("h", "f"); err != nil || v != "1" {
+		t.Fatalf("HSet failed: expect 1 after reopen, get %s %v\n", v, err)
+	}
+}
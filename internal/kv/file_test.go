@@ -0,0 +1,150 @@
+package kv_test
+
+import (
+	"errors"
+	"gospider/internal/kv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.db")
+
+	s, err := kv.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	s.HSet("account", "alice", "1", "bob", "2")
+	s.HDel("account", "bob")
+	s.ZAdd("proxy", kv.Z{Member: "a", Score: 10}, kv.Z{Member: "b", Score: 100}, kv.Z{Member: "c", Score: 50})
+	s.ZIncrBy("proxy", -1, "a")
+	s.ZRem("proxy", "c")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v\n", err)
+	}
+
+	// 模拟写入中途崩溃
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`[{"o":"hset","k":"account","f":"eve"`)
+	f.Close()
+
+	s, err = kv.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	defer s.Close()
+
+	if all, _ := s.HGetAll("account"); len(all) != 1 || all["alice"] != "1" {
+		t.Fatalf("FileStore failed: account %v\n", all)
+	}
+	if _, err := s.HGet("account", "eve"); err != kv.Nil {
+		t.Fatalf("FileStore failed: incomplete change is applied\n")
+	}
+	zs, _ := s.ZRevRange("proxy", 0, -1)
+	if len(zs) != 2 || zs[0] != (kv.Z{Member: "b", Score: 100}) || zs[1] != (kv.Z{Member: "a", Score: 9}) {
+		t.Fatalf("FileStore failed: proxy %v\n", zs)
+	}
+	if zs, _ := s.ZRangeByScore("proxy", 50, 100); len(zs) != 1 || zs[0].Member != "b" {
+		t.Fatalf("FileStore failed: ZRangeByScore %v\n", zs)
+	}
+
+	// 修改仍然可以追加在截断后的日志中
+	s.HSet("account", "carol", "3")
+	if n, _ := s.HLen("account"); n != 2 {
+		t.Fatalf("FileStore failed: expect 2 accounts, get %d\n", n)
+	}
+}
+
+// 脚本的所有修改作为一个整体写入
+var swapScript = kv.NewScript(`
+local v = redis.call('HGET', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return v
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	v, err := ops.HGet(keys[0], args[0])
+	ops.HSet(keys[0], args[0], args[1])
+	return v, err
+})
+
+func TestFileStoreScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.db")
+	s, err := kv.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	defer s.Close()
+
+	if _, err := kv.Text(s.Run(swapScript, []string{"h"}, "f", 1)); err != kv.Nil {
+		t.Fatalf("Run failed: expect Nil, get %v\n", err)
+	}
+	if v, err := kv.Text(s.Run(swapScript, []string{"h"}, "f", 2)); err != nil || v != "1" {
+		t.Fatalf("Run failed: expect 1, get %v %v\n", v, err)
+	}
+
+	// 同一个文件共用同一个存储
+	s2, _ := kv.OpenFile(path)
+	defer s2.Close()
+	if v, _ := s2.HGet("h", "f"); v != "2" {
+		t.Fatalf("OpenFile failed: expect shared store, get %s\n", v)
+	}
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.db")
+	s, err := kv.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	for i := 0; i < 12000; i++ {
+		s.ZAdd("proxy", kv.Z{Member: strconv.Itoa(i % 10), Score: float64(i)})
+	}
+	s.Close()
+
+	fi, _ := os.Stat(path)
+	if fi.Size() > 200000 {
+		t.Fatalf("Compact failed: file size %d\n", fi.Size())
+	}
+	s, _ = kv.OpenFile(path)
+	defer s.Close()
+	if score, _ := s.ZScore("proxy", "9"); score != 11999 {
+		t.Fatalf("Compact failed: expect 11999, get %v\n", score)
+	}
+}
+
+// 脚本出错时撤销它已经做出的修改
+var failScript = kv.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return redis.error_reply('fail')
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	ops.HSet(keys[0], args[0], args[1])
+	return nil, errors.New("fail")
+})
+
+func TestFileStoreRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.db")
+	s, err := kv.OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	s.HSet("h", "f", "1")
+	if _, err := s.Run(failScript, []string{"h"}, "f", 2); err == nil {
+		t.Fatalf("Run failed: expect error\n")
+	}
+	res, err := s.Batch(failScript, []string{"h"}, [][]any{{"g", 1}})
+	if err != nil || res[0].Err == nil {
+		t.Fatalf("Batch failed: expect script error, get %v %v\n", res, err)
+	}
+	if all, _ := s.HGetAll("h"); len(all) != 1 || all["f"] != "1" {
+		t.Fatalf("Run failed: changes are not rolled back, get %v\n", all)
+	}
+	s.Close()
+
+	// 文件中间的行损坏时不能静默丢弃之后的数据
+	b, _ := os.ReadFile(path)
+	os.WriteFile(path, append([]byte("[{\"o\":\"hset\"\n"), b...), 0600)
+	if _, err := kv.OpenFile(path); err == nil {
+		t.Fatalf("OpenFile failed: expect error for corrupted file\n")
+	}
+}
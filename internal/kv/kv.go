@@ -0,0 +1,129 @@
+// 存储后端:
+// 哈希和有序集合的操作以及原子脚本 - kv.go
+// Redis后端 - redis.go
+// 内存数据结构 - mem.go
+// 追加写文件后端 - file.go
+//
+// 代理池、Cookie池和令牌存储通过Store访问数据，可以使用Redis，也可以使用
+// 本地文件而不需要运行Redis。
+
+package kv
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 键、字段或者成员不存在
+var Nil = errors.New("kv: nil")
+
+// 有序集合的成员
+type Z struct {
+	Member string
+	Score  float64
+}
+
+// 哈希和有序集合的操作，语义与Redis的同名命令相同
+type Ops interface {
+	HSet(key string, fieldValues ...string) error
+	HGet(key, field string) (string, error)
+	HDel(key string, fields ...string) error
+	HExists(key, field string) (bool, error)
+	HLen(key string) (int64, error)
+	HGetAll(key string) (map[string]string, error)
+	HKeys(key string) ([]string, error)
+	HVals(key string) ([]string, error)
+
+	ZAdd(key string, members ...Z) error
+	ZScore(key, member string) (float64, error)
+	ZIncrBy(key string, incr float64, member string) (float64, error)
+	ZRem(key string, members ...string) error
+	ZCard(key string) (int64, error)
+	ZRangeByScore(key string, min, max float64) ([]Z, error) // 按分数升序
+	ZRevRange(key string, start, stop int64) ([]Z, error)    // 按分数降序
+}
+
+type Store interface {
+	Ops
+
+	// 原子地执行脚本
+	Run(s *Script, keys []string, args ...any) (any, error)
+	// 使用不同的参数多次执行同一个脚本，Redis后端使用管道在一次往返中完成。
+	// 每次执行各自是原子的，返回的错误只表示连接等整体的失败
+	Batch(s *Script, keys []string, argsList [][]any) ([]Result, error)
+	// 集群模式下为键加上哈希标签，使同一个存储的所有键可以在同一个脚本中使用
+	HashTag(key string) string
+	Close() error
+}
+
+// 原子操作。Redis后端执行Lua脚本，其他后端持有锁执行Go函数，两者的行为必须一致。
+// 函数的参数与Lua脚本一样都是字符串，返回值使用int64、string以及由它们组成的[]any，
+// 不存在时返回Nil
+type Script struct {
+	lua *redis.Script
+	fn  func(ops Ops, keys []string, args []string) (any, error)
+}
+
+func NewScript(lua string, fn func(ops Ops, keys []string, args []string) (any, error)) *Script {
+	return &Script{lua: redis.NewScript(lua), fn: fn}
+}
+
+func stringArgs(args []any) []string {
+	strs := make([]string, len(args))
+	for i, a := range args {
+		strs[i] = fmt.Sprint(a)
+	}
+	return strs
+}
+
+// 批量执行中一次脚本的结果
+type Result struct {
+	Val any
+	Err error
+}
+
+// 将脚本的返回值转换为整数
+func Int(v any, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("kv: unexpected type %T for int64", v)
+	}
+	return n, nil
+}
+
+// 将脚本的返回值转换为字符串
+func Text(v any, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("kv: unexpected type %T for string", v)
+	}
+	return s, nil
+}
+
+// 将脚本返回的列表转换为字符串切片
+func Strings(v any, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	vs, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("kv: unexpected type %T for list", v)
+	}
+	strs := make([]string, 0, len(vs))
+	for _, v := range vs {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("kv: unexpected type %T for string", v)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
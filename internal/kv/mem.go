@@ -0,0 +1,271 @@
+package kv
+
+import (
+	"sort"
+)
+
+// 修改操作，文件后端按顺序追加写入
+type change struct {
+	Op    string  `json:"o"`           // hset、hdel、zset、zrem
+	Key   string  `json:"k"`           // 键
+	Field string  `json:"f"`           // 哈希的字段或者有序集合的成员
+	Value string  `json:"v,omitempty"` // 哈希的值
+	Score float64 `json:"s,omitempty"` // 有序集合的分数
+}
+
+// 内存中的哈希和有序集合，不是并发安全的。所有修改都记录在changes中，
+// 撤销每个修改的操作记录在undo中，写入日志失败时可以回滚
+type mem struct {
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]float64
+	changes []change
+	undo    []change
+}
+
+func newMem() *mem {
+	return &mem{
+		hashes: map[string]map[string]string{},
+		zsets:  map[string]map[string]float64{},
+	}
+}
+
+// 应用一个修改
+func (m *mem) apply(c change) {
+	switch c.Op {
+	case "hset":
+		h, ok := m.hashes[c.Key]
+		if !ok {
+			h = map[string]string{}
+			m.hashes[c.Key] = h
+		}
+		h[c.Field] = c.Value
+	case "hdel":
+		delete(m.hashes[c.Key], c.Field)
+		if len(m.hashes[c.Key]) == 0 {
+			delete(m.hashes, c.Key)
+		}
+	case "zset":
+		z, ok := m.zsets[c.Key]
+		if !ok {
+			z = map[string]float64{}
+			m.zsets[c.Key] = z
+		}
+		z[c.Field] = c.Score
+	case "zrem":
+		delete(m.zsets[c.Key], c.Field)
+		if len(m.zsets[c.Key]) == 0 {
+			delete(m.zsets, c.Key)
+		}
+	}
+}
+
+func (m *mem) record(c change) {
+	m.undo = append(m.undo, m.inverse(c))
+	m.apply(c)
+	m.changes = append(m.changes, c)
+}
+
+// 撤销修改c的操作
+func (m *mem) inverse(c change) change {
+	switch c.Op {
+	case "hset", "hdel":
+		if v, ok := m.hashes[c.Key][c.Field]; ok {
+			return change{Op: "hset", Key: c.Key, Field: c.Field, Value: v}
+		}
+		return change{Op: "hdel", Key: c.Key, Field: c.Field}
+	default:
+		if score, ok := m.zsets[c.Key][c.Field]; ok {
+			return change{Op: "zset", Key: c.Key, Field: c.Field, Score: score}
+		}
+		return change{Op: "zrem", Key: c.Key, Field: c.Field}
+	}
+}
+
+// 当前记录的修改数目，用于回滚到此处
+func (m *mem) mark() int {
+	return len(m.changes)
+}
+
+// 撤销mark之后记录的修改
+func (m *mem) rollback(mark int) {
+	for i := len(m.undo) - 1; i >= mark; i-- {
+		m.apply(m.undo[i])
+	}
+	m.changes = m.changes[:mark]
+	m.undo = m.undo[:mark]
+}
+
+// 取出并清空记录的修改
+func (m *mem) flush() []change {
+	cs := m.changes
+	m.changes = nil
+	m.undo = nil
+	return cs
+}
+
+// 重建当前状态所需的最少修改
+func (m *mem) snapshot() []change {
+	var cs []change
+	for k, h := range m.hashes {
+		for f, v := range h {
+			cs = append(cs, change{Op: "hset", Key: k, Field: f, Value: v})
+		}
+	}
+	for k, z := range m.zsets {
+		for member, score := range z {
+			cs = append(cs, change{Op: "zset", Key: k, Field: member, Score: score})
+		}
+	}
+	return cs
+}
+
+func (m *mem) size() int {
+	n := 0
+	for _, h := range m.hashes {
+		n += len(h)
+	}
+	for _, z := range m.zsets {
+		n += len(z)
+	}
+	return n
+}
+
+func (m *mem) HSet(key string, fieldValues ...string) error {
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		m.record(change{Op: "hset", Key: key, Field: fieldValues[i], Value: fieldValues[i+1]})
+	}
+	return nil
+}
+
+func (m *mem) HGet(key, field string) (string, error) {
+	v, ok := m.hashes[key][field]
+	if !ok {
+		return "", Nil
+	}
+	return v, nil
+}
+
+func (m *mem) HDel(key string, fields ...string) error {
+	for _, f := range fields {
+		if _, ok := m.hashes[key][f]; ok {
+			m.record(change{Op: "hdel", Key: key, Field: f})
+		}
+	}
+	return nil
+}
+
+func (m *mem) HExists(key, field string) (bool, error) {
+	_, ok := m.hashes[key][field]
+	return ok, nil
+}
+
+func (m *mem) HLen(key string) (int64, error) {
+	return int64(len(m.hashes[key])), nil
+}
+
+func (m *mem) HGetAll(key string) (map[string]string, error) {
+	res := make(map[string]string, len(m.hashes[key]))
+	for f, v := range m.hashes[key] {
+		res[f] = v
+	}
+	return res, nil
+}
+
+func (m *mem) HKeys(key string) ([]string, error) {
+	res := make([]string, 0, len(m.hashes[key]))
+	for f := range m.hashes[key] {
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+func (m *mem) HVals(key string) ([]string, error) {
+	res := make([]string, 0, len(m.hashes[key]))
+	for _, v := range m.hashes[key] {
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func (m *mem) ZAdd(key string, members ...Z) error {
+	for _, z := range members {
+		m.record(change{Op: "zset", Key: key, Field: z.Member, Score: z.Score})
+	}
+	return nil
+}
+
+func (m *mem) ZScore(key, member string) (float64, error) {
+	score, ok := m.zsets[key][member]
+	if !ok {
+		return 0, Nil
+	}
+	return score, nil
+}
+
+func (m *mem) ZIncrBy(key string, incr float64, member string) (float64, error) {
+	score := m.zsets[key][member] + incr
+	m.record(change{Op: "zset", Key: key, Field: member, Score: score})
+	return score, nil
+}
+
+func (m *mem) ZRem(key string, members ...string) error {
+	for _, member := range members {
+		if _, ok := m.zsets[key][member]; ok {
+			m.record(change{Op: "zrem", Key: key, Field: member})
+		}
+	}
+	return nil
+}
+
+func (m *mem) ZCard(key string) (int64, error) {
+	return int64(len(m.zsets[key])), nil
+}
+
+// 按分数升序排列，分数相同时按成员排序，与Redis一致
+func (m *mem) sorted(key string) []Z {
+	zs := make([]Z, 0, len(m.zsets[key]))
+	for member, score := range m.zsets[key] {
+		zs = append(zs, Z{Member: member, Score: score})
+	}
+	sort.Slice(zs, func(i, j int) bool {
+		if zs[i].Score != zs[j].Score {
+			return zs[i].Score < zs[j].Score
+		}
+		return zs[i].Member < zs[j].Member
+	})
+	return zs
+}
+
+func (m *mem) ZRangeByScore(key string, min, max float64) ([]Z, error) {
+	var res []Z
+	for _, z := range m.sorted(key) {
+		if z.Score >= min && z.Score <= max {
+			res = append(res, z)
+		}
+	}
+	return res, nil
+}
+
+func (m *mem) ZRevRange(key string, start, stop int64) ([]Z, error) {
+	zs := m.sorted(key)
+	for i, j := 0, len(zs)-1; i < j; i, j = i+1, j-1 {
+		zs[i], zs[j] = zs[j], zs[i]
+	}
+	n := int64(len(zs))
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []Z{}, nil
+	}
+	return zs[start : stop+1], nil
+}
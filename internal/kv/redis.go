@@ -0,0 +1,193 @@
+package kv
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type redisStore struct {
+	rdb   redis.UniversalClient // redis客户端
+	owned bool                  // 客户端由NewRedisWithOptions建立，Close时关闭
+}
+
+// 使用已有的Redis客户端，Close不会关闭该客户端
+func NewRedis(rdb redis.UniversalClient) (Store, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+
+	return &redisStore{rdb: rdb}, nil
+}
+
+func NewRedisWithOptions(opts *redis.UniversalOptions) (Store, error) {
+	rdb := redis.NewUniversalClient(opts)
+	s, err := NewRedis(rdb)
+	if err != nil {
+		rdb.Close()
+		return nil, err
+	}
+	s.(*redisStore).owned = true
+	return s, nil
+}
+
+// 将redis.Nil转换为Nil
+func redisErr(err error) error {
+	if err == redis.Nil {
+		return Nil
+	}
+	return err
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+func fromRedisZ(zs []redis.Z) []Z {
+	res := make([]Z, 0, len(zs))
+	for _, z := range zs {
+		res = append(res, Z{Member: z.Member.(string), Score: z.Score})
+	}
+	return res
+}
+
+func (s *redisStore) HSet(key string, fieldValues ...string) error {
+	values := make([]any, len(fieldValues))
+	for i, v := range fieldValues {
+		values[i] = v
+	}
+	return s.rdb.HSet(context.Background(), key, values...).Err()
+}
+
+func (s *redisStore) HGet(key, field string) (string, error) {
+	v, err := s.rdb.HGet(context.Background(), key, field).Result()
+	return v, redisErr(err)
+}
+
+func (s *redisStore) HDel(key string, fields ...string) error {
+	return s.rdb.HDel(context.Background(), key, fields...).Err()
+}
+
+func (s *redisStore) HExists(key, field string) (bool, error) {
+	return s.rdb.HExists(context.Background(), key, field).Result()
+}
+
+func (s *redisStore) HLen(key string) (int64, error) {
+	return s.rdb.HLen(context.Background(), key).Result()
+}
+
+func (s *redisStore) HGetAll(key string) (map[string]string, error) {
+	return s.rdb.HGetAll(context.Background(), key).Result()
+}
+
+func (s *redisStore) HKeys(key string) ([]string, error) {
+	return s.rdb.HKeys(context.Background(), key).Result()
+}
+
+func (s *redisStore) HVals(key string) ([]string, error) {
+	return s.rdb.HVals(context.Background(), key).Result()
+}
+
+func (s *redisStore) ZAdd(key string, members ...Z) error {
+	zs := make([]*redis.Z, 0, len(members))
+	for _, m := range members {
+		zs = append(zs, &redis.Z{Score: m.Score, Member: m.Member})
+	}
+	return s.rdb.ZAdd(context.Background(), key, zs...).Err()
+}
+
+func (s *redisStore) ZScore(key, member string) (float64, error) {
+	v, err := s.rdb.ZScore(context.Background(), key, member).Result()
+	return v, redisErr(err)
+}
+
+func (s *redisStore) ZIncrBy(key string, incr float64, member string) (float64, error) {
+	return s.rdb.ZIncrBy(context.Background(), key, incr, member).Result()
+}
+
+func (s *redisStore) ZRem(key string, members ...string) error {
+	ms := make([]any, len(members))
+	for i, m := range members {
+		ms[i] = m
+	}
+	return s.rdb.ZRem(context.Background(), key, ms...).Err()
+}
+
+func (s *redisStore) ZCard(key string) (int64, error) {
+	return s.rdb.ZCard(context.Background(), key).Result()
+}
+
+func (s *redisStore) ZRangeByScore(key string, min, max float64) ([]Z, error) {
+	zs, err := s.rdb.ZRangeByScoreWithScores(context.Background(), key, &redis.ZRangeBy{
+		Min: formatScore(min),
+		Max: formatScore(max),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return fromRedisZ(zs), nil
+}
+
+func (s *redisStore) ZRevRange(key string, start, stop int64) ([]Z, error) {
+	zs, err := s.rdb.ZRevRangeWithScores(context.Background(), key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	return fromRedisZ(zs), nil
+}
+
+func (s *redisStore) Run(sc *Script, keys []string, args ...any) (any, error) {
+	v, err := sc.lua.Run(context.Background(), s.rdb, keys, args...).Result()
+	return v, redisErr(err)
+}
+
+func (s *redisStore) Batch(sc *Script, keys []string, argsList [][]any) ([]Result, error) {
+	if len(argsList) == 0 {
+		return nil, nil
+	}
+	ctx := context.Background()
+	if err := sc.lua.Load(ctx, s.rdb).Err(); err != nil {
+		return nil, err
+	}
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.Cmd, len(argsList))
+	for i, args := range argsList {
+		cmds[i] = sc.lua.EvalSha(ctx, pipe, keys, args...)
+	}
+	// 脚本返回的错误属于各自的结果，其他错误表示整个管道失败
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		if _, ok := err.(redis.Error); !ok {
+			return nil, err
+		}
+	}
+	res := make([]Result, len(cmds))
+	for i, cmd := range cmds {
+		v, err := cmd.Result()
+		res[i] = Result{Val: v, Err: redisErr(err)}
+	}
+	return res, nil
+}
+
+func (s *redisStore) HashTag(key string) string {
+	return ClusterHashTag(s.rdb, key)
+}
+
+// 集群模式下为键加上哈希标签{key}，非集群模式下原样返回
+func ClusterHashTag(rdb redis.UniversalClient, key string) string {
+	if _, ok := rdb.(*redis.ClusterClient); ok {
+		return "{" + key + "}"
+	}
+	return key
+}
+
+func (s *redisStore) Close() error {
+	if s.owned {
+		return s.rdb.Close()
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package kv_test
+
+import (
+	"gospider/internal/kv"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestHashTag(t *testing.T) {
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:7000", "localhost:7001"}})
+	defer cluster.Close()
+
+	s, err := kv.OpenFile(t.TempDir() + "/pool.db")
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v\n", err)
+	}
+	defer s.Close()
+	if key := s.HashTag("spiderproxy"); key != "spiderproxy" {
+		t.Fatalf("HashTag failed: expect spiderproxy, get %s\n", key)
+	}
+	if key := kv.ClusterHashTag(cluster, "spiderproxy"); key != "{spiderproxy}" {
+		t.Fatalf("HashTag failed: expect {spiderproxy}, get %s\n", key)
+	}
+}
@@ -0,0 +1,88 @@
+// 代理地址的解析和每个代理的http.Transport缓存，由代理池、Cookie池和客户端共用，
+// 使它们不必相互导入。
+
+package proxyutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// 解析代理地址，没有协议时默认为http
+func ParseProxy(proxy string) (*url.URL, error) {
+	proxy = strings.TrimSpace(proxy)
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	uri, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy failed: %v", err)
+	}
+	return uri, nil
+}
+
+// 每个代理一个http.Transport的缓存，复用代理的连接。代理不断轮换，
+// 缓存的Transport超过Max个时关闭它们的空闲连接并清空缓存
+type TransportCache struct {
+	Base *http.Transport // 创建Transport时使用的模板，为nil时使用http.DefaultTransport
+	Max  int             // 缓存的Transport数目上限，默认为100
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func (c *TransportCache) max() int {
+	if c.Max > 0 {
+		return c.Max
+	}
+	return 100
+}
+
+// 获取代理的Transport，没有时创建
+func (c *TransportCache) Get(proxy string) (*http.Transport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tr, ok := c.transports[proxy]; ok {
+		return tr, nil
+	}
+	uri, err := ParseProxy(proxy)
+	if err != nil {
+		return nil, err
+	}
+	base := c.Base
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	tr := base.Clone()
+	tr.Proxy = http.ProxyURL(uri)
+	if c.transports == nil || len(c.transports) >= c.max() {
+		for _, tr := range c.transports {
+			tr.CloseIdleConnections()
+		}
+		c.transports = map[string]*http.Transport{}
+	}
+	c.transports[proxy] = tr
+	return tr, nil
+}
+
+// 关闭代理的空闲连接并从缓存中删除，例如代理失败后
+func (c *TransportCache) Drop(proxy string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tr, ok := c.transports[proxy]; ok {
+		tr.CloseIdleConnections()
+		delete(c.transports, proxy)
+	}
+}
+
+// 关闭所有代理的空闲连接
+func (c *TransportCache) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tr := range c.transports {
+		tr.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,34 @@
+package proxyutil_test
+
+import (
+	"gospider/internal/proxyutil"
+	"testing"
+)
+
+func TestTransportCache(t *testing.T) {
+	if uri, err := proxyutil.ParseProxy(" 1.1.1.1:80 "); err != nil || uri.String() != "http://1.1.1.1:80" {
+		t.Fatalf("ParseProxy failed: get %v %v\n", uri, err)
+	}
+
+	c := &proxyutil.TransportCache{Max: 2}
+	a, err := c.Get("1.1.1.1:80")
+	if err != nil {
+		t.Fatalf("Get failed: %v\n", err)
+	}
+	// 同一个代理复用Transport
+	if b, _ := c.Get("1.1.1.1:80"); b != a {
+		t.Fatalf("Get failed: expect the same transport\n")
+	}
+	// 删除后重新创建
+	c.Drop("1.1.1.1:80")
+	if b, _ := c.Get("1.1.1.1:80"); b == a {
+		t.Fatalf("Drop failed: expect a new transport\n")
+	}
+	// 超过上限时清空缓存
+	c.Get("2.2.2.2:80")
+	a, _ = c.Get("1.1.1.1:80")
+	c.Get("3.3.3.3:80")
+	if b, _ := c.Get("1.1.1.1:80"); b == a {
+		t.Fatalf("Get failed: expect the cache cleared after exceeding Max\n")
+	}
+}
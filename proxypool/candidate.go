@@ -0,0 +1,83 @@
+package proxypool
+
+// 候选队列。爬取的代理先进入候选队列，由调度器尽快验证，通过验证的代理才进入代理池，
+// 接口只提供通过过检测的代理。候选队列使用有序集合，分数为可以验证的时间，
+// 取出验证的代理在租期内不会被再次取出。
+
+import (
+	"gospider/internal/kv"
+	"time"
+)
+
+// 将代理加入候选队列，已经在代理池或者候选队列中的代理被忽略，返回新加入的代理数目
+func (s *Storage) AddCandidates(proxies []string) (int, error) {
+	resolved, err := s.resolveAll(proxies)
+	if err != nil {
+		return 0, err
+	}
+	t := unixNow()
+	argsList := make([][]any, 0, len(proxies))
+	for _, p := range resolved {
+		argsList = append(argsList, []any{p, t})
+	}
+	res, err := s.store.Batch(enqueueScript, s.keys(), argsList)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, r := range res {
+		if n, err := kv.Int(r.Val, r.Err); err == nil && n == 1 {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// 取出最多n个等待验证的候选代理，lease内没有提交结果的代理会被再次取出
+func (s *Storage) ClaimCandidates(n int, lease time.Duration) ([]string, error) {
+	now := time.Now()
+	return kv.Strings(s.store.Run(claimScript, s.keys()[3:4], unixTime(now), n, unixTime(now.Add(lease))))
+}
+
+// 提交候选代理的验证结果，通过的代理以最高分进入代理池，间隔interval后再次检测，
+// 没有通过的代理被丢弃。返回进入代理池的代理数目
+func (s *Storage) Promote(results []ScoreUpdate, interval time.Duration) (int, error) {
+	resolved, err := s.resolveAll(updateProxies(results))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	t, next := unixTime(now), unixMilli(now.Add(interval))
+	argsList := make([][]any, 0, len(results))
+	for i, r := range results {
+		ok := 0
+		if r.OK {
+			ok = 1
+		}
+		argsList = append(argsList, []any{resolved[i], ok, maxStorageScore, t, next})
+	}
+	res, err := s.store.Batch(promoteScript, s.keys(), argsList)
+	if err != nil {
+		return 0, err
+	}
+	promoted := 0
+	for _, r := range res {
+		if n, err := kv.Int(r.Val, r.Err); err == nil && n == 1 {
+			promoted++
+		}
+	}
+	return promoted, nil
+}
+
+// 计算候选队列中的代理数目
+func (s *Storage) CountCandidates() (int64, error) {
+	return s.store.ZCard(s.keys()[3])
+}
+
+// 为没有候选队列之前的代理池补充验证时间。当时代理直接进入代理池，分数高于初始分数的代理
+// 通过过检测，记录为在当前时间通过检测，否则升级后接口不再提供任何代理。
+// 已经有验证记录的代理池不做改变，返回补充的代理数目
+func (s *Storage) BackfillVerified() (int, error) {
+	n, err := kv.Int(s.store.Run(backfillScript, s.keys(), initStorageScore, maxStorageScore, unixNow()))
+	return int(n), err
+}
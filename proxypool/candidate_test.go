@@ -0,0 +1,124 @@
+package proxypool_test
+
+import (
+	"fmt"
+	"gospider/proxypool"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCandidates(t *testing.T) {
+	storage, err := proxypool.NewFileStorage(filepath.Join(t.TempDir(), "proxy.db"), "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	// 已经在代理池中的代理不会加入候选队列
+	storage.Add("1.1.1.1:80")
+	n, err := storage.AddCandidates([]string{"1.1.1.1:80", "2.2.2.2:80", "3.3.3.3:80", "2.2.2.2:80"})
+	if err != nil || n != 2 {
+		t.Fatalf("AddCandidates failed: expect 2, get %d %v\n", n, err)
+	}
+
+	// 没有通过检测的代理不会被提供
+	if p, err := storage.Random(); err == nil {
+		t.Fatalf("Random failed: unverified proxy %s is served\n", p)
+	}
+	if ps, _ := storage.RandomN(0, 0); len(ps) != 0 {
+		t.Fatalf("RandomN failed: unverified proxies %v are served\n", ps)
+	}
+
+	ps, err := storage.ClaimCandidates(10, time.Hour)
+	if err != nil || len(ps) != 2 {
+		t.Fatalf("ClaimCandidates failed: expect 2, get %v %v\n", ps, err)
+	}
+	if ps, _ := storage.ClaimCandidates(10, time.Hour); len(ps) != 0 {
+		t.Fatalf("ClaimCandidates failed: claimed candidates %v are claimed again\n", ps)
+	}
+
+	n, err = storage.Promote([]proxypool.ScoreUpdate{
+		{Proxy: "2.2.2.2:80", OK: true},
+		{Proxy: "3.3.3.3:80", OK: false},
+	}, time.Minute)
+	if err != nil || n != 1 {
+		t.Fatalf("Promote failed: expect 1, get %d %v\n", n, err)
+	}
+	if c, _ := storage.CountCandidates(); c != 0 {
+		t.Fatalf("Promote failed: expect empty candidates, get %d\n", c)
+	}
+	if p, err := storage.Random(); err != nil || p != "http://2.2.2.2:80" {
+		t.Fatalf("Random failed: expect http://2.2.2.2:80, get %s %v\n", p, err)
+	}
+	if ps, _ := storage.RandomN(0, 0); len(ps) != 1 || ps[0].Proxy != "http://2.2.2.2:80" {
+		t.Fatalf("RandomN failed: expect [http://2.2.2.2:80], get %v\n", ps)
+	}
+
+	// 租期过后没有提交结果的代理被重新取出
+	storage.AddCandidates([]string{"4.4.4.4:80"})
+	storage.ClaimCandidates(10, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if ps, _ := storage.ClaimCandidates(10, time.Hour); len(ps) != 1 || ps[0] != "http://4.4.4.4:80" {
+		t.Fatalf("ClaimCandidates failed: expect [http://4.4.4.4:80], get %v\n", ps)
+	}
+}
+
+func TestBackfillVerified(t *testing.T) {
+	// 没有验证记录的旧代理池
+	path := filepath.Join(t.TempDir(), "proxy.db")
+	legacy := `[{"o":"zset","k":"spiderproxy_test","f":"http://1.1.1.1:80","s":100},` +
+		`{"o":"zset","k":"spiderproxy_test","f":"http://2.2.2.2:80","s":10}]` + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v\n", err)
+	}
+	storage, err := proxypool.NewFileStorage(path, "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	if n, err := storage.BackfillVerified(); err != nil || n != 1 {
+		t.Fatalf("BackfillVerified failed: expect 1, get %d %v\n", n, err)
+	}
+	if p, err := storage.Random(); err != nil || p != "http://1.1.1.1:80" {
+		t.Fatalf("Random failed: expect http://1.1.1.1:80, get %s %v\n", p, err)
+	}
+	// 已经有验证记录时不再补充
+	storage.Add("3.3.3.3:80", 50)
+	if n, _ := storage.BackfillVerified(); n != 0 {
+		t.Fatalf("BackfillVerified failed: expect 0 after upgrade, get %d\n", n)
+	}
+}
+
+func TestRandomVerified(t *testing.T) {
+	storage, err := proxypool.NewFileStorage(filepath.Join(t.TempDir(), "proxy.db"), "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	// 最高分的代理很多时随机选择，只提供通过过检测的代理
+	verified := map[string]bool{}
+	for i := 0; i < 300; i++ {
+		p := fmt.Sprintf("http://10.0.%d.%d:80", i/250, i%250+1)
+		if i%2 == 0 {
+			storage.SetMax(p)
+			verified[p] = true
+		} else {
+			storage.Add(p, 100)
+		}
+	}
+	served := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		p, err := storage.Random()
+		if err != nil || !verified[p] {
+			t.Fatalf("Random failed: get %s %v\n", p, err)
+		}
+		served[p] = true
+	}
+	if len(served) < 10 {
+		t.Fatalf("Random failed: only %d different proxies served\n", len(served))
+	}
+}
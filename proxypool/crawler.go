@@ -2,10 +2,12 @@ package proxypool
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"gospider"
-	"io/ioutil"
+	"io"
+	"log"
 	"math/rand"
 	"mime/multipart"
 	"net/http"
@@ -20,6 +22,19 @@ import (
 
 // 用于获取可用的代理
 
+// 使用context控制的爬虫。Crawl开始爬取并返回代理通道，爬取结束、ctx取消或者超时时关闭通道。
+// 每次调用相互独立，可以并发调用
+type ContextCrawler interface {
+	Crawl(ctx context.Context) (<-chan string, error)
+}
+
+type ContextCrawlerFunc func(ctx context.Context) (<-chan string, error)
+
+func (f ContextCrawlerFunc) Crawl(ctx context.Context) (<-chan string, error) {
+	return f(ctx)
+}
+
+// 旧的爬虫接口，使用Stoppable和FromCrawler与ContextCrawler相互转换
 type CrawlerFunc func() <-chan string
 
 func (f CrawlerFunc) Crawl() <-chan string {
@@ -35,156 +50,256 @@ type StoppableCrawler interface {
 	Stop()
 }
 
+// 默认爬虫的名称和构造函数
+var defaultCrawlers = []struct {
+	name string
+	new  func() ContextCrawler
+}{
+	{"kdl", func() ContextCrawler { return NewkdlContextCrawler(60*60, 5, 2000) }},
+	{"ip89", func() ContextCrawler { return Newip89ContextCrawler(60*60, 5) }},
+	{"ip3366", func() ContextCrawler { return Newip3366ContextCrawler(60*60, 5) }},
+	{"ihuan", func() ContextCrawler { return NewihuanContextCrawler(60*60, 5, 2000) }},
+	{"kx", func() ContextCrawler { return NewkxContextCrawler(60*60, 5) }},
+	{"zdy", func() ContextCrawler { return NewzdyContextCrawler(60*60, 5) }},
+	{"xsdl", func() ContextCrawler { return NewxsdlContextCrawler(60*60, 5) }},
+	{"mimvp", func() ContextCrawler { return NewmimvpContextCrawler(60*60, 5) }},
+	{"yqie", func() ContextCrawler { return NewyqieContextCrawler() }},
+	{"ffseo", func() ContextCrawler { return NewffseoContextCrawler() }},
+}
+
+var DefaultContextCrawlers []ContextCrawler
 var DefaultCrawlers []Crawler
 var DefaultStoppableCrawlers []StoppableCrawler
 
 func init() {
 	soup.Header("User-Agent", gospider.UserAgent)
 
-	DefaultStoppableCrawlers = append(
-		DefaultStoppableCrawlers,
-		NewkdlCrawler(60*60, 5, 2000),
-		Newip89Crawler(60*60, 5),
-		Newip3366Crawler(60*60, 5),
-		NewihuanCrawler(60*60, 5, 2000),
-		NewkxCrawler(60*60, 5),
-		NewzdyCrawler(60*60, 5),
-		NewxsdlCrawler(60*60, 5),
-		NewmimvpCrawler(60*60, 5),
-	)
-
-	for _, c := range DefaultStoppableCrawlers {
-		DefaultCrawlers = append(DefaultCrawlers, c)
+	for _, d := range defaultCrawlers {
+		DefaultContextCrawlers = append(DefaultContextCrawlers, d.new())
 	}
-	DefaultCrawlers = append(
-		DefaultCrawlers,
-		NewyqieCrawler(),
-		NewffseoCrawler(),
-	)
-}
 
-type inBaseCrawler struct {
-	timeout  time.Duration
-	interval time.Duration // 获取每一页网页的时间间隔
-	maxnum   int           // 获取最大的代理数目。当其为0或负数时，最大代理数目由网站提供
-
-	parse func() // 解析网页
+	for _, c := range DefaultContextCrawlers {
+		sc := Stoppable(c)
+		DefaultStoppableCrawlers = append(DefaultStoppableCrawlers, sc)
+		DefaultCrawlers = append(DefaultCrawlers, sc)
+	}
+}
 
-	cwg sync.WaitGroup // crawl等待
-	twg sync.WaitGroup // 定时器等待
-	swg sync.WaitGroup // stop等待
+// 将ContextCrawler转换为旧的StoppableCrawler。爬取进行中再次调用Crawl返回同一个通道，
+// Stop取消爬取并等待爬取结束
+func Stoppable(c ContextCrawler) StoppableCrawler {
+	return &stoppableCrawler{c: c}
+}
 
-	proxyCh chan string
-	abortCh chan struct{}
+type stoppableCrawler struct {
+	c ContextCrawler
 
-	initf  bool         // 开始爬取的初始化标志
-	finalf bool         // 结束爬取的标志
-	abortf bool         // 放弃标志
-	mutf   sync.RWMutex // 状态锁
+	mu     sync.Mutex
+	ch     chan string
+	cancel context.CancelFunc
+	done   chan struct{} // 爬取结束时关闭
 }
 
-func (base *inBaseCrawler) crawl() {
-
-	base.cwg.Add(1)
-	go func() {
-		defer base.cwg.Done()
-		if base.parse == nil {
-			return
+func (s *stoppableCrawler) Crawl() <-chan string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done != nil {
+		select {
+		case <-s.done:
+		default:
+			return s.ch
 		}
-		base.parse()
-	}()
+	}
 
-	// 关闭go协程，防止资源泄露
-	base.swg.Add(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan string)
+	done := make(chan struct{})
+	s.ch, s.cancel, s.done = out, cancel, done
+
+	in, err := s.c.Crawl(ctx)
+	if err != nil {
+		cancel()
+		close(out)
+		close(done)
+		return out
+	}
 	go func() {
-		defer base.swg.Done()
-
-		// 等待crawl中的go协程完成
-		base.cwg.Wait()
-		close(base.proxyCh)
-
-		// 修改结束状态
-		base.mutf.Lock()
-		base.finalf = true
-		base.mutf.Unlock()
-
-		// 等待计时器协程完成
-		base.twg.Wait()
-
-		// 修改初始化状态
-		base.mutf.Lock()
-		base.initf = false
-		base.mutf.Unlock()
+		defer close(done)
+		defer close(out)
+		defer cancel()
+		for proxy := range in {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- proxy:
+			}
+		}
 	}()
+	return out
 }
 
-func (base *inBaseCrawler) Crawl() <-chan string {
-	base.mutf.Lock()
-	defer base.mutf.Unlock()
-	if base.initf {
-		return base.proxyCh
+func (s *stoppableCrawler) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
 	}
+}
 
-	base.finalf = false
-	base.abortf = false
-
-	base.proxyCh = make(chan string, 5)
-	base.abortCh = make(chan struct{})
-
-	if base.timeout > 0 {
-		base.twg.Add(1)
+// 将旧的爬虫转换为ContextCrawler，ctx取消时停止转发，爬虫满足StoppableCrawler时调用Stop
+func FromCrawler(c Crawler) ContextCrawler {
+	if s, ok := c.(*stoppableCrawler); ok {
+		return s.c
+	}
+	return ContextCrawlerFunc(func(ctx context.Context) (<-chan string, error) {
+		in := c.Crawl()
+		out := make(chan string)
 		go func() {
-			defer base.twg.Done()
-			tick := time.After(base.timeout)
-
+			defer close(out)
 			for {
+				var proxy string
+				var ok bool
 				select {
-				case <-tick:
-					base.stop()
-					return
-				default:
-					base.mutf.RLock()
-					if base.finalf {
-						base.mutf.RUnlock()
+				case <-ctx.Done():
+				case proxy, ok = <-in:
+					if !ok {
 						return
 					}
-					base.mutf.RUnlock()
+					select {
+					case <-ctx.Done():
+					case out <- proxy:
+						continue
+					}
+				}
+				// 先在后台读完，Stop等待爬虫结束时不会因为发送代理而阻塞，不能停止的爬虫也不会阻塞
+				go func() {
+					for range in {
+					}
+				}()
+				if s, ok := c.(StoppableCrawler); ok {
+					s.Stop()
 				}
+				return
 			}
-
 		}()
-	}
+		return out, nil
+	})
+}
+
+// 旧的构造函数，返回旧的爬虫接口，新代码使用对应的NewXxxContextCrawler
+func NewkdlCrawler(timeout, interval, maxnum int) StoppableCrawler {
+	return Stoppable(NewkdlContextCrawler(timeout, interval, maxnum))
+}
 
-	base.crawl()
+func Newip89Crawler(timeout, interval int) StoppableCrawler {
+	return Stoppable(Newip89ContextCrawler(timeout, interval))
+}
 
-	base.initf = true
+func Newip3366Crawler(timeout, interval int) StoppableCrawler {
+	return Stoppable(Newip3366ContextCrawler(timeout, interval))
+}
 
-	return base.proxyCh
+func NewihuanCrawler(timeout, interval, maxnum int) StoppableCrawler {
+	return Stoppable(NewihuanContextCrawler(timeout, interval, maxnum))
 }
 
-func (base *inBaseCrawler) stop() {
-	base.mutf.RLock()
-	if !base.abortf {
-		close(base.abortCh) // 对子go协程进行广播，如果不关闭此通道，协程也会正常退出
-		base.abortf = true
+func NewkxCrawler(timeout, interval int) StoppableCrawler {
+	return Stoppable(NewkxContextCrawler(timeout, interval))
+}
+
+func NewzdyCrawler(timeout, interval int) StoppableCrawler {
+	return Stoppable(NewzdyContextCrawler(timeout, interval))
+}
+
+func NewxsdlCrawler(timeout, interval int) StoppableCrawler {
+	return Stoppable(NewxsdlContextCrawler(timeout, interval))
+}
+
+func NewmimvpCrawler(timeout, interval int) StoppableCrawler {
+	return Stoppable(NewmimvpContextCrawler(timeout, interval))
+}
+
+func NewyqieCrawler() CrawlerFunc {
+	return Stoppable(NewyqieContextCrawler()).Crawl
+}
+
+func NewffseoCrawler() CrawlerFunc {
+	return Stoppable(NewffseoContextCrawler()).Crawl
+}
+
+// 发送代理，ctx取消时返回false
+func send(ctx context.Context, proxyCh chan<- string, proxy string) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case proxyCh <- proxy:
+		return true
 	}
-	base.mutf.RUnlock()
 }
 
-func (base *inBaseCrawler) Stop() {
-	base.stop()
-	base.swg.Wait()
+// 逐页爬取网页的爬虫
+type pageCrawler struct {
+	timeout  time.Duration // 每次爬取的超时，为0时不限制
+	interval time.Duration // 获取每一页网页的时间间隔
+	maxnum   int           // 获取最大的代理数目。当其为0或负数时，最大代理数目由网站提供
+
+	parse func(ctx context.Context, proxyCh chan<- string) error // 解析网页，ctx取消时返回
 }
 
-// kdl公共代理
-func NewkdlCrawler(timeout, interval, maxnum int) *inBaseCrawler {
-	kdl := &inBaseCrawler{
+func newPageCrawler(timeout, interval, maxnum int) *pageCrawler {
+	return &pageCrawler{
 		timeout:  time.Duration(timeout) * time.Second,
 		interval: time.Duration(interval) * time.Second,
 		maxnum:   maxnum,
 	}
+}
+
+func (c *pageCrawler) CrawlErrors(ctx context.Context) (<-chan string, <-chan error, error) {
+	if c.parse == nil {
+		return nil, nil, fmt.Errorf("crawler has no parser")
+	}
+	var cancel context.CancelFunc
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	proxyCh := make(chan string, 5)
+	errCh := make(chan error, 1)
+	go func() {
+		defer cancel()
+		defer close(errCh)
+		err := c.parse(ctx, proxyCh)
+		close(proxyCh)
+		if err != nil && ctx.Err() == nil {
+			errCh <- err
+		}
+	}()
+	return proxyCh, errCh, nil
+}
 
-	kdl.parse = func() {
+// 爬取代理，错误只记录日志
+func (c *pageCrawler) Crawl(ctx context.Context) (<-chan string, error) {
+	proxyCh, errCh, err := c.CrawlErrors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for err := range errCh {
+			log.Printf("crawl failed: %v\n", err)
+		}
+	}()
+	return proxyCh, nil
+}
+
+// kdl公共代理
+func NewkdlContextCrawler(timeout, interval, maxnum int) ContextCrawler {
+	kdl := newPageCrawler(timeout, interval, maxnum)
+
+	kdl.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "https://www.kuaidaili.com/free/"
 			inhaURL  = startURL + "inha/" // 国内高匿http代理
@@ -192,6 +307,7 @@ func NewkdlCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 		)
 
 		var num = 0 // count number of proxies
+		var lastErr error
 
 	mainloop:
 		for _, u := range []string{inhaURL, intrURL} {
@@ -201,35 +317,32 @@ func NewkdlCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 		pageloop:
 			for {
 				select {
-				case <-kdl.abortCh:
-					return
+				case <-ctx.Done():
+					return nil
 				default:
 					url := u + strconv.Itoa(page) + "/"
 
-					html, err := soup.Get(url)
+					html, err := fetch(ctx, url)
 					if html == "Invalid Page" {
 						break pageloop
 					}
+					// 获取失败时跳过这一类代理，继续爬取下一类
 					if err != nil {
-						select {
-						case <-time.After(1 * time.Second):
-							continue pageloop
-						case <-kdl.abortCh:
-							return
-						}
+						lastErr = err
+						continue mainloop
 					}
 
 					doc := soup.HTMLParse(html)
 					if doc.Error != nil {
-						break pageloop
+						return layoutError(url, doc.Error)
 					}
 					table := doc.FindStrict("table", "class", "table table-bordered table-striped")
 					if table.Error != nil {
-						break pageloop
+						return layoutError(url, table.Error)
 					}
 					tbody := table.Find("tbody")
 					if tbody.Error != nil {
-						break pageloop
+						return layoutError(url, tbody.Error)
 					}
 					for _, tr := range tbody.FindAll("tr") {
 						if tr.Error != nil {
@@ -243,18 +356,18 @@ func NewkdlCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 						addr := fmt.Sprintf("%s://%s:%s", typ, ip, port)
 
 						select {
-						case <-kdl.abortCh:
-							return
-						case kdl.proxyCh <- addr:
+						case <-ctx.Done():
+							return nil
+						case proxyCh <- addr:
 							num++
 							if kdl.maxnum > 0 && num >= kdl.maxnum {
-								return
+								return nil
 							}
 						}
 					}
 
 					select {
-					case <-kdl.abortCh:
+					case <-ctx.Done():
 						break mainloop
 					case <-time.After(kdl.interval + time.Second*time.Duration(rand.Intn(5))):
 						page++
@@ -264,59 +377,51 @@ func NewkdlCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 			}
 		}
 
+		return lastErr
 	}
 
 	return kdl
 }
 
 // 89ip公共代理
-func Newip89Crawler(timeout, interval int) *inBaseCrawler {
-	ip89 := &inBaseCrawler{
-		timeout:  time.Duration(timeout) * time.Second,
-		interval: time.Duration(interval) * time.Second,
-	}
+func Newip89ContextCrawler(timeout, interval int) ContextCrawler {
+	ip89 := newPageCrawler(timeout, interval, 0)
 
-	ip89.parse = func() {
+	ip89.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "https://www.89ip.cn/"
 		)
 
 		page := 1 // web page
 
-	loop:
 		for {
 			select {
-			case <-ip89.abortCh:
-				return
+			case <-ctx.Done():
+				return nil
 			default:
 
 				url := startURL + "index_" + strconv.Itoa(page) + ".html"
 
-				html, err := soup.Get(url)
+				html, err := fetch(ctx, url)
 				if err != nil {
-					select {
-					case <-time.After(1 * time.Second):
-						continue loop
-					case <-ip89.abortCh:
-						return
-					}
+					return err
 				}
 
 				doc := soup.HTMLParse(html)
 				if doc.Error != nil {
-					return
+					return layoutError(url, doc.Error)
 				}
 				table := doc.FindStrict("table", "class", "layui-table")
 				if table.Error != nil {
-					return
+					return layoutError(url, table.Error)
 				}
 				tbody := table.Find("tbody")
 				if tbody.Error != nil {
-					return
+					return layoutError(url, tbody.Error)
 				}
 				trs := tbody.FindAll("tr")
 				if len(trs) == 0 {
-					return
+					return nil
 				}
 
 				for _, tr := range trs {
@@ -328,16 +433,16 @@ func Newip89Crawler(timeout, interval int) *inBaseCrawler {
 						ip := strings.TrimSpace(tds[0].Text())
 						port := strings.TrimSpace(tds[1].Text())
 						select {
-						case <-ip89.abortCh:
-							return
-						case ip89.proxyCh <- fmt.Sprintf("%s:%s", ip, port):
+						case <-ctx.Done():
+							return nil
+						case proxyCh <- fmt.Sprintf("%s:%s", ip, port):
 						}
 					}
 				}
 
 				select {
-				case <-ip89.abortCh:
-					return
+				case <-ctx.Done():
+					return nil
 				case <-time.After(ip89.interval + time.Second*time.Duration(rand.Intn(5))):
 					page++
 				}
@@ -350,105 +455,92 @@ func Newip89Crawler(timeout, interval int) *inBaseCrawler {
 }
 
 // yqie公共代理
-func NewyqieCrawler() CrawlerFunc {
-	f := func() <-chan string {
+func NewyqieContextCrawler() ContextCrawler {
+	yqie := newPageCrawler(0, 0, 0)
+
+	yqie.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "http://ip.yqie.com/ipproxy.htm"
 		)
-		proxyCh := make(chan string, 5)
 
-		var wg sync.WaitGroup
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			s, err := soup.Get(startURL)
-			if err != nil {
-				return
+		s, err := fetch(ctx, startURL)
+		if err != nil {
+			return err
+		}
+		doc := soup.HTMLParse(s)
+		if doc.Error != nil {
+			return layoutError(startURL, doc.Error)
+		}
+		tables := doc.FindAll("table", "id", "GridViewOrder")
+		if len(tables) == 0 {
+			return layoutError(startURL, fmt.Errorf("table GridViewOrder not found"))
+		}
+		for _, table := range tables {
+			if table.Error != nil {
+				continue
 			}
-			doc := soup.HTMLParse(s)
-			if doc.Error != nil {
-				return
+			tbody := table.Find("tbody")
+			if tbody.Error != nil {
+				continue
 			}
-			for _, table := range doc.FindAll("table", "id", "GridViewOrder") {
-				if table.Error != nil {
-					continue
-				}
-				tbody := table.Find("tbody")
-				if tbody.Error != nil {
+			for _, tr := range tbody.FindAll("tr") {
+				tds := tr.FindAll("td")
+				if len(tds) == 0 {
 					continue
 				}
-				for _, tr := range tbody.FindAll("tr") {
-					tds := tr.FindAll("td")
-					if len(tds) == 0 {
-						continue
-					}
-					if len(tds) >= 6 {
-						ip := tds[0].Text()
-						port := tds[1].Text()
-						typ := strings.ToLower(tds[4].Text())
-						proxyCh <- fmt.Sprintf("%s://%s:%s", typ, ip, port)
+				if len(tds) >= 6 {
+					ip := tds[0].Text()
+					port := tds[1].Text()
+					typ := strings.ToLower(tds[4].Text())
+					if !send(ctx, proxyCh, fmt.Sprintf("%s://%s:%s", typ, ip, port)) {
+						return nil
 					}
 				}
 			}
-		}()
-
-		go func() {
-			wg.Wait()
-			close(proxyCh)
-		}()
-
-		return proxyCh
+		}
+		return nil
 	}
-	return CrawlerFunc(f)
+
+	return yqie
 }
 
 // ip3366公共代理
-func Newip3366Crawler(timeout, interval int) *inBaseCrawler {
-	ip3366 := &inBaseCrawler{
-		timeout:  time.Duration(timeout) * time.Second,
-		interval: time.Duration(interval) * time.Second,
-	}
+func Newip3366ContextCrawler(timeout, interval int) ContextCrawler {
+	ip3366 := newPageCrawler(timeout, interval, 0)
 
-	ip3366.parse = func() {
+	ip3366.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "http://www.ip3366.net/?stype=1"
 		)
 
 		page := 1
-	loop:
 		for page <= 10 {
 			select {
-			case <-ip3366.abortCh:
-				return
+			case <-ctx.Done():
+				return nil
 			default:
 				url := startURL + "&page=" + strconv.Itoa(page)
 
-				html, err := soup.Get(url)
+				html, err := fetch(ctx, url)
 				if err != nil {
-					select {
-					case <-time.After(1 * time.Second):
-						continue loop
-					case <-ip3366.abortCh:
-						return
-					}
+					return err
 				}
 
 				doc := soup.HTMLParse(html)
 				if doc.Error != nil {
-					return
+					return layoutError(url, doc.Error)
 				}
 				table := doc.FindStrict("table", "class", "table table-bordered table-striped")
 				if table.Error != nil {
-					return
+					return layoutError(url, table.Error)
 				}
 				tbody := table.Find("tbody")
 				if tbody.Error != nil {
-					return
+					return layoutError(url, tbody.Error)
 				}
 				trs := tbody.FindAll("tr")
 				if len(trs) == 0 {
-					return
+					return nil
 				}
 
 				for _, tr := range trs {
@@ -461,16 +553,16 @@ func Newip3366Crawler(timeout, interval int) *inBaseCrawler {
 						port := strings.TrimSpace(tds[1].Text())
 						typ := strings.ToLower(strings.TrimSpace(tds[3].Text()))
 						select {
-						case <-ip3366.abortCh:
-							return
-						case ip3366.proxyCh <- fmt.Sprintf("%s://%s:%s", typ, ip, port):
+						case <-ctx.Done():
+							return nil
+						case proxyCh <- fmt.Sprintf("%s://%s:%s", typ, ip, port):
 						}
 					}
 				}
 
 				select {
-				case <-ip3366.abortCh:
-					return
+				case <-ctx.Done():
+					return nil
 				case <-time.After(ip3366.interval + time.Second*time.Duration(rand.Intn(5))):
 					page++
 				}
@@ -478,20 +570,17 @@ func Newip3366Crawler(timeout, interval int) *inBaseCrawler {
 			}
 		}
 
+		return nil
 	}
 
 	return ip3366
 }
 
 // 小幻公共代理
-func NewihuanCrawler(timeout, interval, maxnum int) *inBaseCrawler {
-	ihuan := &inBaseCrawler{
-		timeout:  time.Duration(timeout) * time.Second,
-		interval: time.Duration(interval) * time.Second,
-		maxnum:   maxnum,
-	}
+func NewihuanContextCrawler(timeout, interval, maxnum int) ContextCrawler {
+	ihuan := newPageCrawler(timeout, interval, maxnum)
 
-	ihuan.parse = func() {
+	ihuan.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "https://ip.ihuan.me/"
 		)
@@ -502,38 +591,32 @@ func NewihuanCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 		url := startURL
 		num := 0
 
-	loop:
 		for {
 			select {
-			case <-ihuan.abortCh:
-				return
+			case <-ctx.Done():
+				return nil
 			default:
 
-				html, err := soup.Get(url)
+				html, err := fetch(ctx, url)
 				if err != nil {
-					select {
-					case <-time.After(1 * time.Second):
-						continue loop
-					case <-ihuan.abortCh:
-						return
-					}
+					return err
 				}
 
 				doc := soup.HTMLParse(html)
 				if doc.Error != nil {
-					return
+					return layoutError(url, doc.Error)
 				}
 				table := doc.FindStrict("table", "class", "table table-hover table-bordered")
 				if table.Error != nil {
-					return
+					return layoutError(url, table.Error)
 				}
 				tbody := table.Find("tbody")
 				if tbody.Error != nil {
-					return
+					return layoutError(url, tbody.Error)
 				}
 				trs := tbody.FindAll("tr")
 				if len(trs) == 0 {
-					return
+					return nil
 				}
 
 				for _, tr := range trs {
@@ -551,12 +634,12 @@ func NewihuanCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 							typ = "http://"
 						}
 						select {
-						case <-ihuan.abortCh:
-							return
-						case ihuan.proxyCh <- fmt.Sprintf("%s%s:%s", typ, ip, port):
+						case <-ctx.Done():
+							return nil
+						case proxyCh <- fmt.Sprintf("%s%s:%s", typ, ip, port):
 							num++
 							if ihuan.maxnum > 0 && num >= ihuan.maxnum {
-								return
+								return nil
 							}
 						}
 					}
@@ -564,11 +647,11 @@ func NewihuanCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 
 				pagination := doc.FindStrict("ul", "class", "pagination")
 				if pagination.Error != nil {
-					return
+					return layoutError(url, pagination.Error)
 				}
 				for i, li := range pagination.FindAll("li") {
 					if li.Error != nil {
-						return
+						return layoutError(url, li.Error)
 					}
 					if i == 0 {
 						continue
@@ -587,8 +670,8 @@ func NewihuanCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 				delete(pagemap, strconv.Itoa(page))
 
 				select {
-				case <-ihuan.abortCh:
-					return
+				case <-ctx.Done():
+					return nil
 				case <-time.After(ihuan.interval + time.Second*time.Duration(rand.Intn(5))):
 					page++
 					url = startURL + pagemap[strconv.Itoa(page)]
@@ -603,13 +686,10 @@ func NewihuanCrawler(timeout, interval, maxnum int) *inBaseCrawler {
 }
 
 // 开心公共代理
-func NewkxCrawler(timeout, interval int) *inBaseCrawler {
-	kx := &inBaseCrawler{
-		timeout:  time.Duration(timeout) * time.Second,
-		interval: time.Duration(interval) * time.Second,
-	}
+func NewkxContextCrawler(timeout, interval int) ContextCrawler {
+	kx := newPageCrawler(timeout, interval, 0)
 
-	kx.parse = func() {
+	kx.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "http://www.kxdaili.com/dailiip"
 		)
@@ -618,39 +698,33 @@ func NewkxCrawler(timeout, interval int) *inBaseCrawler {
 
 			page := 1
 
-		pageloop:
 			for page <= 10 {
 				select {
-				case <-kx.abortCh:
-					return
+				case <-ctx.Done():
+					return nil
 				default:
 					url := fmt.Sprintf("%s/%d/%d.html", startURL, i, page)
 
-					html, err := soup.Get(url)
+					html, err := fetch(ctx, url)
 					if err != nil {
-						select {
-						case <-time.After(1 * time.Second):
-							continue pageloop
-						case <-kx.abortCh:
-							return
-						}
+						return err
 					}
 
 					doc := soup.HTMLParse(html)
 					if doc.Error != nil {
-						return
+						return layoutError(url, doc.Error)
 					}
 					table := doc.FindStrict("table", "class", "active")
 					if table.Error != nil {
-						return
+						return layoutError(url, table.Error)
 					}
 					tbody := table.Find("tbody")
 					if tbody.Error != nil {
-						return
+						return layoutError(url, tbody.Error)
 					}
 					trs := tbody.FindAll("tr")
 					if len(trs) == 0 {
-						return
+						return nil
 					}
 
 					for _, tr := range trs {
@@ -669,16 +743,16 @@ func NewkxCrawler(timeout, interval int) *inBaseCrawler {
 							}
 
 							select {
-							case <-kx.abortCh:
-								return
-							case kx.proxyCh <- fmt.Sprintf("%s%s:%s", typ, ip, port):
+							case <-ctx.Done():
+								return nil
+							case proxyCh <- fmt.Sprintf("%s%s:%s", typ, ip, port):
 							}
 						}
 					}
 
 					select {
-					case <-kx.abortCh:
-						return
+					case <-ctx.Done():
+						return nil
 					case <-time.After(kx.interval + time.Second*time.Duration(rand.Intn(5))):
 						page++
 					}
@@ -687,19 +761,17 @@ func NewkxCrawler(timeout, interval int) *inBaseCrawler {
 			}
 
 		}
+		return nil
 	}
 
 	return kx
 }
 
 // 站大爷公共代理
-func NewzdyCrawler(timeout, interval int) *inBaseCrawler {
-	zdy := &inBaseCrawler{
-		timeout:  time.Duration(timeout) * time.Second,
-		interval: time.Duration(interval) * time.Second,
-	}
+func NewzdyContextCrawler(timeout, interval int) ContextCrawler {
+	zdy := newPageCrawler(timeout, interval, 0)
 
-	zdy.parse = func() {
+	zdy.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "https://www.zdaye.com"
 		)
@@ -710,36 +782,31 @@ func NewzdyCrawler(timeout, interval int) *inBaseCrawler {
 		url := fmt.Sprintf("%s/dayProxy/%d/%d/1.html", startURL, now.Year(), int(now.Month()))
 	ploop:
 		for {
-			html, err := soup.Get(url)
+			html, err := fetch(ctx, url)
 			if err != nil {
-				select {
-				case <-zdy.abortCh:
-					return
-				case <-time.After(2 * time.Second):
-					continue ploop
-				}
+				return err
 			}
 			doc := soup.HTMLParse(html)
 			if doc.Error != nil {
-				return
+				return layoutError(url, doc.Error)
 			}
 			title := doc.Find("h3", "class", "thread_title")
 			if title.Error != nil {
-				return
+				return layoutError(url, title.Error)
 			}
 			a := title.Find("a")
 			if a.Error != nil {
-				return
+				return layoutError(url, a.Error)
 			}
 			href := a.Attrs()["href"]
 			if href == "" {
-				return
+				return layoutError(url, fmt.Errorf("no link to the latest proxies"))
 			}
 			href = href[:len(href)-5]
 			sp := strings.Split(href, "/")
 			ind, err := strconv.Atoi(sp[len(sp)-1])
 			if err != nil {
-				return
+				return layoutError(url, err)
 			}
 			newdateindex = ind
 			break ploop
@@ -755,26 +822,21 @@ func NewzdyCrawler(timeout, interval int) *inBaseCrawler {
 		pageloop:
 			for {
 				url = indURL + strconv.Itoa(page) + ".html"
-				html, err := soup.Get(url)
+				html, err := fetch(ctx, url)
 				if err != nil {
-					select {
-					case <-zdy.abortCh:
-						return
-					case <-time.After(2 * time.Second):
-						continue pageloop
-					}
+					return err
 				}
 				doc := soup.HTMLParse(html)
 				if doc.Error != nil {
-					break pageloop
+					return layoutError(url, doc.Error)
 				}
 				ipc := doc.FindStrict("table", "id", "ipc")
 				if ipc.Error != nil {
-					break pageloop
+					return layoutError(url, ipc.Error)
 				}
 				tbody := ipc.Find("tbody")
 				if tbody.Error != nil {
-					break pageloop
+					return layoutError(url, tbody.Error)
 				}
 				trs := tbody.FindAll("tr")
 				if len(trs) == 0 {
@@ -790,34 +852,33 @@ func NewzdyCrawler(timeout, interval int) *inBaseCrawler {
 						port := strings.TrimSpace(tds[1].Text())
 						typ := strings.ToLower(strings.TrimSpace(tds[2].Text()))
 						select {
-						case <-zdy.abortCh:
-							return
-						case zdy.proxyCh <- fmt.Sprintf("%s://%s:%s", typ, ip, port):
+						case <-ctx.Done():
+							return nil
+						case proxyCh <- fmt.Sprintf("%s://%s:%s", typ, ip, port):
 						}
 					}
 				}
 				select {
-				case <-zdy.abortCh:
-					return
+				case <-ctx.Done():
+					return nil
 				case <-time.After(zdy.interval + time.Second*time.Duration(rand.Intn(5))):
 					page++
 				}
 			}
+			index++
 		}
 
+		return nil
 	}
 
 	return zdy
 }
 
 // 小舒公共代理
-func NewxsdlCrawler(timeout, interval int) *inBaseCrawler {
-	xsdl := &inBaseCrawler{
-		timeout:  time.Duration(timeout) * time.Second,
-		interval: time.Duration(interval) * time.Second,
-	}
+func NewxsdlContextCrawler(timeout, interval int) ContextCrawler {
+	xsdl := newPageCrawler(timeout, interval, 0)
 
-	xsdl.parse = func() {
+	xsdl.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "https://www.xsdaili.cn"
 		)
@@ -827,36 +888,31 @@ func NewxsdlCrawler(timeout, interval int) *inBaseCrawler {
 		url := fmt.Sprintf("%s/dayProxy/1.html", startURL)
 	ploop:
 		for {
-			html, err := soup.Get(url)
+			html, err := fetch(ctx, url)
 			if err != nil {
-				select {
-				case <-xsdl.abortCh:
-					return
-				case <-time.After(2 * time.Second):
-					continue ploop
-				}
+				return err
 			}
 			doc := soup.HTMLParse(html)
 			if doc.Error != nil {
-				return
+				return layoutError(url, doc.Error)
 			}
 			title := doc.Find("div", "class", "title")
 			if title.Error != nil {
-				return
+				return layoutError(url, title.Error)
 			}
 			a := title.Find("a")
 			if a.Error != nil {
-				return
+				return layoutError(url, a.Error)
 			}
 			href := a.Attrs()["href"]
 			if href == "" {
-				return
+				return layoutError(url, fmt.Errorf("no link to the latest proxies"))
 			}
 			href = href[:len(href)-5]
 			sp := strings.Split(href, "/")
 			ind, err := strconv.Atoi(sp[len(sp)-1])
 			if err != nil {
-				return
+				return layoutError(url, err)
 			}
 			newdateindex = ind
 			break ploop
@@ -865,25 +921,19 @@ func NewxsdlCrawler(timeout, interval int) *inBaseCrawler {
 		index := newdateindex - 3
 		indexURL := startURL + "/dayProxy/ip/"
 
-	indexloop:
 		for index <= newdateindex {
 			url := indexURL + strconv.Itoa(index) + ".html"
-			html, err := soup.Get(url)
+			html, err := fetch(ctx, url)
 			if err != nil {
-				select {
-				case <-xsdl.abortCh:
-					return
-				case <-time.After(2 * time.Second):
-					continue indexloop
-				}
+				return err
 			}
 			doc := soup.HTMLParse(html)
 			if doc.Error != nil {
-				return
+				return layoutError(url, doc.Error)
 			}
 			body := doc.FindStrict("div", "class", "cont")
 			if body.Error != nil {
-				return
+				return layoutError(url, body.Error)
 			}
 
 			for _, child := range body.Children() {
@@ -900,89 +950,77 @@ func NewxsdlCrawler(timeout, interval int) *inBaseCrawler {
 					addr := sp[0]
 					typ := strings.ToLower(sp[1])
 					select {
-					case <-xsdl.abortCh:
-						return
-					case xsdl.proxyCh <- fmt.Sprintf("%s://%s", typ, addr):
+					case <-ctx.Done():
+						return nil
+					case proxyCh <- fmt.Sprintf("%s://%s", typ, addr):
 					}
 				}
 			}
 
 			select {
-			case <-xsdl.abortCh:
-				return
+			case <-ctx.Done():
+				return nil
 			case <-time.After(xsdl.interval + time.Second*time.Duration(rand.Intn(5))):
 				index++
 			}
 		}
 
+		return nil
 	}
 
 	return xsdl
 }
 
 // 方法SEO代理
-func NewffseoCrawler() CrawlerFunc {
-	f := func() <-chan string {
+func NewffseoContextCrawler() ContextCrawler {
+	ffseo := newPageCrawler(0, 0, 0)
+
+	ffseo.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "https://proxy.seofangfa.com/"
 		)
-		proxyCh := make(chan string, 5)
-
-		var wg sync.WaitGroup
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
 
-			html, err := soup.Get(startURL)
-			if err != nil {
-				return
-			}
-			doc := soup.HTMLParse(html)
-			if doc.Error != nil {
-				return
-			}
+		html, err := fetch(ctx, startURL)
+		if err != nil {
+			return err
+		}
+		doc := soup.HTMLParse(html)
+		if doc.Error != nil {
+			return layoutError(startURL, doc.Error)
+		}
 
-			table := doc.Find("table", "class", "table")
-			if table.Error != nil {
-				return
-			}
-			tbody := table.Find("tbody")
-			if tbody.Error != nil {
-				return
+		table := doc.Find("table", "class", "table")
+		if table.Error != nil {
+			return layoutError(startURL, table.Error)
+		}
+		tbody := table.Find("tbody")
+		if tbody.Error != nil {
+			return layoutError(startURL, tbody.Error)
+		}
+		for _, tr := range tbody.FindAll("tr") {
+			tds := tr.FindAll("td")
+			if len(tds) == 0 {
+				continue
 			}
-			for _, tr := range tbody.FindAll("tr") {
-				tds := tr.FindAll("td")
-				if len(tds) == 0 {
-					continue
-				}
-				if len(tds) >= 5 {
-					ip := tds[0].Text()
-					port := tds[1].Text()
-					proxyCh <- fmt.Sprintf("%s:%s", ip, port)
+			if len(tds) >= 5 {
+				ip := tds[0].Text()
+				port := tds[1].Text()
+				if !send(ctx, proxyCh, fmt.Sprintf("%s:%s", ip, port)) {
+					return nil
 				}
 			}
-
-		}()
-
-		go func() {
-			wg.Wait()
-			close(proxyCh)
-		}()
-
-		return proxyCh
+		}
+		return nil
 	}
-	return CrawlerFunc(f)
+
+	return ffseo
 }
 
 //米扑代理
-func NewmimvpCrawler(timeout, interval int) *inBaseCrawler {
-	mimvp := &inBaseCrawler{
-		timeout:  time.Duration(timeout) * time.Second,
-		interval: time.Duration(interval) * time.Second,
-	}
+func NewmimvpContextCrawler(timeout, interval int) ContextCrawler {
+	mimvp := newPageCrawler(timeout, interval, 0)
 
-	mimvp.parse = func() {
+	mimvp.parse = func(ctx context.Context, proxyCh chan<- string) error {
 		const (
 			startURL = "https://proxy.mimvp.com"
 			freeopen = "/freeopen?proxy="
@@ -1000,40 +1038,31 @@ func NewmimvpCrawler(timeout, interval int) *inBaseCrawler {
 			ReqID string `json:"req_id"`
 		}
 
-		// 使用第三方API完成OCR
-		parsePortImg := func(imgurl string) (string, bool) {
+		// 使用第三方API完成OCR，OCR服务不可用时返回ErrDependency
+		parsePortImg := func(imgurl string) (string, error) {
 			var token string
-			var image []byte
 
 			// 获取token
 			{
-				s, err := soup.Get(ocrStartURL)
+				s, err := fetch(ctx, ocrStartURL)
 				if err != nil {
-					return "", false
+					return "", dependencyError(ocrStartURL, err)
 				}
 				doc := soup.HTMLParse(s)
 				if doc.Error != nil {
-					return "", false
+					return "", dependencyError(ocrStartURL, doc.Error)
 				}
 				tb := doc.Find("div", "id", "toolBox")
 				if tb.Error != nil {
-					return "", false
+					return "", dependencyError(ocrStartURL, tb.Error)
 				}
 				token = tb.Attrs()["data-token"]
 			}
 
 			// 获取图片
-			{
-				resp, err := http.Get(imgurl)
-				if err != nil {
-					return "", false
-				}
-				body, err := ioutil.ReadAll(resp.Body)
-				resp.Body.Close()
-				if err != nil {
-					return "", false
-				}
-				image = body
+			image, err := fetch(ctx, imgurl)
+			if err != nil {
+				return "", err
 			}
 
 			buf := &bytes.Buffer{}
@@ -1045,68 +1074,62 @@ func NewmimvpCrawler(timeout, interval int) *inBaseCrawler {
 			h.Set("Content-Type", "image/png")
 			w, err := bodywritter.CreatePart(h)
 			if err != nil {
-				return "", false
+				return "", err
 			}
-			w.Write(image)
+			w.Write([]byte(image))
 
 			contenttype := bodywritter.FormDataContentType()
 			bodywritter.Close()
 
-			req, err := http.NewRequest("POST", ocrAPIURL, buf)
+			req, err := http.NewRequestWithContext(ctx, "POST", ocrAPIURL, buf)
 			if err != nil {
-				return "", false
+				return "", err
 			}
 			req.Header.Set("User-Agent", gospider.UserAgent)
 			req.Header.Set("Content-Type", contenttype)
 
-			resp, err := http.DefaultClient.Do(req)
+			resp, err := fetchClient.Do(req)
 			if err != nil {
-				return "", false
+				return "", dependencyError(ocrAPIURL, err)
 			}
-			body, err := ioutil.ReadAll(resp.Body)
+			body, err := io.ReadAll(resp.Body)
 			resp.Body.Close()
 			if err != nil {
-				return "", false
+				return "", dependencyError(ocrAPIURL, err)
 			}
 
 			res := &imgDetectType{}
 			json.Unmarshal(body, res)
 
-			if res.Status != 1 {
-				return "", false
+			if res.Status != 1 || len(res.Data.Rows) == 0 {
+				return "", dependencyError(ocrAPIURL, fmt.Errorf("ocr status %d", res.Status))
 			}
 
-			return res.Data.Rows[0], true
+			return res.Data.Rows[0], nil
 		}
 
 		ptypes := [...]string{"in_hp", "in_tp", "in_socks", "out_hp", "out_tp", "out_socks"}
 		i := 0
 
-	ploop:
 		for i < len(ptypes) {
 			url := startURL + freeopen + ptypes[i]
 
-			s, err := soup.Get(url)
+			s, err := fetch(ctx, url)
 			if err != nil {
-				select {
-				case <-mimvp.abortCh:
-					return
-				case <-time.After(2 * time.Second):
-					continue ploop
-				}
+				return err
 			}
 
 			doc := soup.HTMLParse(s)
 			if doc.Error != nil {
-				return
+				return layoutError(url, doc.Error)
 			}
 			table := doc.FindStrict("table", "class", "mimvp-tbl free-proxylist-tbl")
 			if table.Error != nil {
-				return
+				return layoutError(url, table.Error)
 			}
 			tbody := table.FindStrict("tbody")
 			if tbody.Error != nil {
-				return
+				return layoutError(url, tbody.Error)
 			}
 
 			for _, tr := range tbody.FindAll("tr") {
@@ -1134,26 +1157,24 @@ func NewmimvpCrawler(timeout, interval int) *inBaseCrawler {
 				if portImgNode.Error != nil {
 					continue
 				}
-				select {
-				case <-time.After(10 * time.Second):
-					port, ok := parsePortImg(startURL + portImgNode.Attrs()["src"])
-					if !ok {
-						return
-					}
-					mimvp.proxyCh <- fmt.Sprintf("%s://%s:%s", typ, ip, port)
-				case <-mimvp.abortCh:
-					return
+				if !sleep(ctx, 10*time.Second) {
+					return nil
+				}
+				port, err := parsePortImg(startURL + portImgNode.Attrs()["src"])
+				if err != nil {
+					return err
+				}
+				if !send(ctx, proxyCh, fmt.Sprintf("%s://%s:%s", typ, ip, port)) {
+					return nil
 				}
 			}
 
-			select {
-			case <-mimvp.abortCh:
-				return
-			case <-time.After(mimvp.interval):
-				i++
+			if !sleep(ctx, mimvp.interval) {
+				return nil
 			}
+			i++
 		}
-
+		return nil
 	}
 
 	return mimvp
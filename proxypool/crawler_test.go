@@ -1,10 +1,12 @@
 package proxypool_test
 
 import (
+	"context"
 	"fmt"
 	"gospider/proxypool"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestCrawler(t *testing.T) {
@@ -33,3 +35,108 @@ func TestCrawler(t *testing.T) {
 		}
 	}
 }
+
+// 不断产生代理直到ctx取消的爬虫
+func endlessCrawler(ctx context.Context) (<-chan string, error) {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for i := 1; ; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- fmt.Sprintf("10.0.0.%d:80", i%250):
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// 旧接口的爬虫，发送代理时不检查是否停止，Stop等待爬取结束。silent时停止前不发送代理
+type legacyCrawler struct {
+	silent bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func (c *legacyCrawler) Crawl() <-chan string {
+	ch := make(chan string)
+	c.stop, c.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(c.done)
+		defer close(ch)
+		if c.silent {
+			<-c.stop
+			return
+		}
+		for {
+			ch <- "10.0.0.1:80"
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+		}
+	}()
+	return ch
+}
+
+func (c *legacyCrawler) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+func TestContextCrawler(t *testing.T) {
+	// 超时后关闭通道
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ch, err := proxypool.ContextCrawlerFunc(endlessCrawler).Crawl(ctx)
+	if err != nil {
+		t.Fatalf("Crawl failed: %v\n", err)
+	}
+	for range ch {
+	}
+
+	// 旧接口：爬取中再次Crawl返回同一个通道，Stop后通道关闭
+	sc := proxypool.Stoppable(proxypool.ContextCrawlerFunc(endlessCrawler))
+	ch1 := sc.Crawl()
+	<-ch1
+	if ch2 := sc.Crawl(); ch2 != ch1 {
+		t.Fatalf("Stoppable failed: expect the same channel while crawling\n")
+	}
+	sc.Stop()
+	for range ch1 {
+	}
+	// 停止后可以重新爬取
+	if _, ok := <-sc.Crawl(); !ok {
+		t.Fatalf("Stoppable failed: crawl after stop\n")
+	}
+	sc.Stop()
+
+	// 旧接口转换回ContextCrawler，ctx取消时停止旧爬虫
+	ctx, cancel = context.WithCancel(context.Background())
+	legacy := struct{ proxypool.StoppableCrawler }{proxypool.Stoppable(proxypool.ContextCrawlerFunc(endlessCrawler))}
+	ch, _ = proxypool.FromCrawler(legacy).Crawl(ctx)
+	<-ch
+	cancel()
+	for range ch {
+	}
+
+	// 没有产生代理的旧爬虫和Stop时仍在发送代理的旧爬虫，ctx取消后通道都会关闭
+	for _, silent := range []bool{true, false} {
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		ch, _ = proxypool.FromCrawler(&legacyCrawler{silent: silent}).Crawl(ctx)
+		closed := make(chan struct{})
+		go func() {
+			for range ch {
+			}
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("FromCrawler failed: channel not closed after cancel, silent %v\n", silent)
+		}
+		cancel()
+	}
+}
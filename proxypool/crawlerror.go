@@ -0,0 +1,161 @@
+package proxypool
+
+// 爬虫的错误。爬虫通过错误通道报告获取网页失败、网页结构改变、被限制访问以及依赖的服务不可用，
+// 获取网页失败时有限次数地退避重试，不再无限重试。
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gospider"
+	"io"
+	"net/http"
+	"time"
+)
+
+type CrawlErrorKind int
+
+const (
+	ErrFetch       CrawlErrorKind = iota + 1 // 获取网页失败
+	ErrLayout                                // 网页结构改变，无法解析
+	ErrRateLimited                           // 被网站限制访问
+	ErrDependency                            // 依赖的服务不可用，例如OCR
+)
+
+func (k CrawlErrorKind) String() string {
+	switch k {
+	case ErrFetch:
+		return "fetch"
+	case ErrLayout:
+		return "layout"
+	case ErrRateLimited:
+		return "rate-limited"
+	case ErrDependency:
+		return "dependency"
+	}
+	return "unknown"
+}
+
+// 错误的严重程度，用于从多个错误中选出决定冷却时间的类型
+func (k CrawlErrorKind) severity() int {
+	switch k {
+	case ErrFetch:
+		return 1
+	case ErrLayout:
+		return 2
+	case ErrDependency:
+		return 3
+	case ErrRateLimited:
+		return 4
+	}
+	return 0
+}
+
+type CrawlError struct {
+	Kind CrawlErrorKind
+	URL  string
+	Err  error
+}
+
+func (e *CrawlError) Error() string {
+	return fmt.Sprintf("%s error: %s: %v", e.Kind, e.URL, e.Err)
+}
+
+func (e *CrawlError) Unwrap() error {
+	return e.Err
+}
+
+// 返回错误的类型，不是CrawlError时返回0
+func CrawlErrorKindOf(err error) CrawlErrorKind {
+	var ce *CrawlError
+	if errors.As(err, &ce) {
+		return ce.Kind
+	}
+	return 0
+}
+
+func layoutError(url string, err error) error {
+	return &CrawlError{Kind: ErrLayout, URL: url, Err: err}
+}
+
+func dependencyError(url string, err error) error {
+	return &CrawlError{Kind: ErrDependency, URL: url, Err: err}
+}
+
+// 可以报告错误的爬虫。CrawlErrors与Crawl相同，另外返回错误通道。
+// 爬取结束时先关闭代理通道，再发送错误并关闭错误通道，调用方读完代理通道后读取错误通道即可。
+// ctx取消或者超时不作为错误报告
+type ErrorCrawler interface {
+	ContextCrawler
+	CrawlErrors(ctx context.Context) (<-chan string, <-chan error, error)
+}
+
+const (
+	fetchRetries = 3           // 获取网页失败后的重试次数
+	fetchBackoff = time.Second // 第一次重试前的等待时间，之后每次加倍
+)
+
+var fetchClient = &http.Client{Timeout: 30 * time.Second}
+
+// 获取网页。请求失败、服务端错误或者被限制访问时退避重试，被限制访问时等待的时间加长。
+// 其他状态码不重试，返回ErrFetch的同时返回网页内容
+func fetch(ctx context.Context, url string) (string, error) {
+	backoff := fetchBackoff
+	for i := 0; ; i++ {
+		html, retry, err := fetchOnce(ctx, url)
+		if err == nil || !retry || i >= fetchRetries {
+			return html, err
+		}
+		wait := backoff
+		if CrawlErrorKindOf(err) == ErrRateLimited {
+			wait *= 4
+		}
+		if !sleep(ctx, wait) {
+			return html, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func fetchOnce(ctx context.Context, url string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", false, &CrawlError{Kind: ErrFetch, URL: url, Err: err}
+	}
+	req.Header.Set("User-Agent", gospider.UserAgent)
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", false, ctx.Err()
+		}
+		return "", true, &CrawlError{Kind: ErrFetch, URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", true, &CrawlError{Kind: ErrFetch, URL: url, Err: err}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden:
+		return string(body), true, &CrawlError{Kind: ErrRateLimited, URL: url, Err: fmt.Errorf("status %s", resp.Status)}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return string(body), true, &CrawlError{Kind: ErrFetch, URL: url, Err: fmt.Errorf("status %s", resp.Status)}
+	case resp.StatusCode >= http.StatusBadRequest:
+		return string(body), false, &CrawlError{Kind: ErrFetch, URL: url, Err: fmt.Errorf("status %s", resp.Status)}
+	}
+	return string(body), false, nil
+}
+
+// 等待d，ctx取消时返回false
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,22 @@
+package proxypool_test
+
+import (
+	"errors"
+	"fmt"
+	"gospider/proxypool"
+	"testing"
+)
+
+func TestCrawlError(t *testing.T) {
+	err := fmt.Errorf("crawl kdl: %w", &proxypool.CrawlError{
+		Kind: proxypool.ErrLayout,
+		URL:  "https://www.kuaidaili.com/free/inha/1/",
+		Err:  errors.New("table not found"),
+	})
+	if kind := proxypool.CrawlErrorKindOf(err); kind != proxypool.ErrLayout || kind.String() != "layout" {
+		t.Fatalf("CrawlErrorKindOf failed: get %v\n", kind)
+	}
+	if kind := proxypool.CrawlErrorKindOf(errors.New("other")); kind != 0 {
+		t.Fatalf("CrawlErrorKindOf failed: get %v for other error\n", kind)
+	}
+}
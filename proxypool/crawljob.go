@@ -0,0 +1,353 @@
+package proxypool
+
+// 爬虫的调度。每个爬虫按照各自的计划运行，出错后冷却一段时间，可以通过接口手动运行，
+// 并且可以查看每个爬虫上次和下次运行的时间。
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// 按照计划运行的爬虫
+type CrawlJob struct {
+	Name     string
+	Crawler  ContextCrawler
+	Schedule Schedule      // 运行计划，为nil时每隔Scheduler.CrawlCycle秒运行
+	Cooldown time.Duration // 出错后至少等待的时间，默认为10分钟，连续出错时加倍
+	Timeout  time.Duration // 每次运行的超时，为0时不限制
+}
+
+func (job *CrawlJob) cooldown() time.Duration {
+	if job.Cooldown > 0 {
+		return job.Cooldown
+	}
+	return 10 * time.Minute
+}
+
+// 出错后的冷却时间。连续出错时加倍，最多为16倍，被限制访问时再加倍
+func (job *CrawlJob) backoff(failures int, kind CrawlErrorKind) time.Duration {
+	d := job.cooldown()
+	for i := 1; i < failures && i <= 4; i++ {
+		d *= 2
+	}
+	if kind == ErrRateLimited {
+		d *= 2
+	}
+	return d
+}
+
+// 爬虫的运行状态
+type CrawlerStatus struct {
+	Name      string    `json:"name"`
+	Running   bool      `json:"running"`
+	LastRun   time.Time `json:"last_run"`             // 上次开始运行的时间，从未运行时为零值
+	LastEnd   time.Time `json:"last_end"`             // 上次结束运行的时间
+	LastCount int       `json:"last_count"`           // 上次爬取的代理数目
+	LastError string    `json:"last_error,omitempty"` // 上次运行的错误
+	Failures  int       `json:"failures"`             // 连续出错的次数
+	NextRun   time.Time `json:"next_run"`
+
+	Errors map[string]int `json:"errors,omitempty"` // 按类型累计的爬虫报告的错误数目
+}
+
+type crawlState struct {
+	job    *CrawlJob
+	status CrawlerStatus
+}
+
+// 默认的爬虫任务，每个默认爬虫一个任务，使用Scheduler.CrawlCycle
+func DefaultCrawlJobs() []*CrawlJob {
+	jobs := make([]*CrawlJob, 0, len(defaultCrawlers))
+	for _, d := range defaultCrawlers {
+		jobs = append(jobs, &CrawlJob{Name: d.name, Crawler: d.new()})
+	}
+	return jobs
+}
+
+func (sch *Scheduler) crawlCycle() time.Duration {
+	if sch.CrawlCycle > 0 {
+		return time.Duration(sch.CrawlCycle) * time.Second
+	}
+	return 2 * time.Hour
+}
+
+// 爬虫任务，没有设置Jobs时由Crawlers生成
+func (sch *Scheduler) jobs() []*CrawlJob {
+	if len(sch.Jobs) > 0 {
+		return sch.Jobs
+	}
+	if len(sch.Crawlers) == 0 {
+		return DefaultCrawlJobs()
+	}
+	// 没有名称的爬虫使用序号命名
+	jobs := make([]*CrawlJob, 0, len(sch.Crawlers))
+	for i, c := range sch.Crawlers {
+		jobs = append(jobs, &CrawlJob{Name: fmt.Sprintf("crawler-%d", i), Crawler: FromCrawler(c)})
+	}
+	return jobs
+}
+
+// 初始化爬虫的状态，所有爬虫在启动时运行一次
+func (sch *Scheduler) initCrawlStates() {
+	sch.crawlMu.Lock()
+	defer sch.crawlMu.Unlock()
+	now := time.Now()
+	sch.crawlStates = nil
+	for _, job := range sch.jobs() {
+		sch.crawlStates = append(sch.crawlStates, &crawlState{
+			job:    job,
+			status: CrawlerStatus{Name: job.Name, NextRun: now},
+		})
+	}
+	sch.trigger = make(chan struct{}, 1)
+}
+
+// 唤醒爬虫的调度循环
+func (sch *Scheduler) wake() {
+	select {
+	case sch.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// 所有爬虫的运行状态
+func (sch *Scheduler) CrawlerStatus() []CrawlerStatus {
+	sch.crawlMu.Lock()
+	defer sch.crawlMu.Unlock()
+	res := make([]CrawlerStatus, 0, len(sch.crawlStates))
+	for _, st := range sch.crawlStates {
+		status := st.status
+		if st.status.Errors != nil {
+			status.Errors = make(map[string]int, len(st.status.Errors))
+			for k, v := range st.status.Errors {
+				status.Errors[k] = v
+			}
+		}
+		res = append(res, status)
+	}
+	return res
+}
+
+var (
+	ErrCrawlerNotFound = errors.New("crawler not found")
+	ErrCrawlerRunning  = errors.New("crawler is running")
+)
+
+// 立即运行名为name的爬虫。没有该爬虫时返回ErrCrawlerNotFound，爬虫正在运行时返回ErrCrawlerRunning
+func (sch *Scheduler) RunCrawler(name string) error {
+	sch.crawlMu.Lock()
+	defer sch.crawlMu.Unlock()
+	for _, st := range sch.crawlStates {
+		if st.job.Name != name {
+			continue
+		}
+		if st.status.Running {
+			return fmt.Errorf("%w: %s", ErrCrawlerRunning, name)
+		}
+		st.status.NextRun = time.Now()
+		sch.wake()
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrCrawlerNotFound, name)
+}
+
+// 按照每个爬虫的计划运行爬虫，爬取的代理批量加入每个存储的候选队列
+func (sch *Scheduler) crawlLoop() {
+	addpCh := make(chan string, 10)
+	var addpwg sync.WaitGroup
+
+	// 缓冲爬取的代理，批量加入每个存储的候选队列。每次爬取结束后超出容量时淘汰最差的代理
+	addpwg.Add(1)
+	go func() {
+		defer addpwg.Done()
+		buffer(sch.abort, sch.batchSize(), addpCh, func(crawled []string) {
+			ended := false
+			for _, p := range crawled {
+				if p == crawlEnd {
+					ended = true
+					break
+				}
+			}
+			proxies := sch.policy().NormalizeAll(crawled)
+			for _, storage := range sch.storages() {
+				if len(proxies) > 0 {
+					n, err := storage.AddCandidates(proxies)
+					if err != nil {
+						log.Printf("add candidates failed: %v\n", err)
+					} else {
+						log.Printf("add %d new candidates of %d crawled.\n", n, len(proxies))
+					}
+				}
+				if !ended {
+					continue
+				}
+				if n, err := storage.Evict(sch.capacity()); err != nil {
+					log.Printf("evict proxies failed: %v\n", err)
+				} else if n > 0 {
+					log.Printf("evict %d proxies.\n", n)
+				}
+			}
+		})
+	}()
+
+	// abort关闭时取消正在运行的爬虫
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-sch.abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	workCh := make(chan struct{}, runtime.NumCPU())
+	var workwg sync.WaitGroup
+
+loop:
+	for {
+		now := time.Now()
+		wait := time.Minute
+		sch.crawlMu.Lock()
+		for _, st := range sch.crawlStates {
+			// 下次运行时间为零值表示计划中没有下次运行
+			if st.status.Running || st.status.NextRun.IsZero() {
+				continue
+			}
+			if d := st.status.NextRun.Sub(now); d > 0 {
+				if d < wait {
+					wait = d
+				}
+				continue
+			}
+			st.status.Running = true
+			workwg.Add(1)
+			go func(st *crawlState) {
+				defer workwg.Done()
+				select {
+				case <-sch.abort:
+					return
+				case workCh <- struct{}{}:
+				}
+				sch.runJob(ctx, st, addpCh)
+				<-workCh
+			}(st)
+		}
+		sch.crawlMu.Unlock()
+
+		select {
+		case <-sch.abort:
+			break loop
+		case <-sch.trigger:
+		case <-time.After(wait):
+		}
+	}
+	workwg.Wait()
+	close(addpCh)
+
+	addpwg.Wait()
+}
+
+// 爬虫运行结束后发送到代理通道的标记，在这次爬取的所有代理之后
+const crawlEnd = ""
+
+// 运行一次爬虫并计算下次运行的时间。没有爬取到代理或者被限制访问时认为出错，按照连续出错的次数冷却
+func (sch *Scheduler) runJob(ctx context.Context, st *crawlState, addpCh chan<- string) {
+	job := st.job
+	start := time.Now()
+	sch.crawlMu.Lock()
+	st.status.LastRun = start
+	sch.crawlMu.Unlock()
+	log.Printf("start crawler %s.\n", job.Name)
+
+	var cancel context.CancelFunc
+	if job.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	var proxyCh <-chan string
+	var errCh <-chan error
+	var err error
+	if c, ok := job.Crawler.(ErrorCrawler); ok {
+		proxyCh, errCh, err = c.CrawlErrors(ctx)
+	} else {
+		proxyCh, err = job.Crawler.Crawl(ctx)
+	}
+
+	count := 0
+	var crawlErrs []error
+	if err == nil {
+	loop:
+		for proxy := range proxyCh {
+			select {
+			case <-ctx.Done():
+				break loop
+			case addpCh <- proxy:
+				count++
+			}
+		}
+		if errCh != nil {
+			for e := range errCh {
+				log.Printf("crawler %s: %v\n", job.Name, e)
+				crawlErrs = append(crawlErrs, e)
+			}
+		}
+	}
+	if count > 0 {
+		select {
+		case <-sch.abort:
+		case addpCh <- crawlEnd:
+		}
+	}
+
+	end := time.Now()
+	schedule := job.Schedule
+	if schedule == nil {
+		schedule = Every(sch.crawlCycle())
+	}
+	next := schedule.Next(end)
+
+	sch.crawlMu.Lock()
+	defer sch.crawlMu.Unlock()
+	st.status.Running = false
+	st.status.LastEnd = end
+	st.status.LastCount = count
+	st.status.LastError = ""
+	var kind CrawlErrorKind
+	for _, e := range crawlErrs {
+		k := CrawlErrorKindOf(e)
+		if k.severity() > kind.severity() {
+			kind = k
+		}
+		if st.status.Errors == nil {
+			st.status.Errors = make(map[string]int)
+		}
+		st.status.Errors[k.String()]++
+		st.status.LastError = e.Error()
+	}
+	if err != nil || count == 0 || kind == ErrRateLimited {
+		if err != nil {
+			st.status.LastError = err.Error()
+		} else if st.status.LastError == "" {
+			st.status.LastError = "no proxies crawled"
+		}
+		st.status.Failures++
+		if cool := end.Add(job.backoff(st.status.Failures, kind)); next.Before(cool) {
+			next = cool
+		}
+		log.Printf("crawler %s failed: %s, next run at %s.\n", job.Name, st.status.LastError, next.Format(time.RFC3339))
+	} else {
+		st.status.Failures = 0
+		log.Printf("crawler %s crawled %d proxies, next run at %s.\n", job.Name, count, next.Format(time.RFC3339))
+	}
+	st.status.NextRun = next
+	sch.wake()
+}
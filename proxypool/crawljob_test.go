@@ -0,0 +1,176 @@
+package proxypool_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gospider/proxypool"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func crawlerStatus(sch *proxypool.Scheduler, name string) proxypool.CrawlerStatus {
+	for _, s := range sch.CrawlerStatus() {
+		if s.Name == name {
+			return s
+		}
+	}
+	return proxypool.CrawlerStatus{}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for crawlers\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 爬取一个代理并报告错误的爬虫
+type errorCrawler []error
+
+func (c errorCrawler) Crawl(ctx context.Context) (<-chan string, error) {
+	proxyCh, _, err := c.CrawlErrors(ctx)
+	return proxyCh, err
+}
+
+func (c errorCrawler) CrawlErrors(ctx context.Context) (<-chan string, <-chan error, error) {
+	proxyCh := make(chan string, 1)
+	proxyCh <- "9.9.9.9:80"
+	close(proxyCh)
+	errCh := make(chan error, len(c))
+	for _, err := range c {
+		errCh <- err
+	}
+	close(errCh)
+	return proxyCh, errCh, nil
+}
+
+func TestCrawlJobs(t *testing.T) {
+	storage, err := proxypool.NewFileStorage(filepath.Join(t.TempDir(), "proxy.db"), "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	// 网络不通时不检测候选代理，避免测试访问外部网络
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	var runs int32
+	sch := &proxypool.Scheduler{
+		Storage: storage,
+		WebAddr: "127.0.0.1:0",
+		Monitor: proxypool.NewMonitor(down.URL),
+		Jobs: []*proxypool.CrawlJob{
+			{
+				Name: "ok",
+				Crawler: proxypool.ContextCrawlerFunc(func(ctx context.Context) (<-chan string, error) {
+					atomic.AddInt32(&runs, 1)
+					ch := make(chan string, 2)
+					ch <- "8.8.8.8:80"
+					ch <- "http://8.8.8.8:80"
+					close(ch)
+					return ch, nil
+				}),
+				Schedule: proxypool.Every(time.Hour),
+			},
+			{
+				Name: "empty",
+				// 旧接口的爬虫
+				Crawler: proxypool.FromCrawler(proxypool.CrawlerFunc(func() <-chan string {
+					ch := make(chan string)
+					close(ch)
+					return ch
+				})),
+				Schedule: proxypool.Every(time.Minute),
+				Cooldown: time.Hour,
+			},
+			{
+				Name: "limited",
+				// 被限制访问之后又获取网页失败，按照更严重的被限制访问冷却
+				Crawler: errorCrawler{
+					&proxypool.CrawlError{
+						Kind: proxypool.ErrRateLimited,
+						URL:  "http://example.com",
+						Err:  errors.New("status 429 Too Many Requests"),
+					},
+					&proxypool.CrawlError{
+						Kind: proxypool.ErrFetch,
+						URL:  "http://example.com/2",
+						Err:  errors.New("status 502 Bad Gateway"),
+					},
+				},
+				Schedule: proxypool.Every(time.Minute),
+				Cooldown: time.Hour,
+			},
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		sch.Serve()
+		close(done)
+	}()
+	defer func() {
+		sch.Close()
+		<-done
+	}()
+
+	waitFor(t, func() bool {
+		ok, empty, limited := crawlerStatus(sch, "ok"), crawlerStatus(sch, "empty"), crawlerStatus(sch, "limited")
+		return !ok.LastEnd.IsZero() && !empty.LastEnd.IsZero() && !limited.LastEnd.IsZero()
+	})
+	ok, empty := crawlerStatus(sch, "ok"), crawlerStatus(sch, "empty")
+	if ok.LastCount != 2 || ok.Failures != 0 || ok.NextRun.Sub(ok.LastEnd) != time.Hour {
+		t.Fatalf("CrawlJob failed: ok %+v\n", ok)
+	}
+	// 出错后冷却时间长于计划的间隔
+	if empty.Failures != 1 || empty.LastError == "" || empty.NextRun.Sub(empty.LastEnd) != time.Hour {
+		t.Fatalf("CrawlJob failed: empty %+v\n", empty)
+	}
+	// 被限制访问时即使爬取到代理也认为出错，冷却时间加倍
+	limited := crawlerStatus(sch, "limited")
+	if limited.LastCount != 1 || limited.Failures != 1 || limited.Errors["rate-limited"] != 1 || limited.Errors["fetch"] != 1 ||
+		limited.NextRun.Sub(limited.LastEnd) != 2*time.Hour {
+		t.Fatalf("CrawlJob failed: limited %+v\n", limited)
+	}
+
+	// 调度器的状态接口
+	rec := httptest.NewRecorder()
+	sch.NewWebServer().Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status proxypool.SchedulerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("/status failed: %d %v\n", rec.Code, err)
+	}
+	if status.Network.Connected || status.Network.Total != 1 || len(status.Crawlers) != 3 || status.Crawlers[0].Name != "ok" {
+		t.Fatalf("/status failed: get %+v\n", status)
+	}
+
+	// 手动运行
+	if err := sch.RunCrawler("ok"); err != nil {
+		t.Fatalf("RunCrawler failed: %v\n", err)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&runs) == 2 && !crawlerStatus(sch, "ok").Running })
+	if err := sch.RunCrawler("missing"); !errors.Is(err, proxypool.ErrCrawlerNotFound) {
+		t.Fatalf("RunCrawler failed: expect ErrCrawlerNotFound, get %v\n", err)
+	}
+	rec = httptest.NewRecorder()
+	sch.NewWebServer().Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/crawlers/run?name=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("/crawlers/run failed: expect 404, get %d\n", rec.Code)
+	}
+
+	// 同一个代理的两种写法只加入一次候选队列
+	waitFor(t, func() bool {
+		n, _ := storage.CountCandidates()
+		return n == 2
+	})
+}
@@ -0,0 +1,46 @@
+package proxypool_test
+
+import (
+	"gospider/proxypool"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 30, 15, 0, time.UTC) // 星期三
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"@every 90m", from.Add(90 * time.Minute)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 2, 1, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2024, 1, 31, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"30 10 29 2 *", time.Date(2024, 2, 29, 10, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * 6", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 5-7", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		// 以*开头的步长与*相同，日和星期取交集：单数日并且是星期一
+		{"0 0 */2 * 1", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)},
+		// 不以*开头的字段即使包括所有的日也取并集
+		{"0 0 1-31 * 1", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		s, err := proxypool.ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) failed: %v\n", test.spec, err)
+			continue
+		}
+		if next := s.Next(from); !next.Equal(test.next) {
+			t.Errorf("ParseSchedule(%q): expect next %v, get %v\n", test.spec, test.next, next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * * 8", "@every x"} {
+		if _, err := proxypool.ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q): expect error\n", spec)
+		}
+	}
+}
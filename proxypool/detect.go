@@ -4,36 +4,16 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
-	"net/url"
-	"strings"
 	"time"
 )
 
 // 用于测试代理是否可用
 func DetectSingleProxy(proxy string) (bool, error) {
-	pres := [...]string{"https://", "http://", "socks5://"}
-	var pflag bool
-
-	for i := 0; i < len(pres); i++ {
-		if strings.HasPrefix(proxy, pres[i]) {
-			pflag = true
-			break
-		}
-	}
-
-	if !pflag {
-		proxy = "http://" + proxy
-	}
-
-	var htps bool
-	if strings.HasPrefix(proxy, "https") {
-		htps = true
-	}
-
-	uri, err := url.Parse(proxy)
+	uri, err := parsePolicy.Parse(proxy)
 	if err != nil {
-		return false, fmt.Errorf("parse proxy failed: %v", err)
+		return false, err
 	}
+	htps := uri.Scheme == "https"
 
 	bCh := make(chan bool)
 	errCh := make(chan error)
@@ -75,6 +55,7 @@ func DetectSingleProxy(proxy string) (bool, error) {
 	}
 }
 
+// 检查是否可以连接外部网络。调度器使用Monitor缓存的状态，不再调用该函数
 func IsConnected() bool {
 	c := &http.Client{Timeout: 30 * time.Second}
 	resp, err := c.Get("http://www.baidu.com")
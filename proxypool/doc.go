@@ -1,8 +1,17 @@
 // 代理池:
 // 爬虫模块 - crawler.go
+// 爬虫的错误 - crawlerror.go
+// 爬虫的调度和运行计划 - crawljob.go, cron.go
 // 存储模块 - storage.go
+// 存储的原子操作 - script.go
+// 代理地址的规范化 - normalize.go
+// 候选队列 - candidate.go
+// 容量管理和淘汰 - evict.go
 // 检测模块 - detect.go
+// 检测的安排 - schedule.go
+// 网络连接的监视 - monitor.go
 // web服务 - webserver.go
+// 使用代理的http.RoundTripper - transport.go
 // 调度模块 - scheduler.go
 
 package proxypool
@@ -0,0 +1,192 @@
+package proxypool
+
+// 存储容量的管理。存储满时不再跳过爬取，而是先添加新爬取的代理，再按照淘汰策略删除最差的代理。
+// 候选队列中的代理以及代理池中从未通过检测的代理为候选代理，通过过检测的代理为已验证代理，
+// 两者的容量分别限制。淘汰时分页读取代理的信息，每次读取有限的成员，Redis不会因为一次调用处理整个
+// 代理池而阻塞；选出的代理逐个删除，读取之后验证时间改变的代理不被淘汰。
+
+import (
+	"gospider/internal/kv"
+	"sort"
+	"time"
+)
+
+// 超出容量时选择淘汰代理的策略
+type EvictPolicy int
+
+const (
+	EvictLowestScore   EvictPolicy = iota // 淘汰分数最低的代理，分数相同时淘汰最早添加的
+	EvictOldest                           // 淘汰最早添加的代理
+	EvictLeastVerified                    // 淘汰最久没有通过检测的代理，候选代理按照添加时间
+)
+
+// 存储的容量限制，为0的限制不生效
+type Capacity struct {
+	MaxTotal      int           // 代理的总数
+	MaxCandidates int           // 候选代理的数目
+	MaxVerified   int           // 已验证代理的数目
+	StaleAfter    time.Duration // 超过该时间没有通过检测的代理被淘汰，候选代理从添加时开始计算
+	Policy        EvictPolicy
+}
+
+type proxyInfo struct {
+	proxy    string
+	score    float64
+	added    float64 // 添加时间，没有记录时为0
+	verified float64 // 最近一次通过检测的时间，候选代理为0
+}
+
+// 淘汰时排在前面的代理先被淘汰，顺序相同时按照代理排序，使淘汰的结果是确定的
+func (c *Capacity) sort(ps []proxyInfo) {
+	sort.Slice(ps, func(i, j int) bool {
+		a, b := ps[i], ps[j]
+		var x, y float64
+		switch c.Policy {
+		case EvictOldest:
+			x, y = a.added, b.added
+		case EvictLeastVerified:
+			x, y = a.verified, b.verified
+			if x == 0 {
+				x = a.added
+			}
+			if y == 0 {
+				y = b.added
+			}
+		default:
+			if a.score != b.score {
+				return a.score < b.score
+			}
+			x, y = a.added, b.added
+		}
+		if x != y {
+			return x < y
+		}
+		return a.proxy < b.proxy
+	})
+}
+
+// 需要淘汰的代理。先淘汰在deadline之前没有通过检测的代理，deadline为0时不按照时间淘汰，
+// 然后分别淘汰超出数目的候选代理和已验证代理，总数仍然超出时优先淘汰候选代理
+func (c *Capacity) victims(ps []proxyInfo, deadline float64) []string {
+	var victims []string
+	var candidates, verified []proxyInfo
+	for _, p := range ps {
+		last := p.verified
+		if last == 0 {
+			last = p.added
+		}
+		// 没有添加时间的代理是记录时间之前添加的，不按照时间淘汰
+		if deadline > 0 && last > 0 && last < deadline {
+			victims = append(victims, p.proxy)
+			continue
+		}
+		if p.verified == 0 {
+			candidates = append(candidates, p)
+		} else {
+			verified = append(verified, p)
+		}
+	}
+
+	c.sort(candidates)
+	c.sort(verified)
+	if c.MaxCandidates > 0 && len(candidates) > c.MaxCandidates {
+		n := len(candidates) - c.MaxCandidates
+		for _, p := range candidates[:n] {
+			victims = append(victims, p.proxy)
+		}
+		candidates = candidates[n:]
+	}
+	if c.MaxVerified > 0 && len(verified) > c.MaxVerified {
+		n := len(verified) - c.MaxVerified
+		for _, p := range verified[:n] {
+			victims = append(victims, p.proxy)
+		}
+		verified = verified[n:]
+	}
+	if c.MaxTotal > 0 && len(candidates)+len(verified) > c.MaxTotal {
+		n := len(candidates) + len(verified) - c.MaxTotal
+		for _, p := range append(candidates, verified...)[:n] {
+			victims = append(victims, p.proxy)
+		}
+	}
+	return victims
+}
+
+// 分页读取时每次读取的成员数目
+const evictPage = 1000
+
+// 分页读取有序集合的所有成员
+func (s *Storage) scanZ(key string) ([]kv.Z, error) {
+	var res []kv.Z
+	for start := int64(0); ; start += evictPage {
+		zs, err := s.store.ZRevRange(key, start, start+evictPage-1)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, zs...)
+		if len(zs) < evictPage {
+			return res, nil
+		}
+	}
+}
+
+// 按照容量限制淘汰代理，返回淘汰的代理数目
+func (s *Storage) Evict(c Capacity) (int, error) {
+	var deadline float64
+	if c.StaleAfter > 0 {
+		deadline = unixTime(time.Now().Add(-c.StaleAfter))
+	}
+	keys := s.keys()
+	var sets [4][]kv.Z
+	for i, key := range []string{keys[0], keys[1], keys[2], keys[3]} {
+		zs, err := s.scanZ(key)
+		if err != nil {
+			return 0, err
+		}
+		sets[i] = zs
+	}
+	added, verified := make(map[string]float64, len(sets[1])), make(map[string]float64, len(sets[2]))
+	for _, z := range sets[1] {
+		added[z.Member] = z.Score
+	}
+	for _, z := range sets[2] {
+		verified[z.Member] = z.Score
+	}
+
+	// 候选队列中的代理分数为0，同时在代理池中的代理只计算一次
+	seen := make(map[string]bool, len(sets[0])+len(sets[3]))
+	ps := make([]proxyInfo, 0, len(sets[0])+len(sets[3]))
+	for i, set := range [][]kv.Z{sets[0], sets[3]} {
+		for _, z := range set {
+			if seen[z.Member] {
+				continue
+			}
+			seen[z.Member] = true
+			p := proxyInfo{proxy: z.Member, added: added[z.Member], verified: verified[z.Member]}
+			if i == 0 {
+				p.score = z.Score
+			}
+			ps = append(ps, p)
+		}
+	}
+
+	victims := c.victims(ps, deadline)
+	if len(victims) == 0 {
+		return 0, nil
+	}
+	argsList := make([][]any, 0, len(victims))
+	for _, p := range victims {
+		argsList = append(argsList, []any{p, verified[p]})
+	}
+	res, err := s.store.Batch(evictScript, keys, argsList)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, r := range res {
+		if v, err := kv.Int(r.Val, r.Err); err == nil && v == 1 {
+			n++
+		}
+	}
+	return n, nil
+}
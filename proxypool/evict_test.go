@@ -0,0 +1,81 @@
+package proxypool_test
+
+import (
+	"fmt"
+	"gospider/proxypool"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func allProxies(t *testing.T, storage *proxypool.Storage) []string {
+	proxies, err := storage.GetAll()
+	if err != nil {
+		t.Fatalf("GetAll failed: %v\n", err)
+	}
+	sort.Strings(proxies)
+	return proxies
+}
+
+func TestEvict(t *testing.T) {
+	storage, err := proxypool.NewFileStorage(filepath.Join(t.TempDir(), "proxy.db"), "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	for _, p := range []string{"a", "b", "c", "d"} {
+		storage.Add(p)
+		time.Sleep(time.Millisecond)
+	}
+	storage.Decrease("b")
+	storage.SetMax("c")
+	storage.SetMax("d")
+
+	// 候选代理a和b中淘汰分数最低的b，已验证代理c和d中淘汰最早添加的c
+	n, err := storage.Evict(proxypool.Capacity{MaxCandidates: 1, MaxVerified: 1})
+	if err != nil || n != 2 {
+		t.Fatalf("Evict failed: expect 2, get %d %v\n", n, err)
+	}
+	if ps := allProxies(t, storage); len(ps) != 2 || ps[0] != "a" || ps[1] != "d" {
+		t.Fatalf("Evict failed: expect [a d], get %v\n", ps)
+	}
+
+	// 总数超出时优先淘汰候选代理
+	storage.Add("e")
+	n, err = storage.Evict(proxypool.Capacity{MaxTotal: 2, Policy: proxypool.EvictOldest})
+	if err != nil || n != 1 {
+		t.Fatalf("Evict failed: expect 1, get %d %v\n", n, err)
+	}
+	if ps := allProxies(t, storage); len(ps) != 2 || ps[0] != "d" || ps[1] != "e" {
+		t.Fatalf("Evict failed: expect [d e], get %v\n", ps)
+	}
+
+	// 超过StaleAfter没有通过检测的代理被淘汰
+	time.Sleep(50 * time.Millisecond)
+	storage.SetMax("d")
+	n, err = storage.Evict(proxypool.Capacity{StaleAfter: 20 * time.Millisecond})
+	if err != nil || n != 1 {
+		t.Fatalf("Evict failed: expect 1, get %d %v\n", n, err)
+	}
+	if ps := allProxies(t, storage); len(ps) != 1 || ps[0] != "d" {
+		t.Fatalf("Evict failed: expect [d], get %v\n", ps)
+	}
+
+	// 代理多于一页时分页读取
+	proxies := make([]string, 0, 2500)
+	for i := 0; i < 2500; i++ {
+		proxies = append(proxies, fmt.Sprintf("10.%d.%d.1:80", i/250, i%250))
+	}
+	if _, err := storage.AddCandidates(proxies); err != nil {
+		t.Fatalf("AddCandidates failed: %v\n", err)
+	}
+	n, err = storage.Evict(proxypool.Capacity{MaxCandidates: 100})
+	if err != nil || n != 2400 {
+		t.Fatalf("Evict failed: expect 2400, get %d %v\n", n, err)
+	}
+	if c, _ := storage.CountCandidates(); c != 100 {
+		t.Fatalf("Evict failed: expect 100 candidates left, get %d\n", c)
+	}
+}
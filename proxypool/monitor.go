@@ -0,0 +1,178 @@
+package proxypool
+
+// 网络连接的监视器。后台定期探测多个目标，达到法定数目的目标可以访问时认为网络连通，
+// 检测和验证代理时使用缓存的状态，网络断开时暂停，恢复后继续，不再为每个代理单独探测。
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var DefaultProbeTargets = []string{
+	"http://www.baidu.com",
+	"http://www.qq.com",
+	"http://www.163.com",
+}
+
+// 网络连接的状态
+type MonitorStatus struct {
+	Connected bool      `json:"connected"`
+	Succeeded int       `json:"succeeded"`  // 上次探测成功的目标数目
+	Total     int       `json:"total"`      // 上次探测的目标数目
+	CheckedAt time.Time `json:"checked_at"` // 上次探测的时间，从未探测时为零值
+	Since     time.Time `json:"since"`      // 当前状态开始的时间
+}
+
+type Monitor struct {
+	Targets  []string      // 探测的地址，为空时使用DefaultProbeTargets
+	Quorum   int           // 网络连通所需的成功探测数目，默认为过半
+	Interval time.Duration // 探测的间隔，默认为30秒
+	Timeout  time.Duration // 每次探测的超时，默认为5秒
+
+	mu     sync.RWMutex
+	status MonitorStatus
+	ready  chan struct{} // 网络连通时关闭
+}
+
+func NewMonitor(targets ...string) *Monitor {
+	return &Monitor{Targets: targets}
+}
+
+func (m *Monitor) targets() []string {
+	if len(m.Targets) > 0 {
+		return m.Targets
+	}
+	return DefaultProbeTargets
+}
+
+func (m *Monitor) quorum() int {
+	if m.Quorum > 0 {
+		return m.Quorum
+	}
+	return len(m.targets())/2 + 1
+}
+
+func (m *Monitor) interval() time.Duration {
+	if m.Interval > 0 {
+		return m.Interval
+	}
+	return 30 * time.Second
+}
+
+func (m *Monitor) timeout() time.Duration {
+	if m.Timeout > 0 {
+		return m.Timeout
+	}
+	return 5 * time.Second
+}
+
+func (m *Monitor) readyCh() chan struct{} {
+	if m.ready == nil {
+		m.ready = make(chan struct{})
+	}
+	return m.ready
+}
+
+// 立即探测所有目标并更新状态。目标返回任何非5xx的响应即认为可以访问
+func (m *Monitor) Probe() MonitorStatus {
+	return m.update(m.probe())
+}
+
+// 探测所有目标，返回可以访问的目标数目和目标总数，不更新状态
+func (m *Monitor) probe() (int, int) {
+	targets := m.targets()
+	c := &http.Client{Timeout: m.timeout()}
+
+	var wg sync.WaitGroup
+	okCh := make(chan bool, len(targets))
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			resp, err := c.Get(target)
+			if err != nil {
+				okCh <- false
+				return
+			}
+			resp.Body.Close()
+			okCh <- resp.StatusCode < http.StatusInternalServerError
+		}(target)
+	}
+	wg.Wait()
+	close(okCh)
+
+	succeeded := 0
+	for ok := range okCh {
+		if ok {
+			succeeded++
+		}
+	}
+	return succeeded, len(targets)
+}
+
+func (m *Monitor) update(succeeded, total int) MonitorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	connected := succeeded >= m.quorum()
+	if connected != m.status.Connected || m.status.Since.IsZero() {
+		m.status.Since = now
+		if connected {
+			log.Printf("network connected: %d of %d probes succeeded.\n", succeeded, total)
+			close(m.readyCh())
+		} else {
+			log.Printf("network disconnected: %d of %d probes succeeded, pause detection.\n", succeeded, total)
+			if m.status.Connected {
+				m.ready = make(chan struct{})
+			}
+		}
+	}
+	m.status.Connected = connected
+	m.status.Succeeded = succeeded
+	m.status.Total = total
+	m.status.CheckedAt = now
+	return m.status
+}
+
+// 定期探测，直到abort关闭
+func (m *Monitor) Run(abort <-chan struct{}) {
+	for {
+		m.Probe()
+		select {
+		case <-abort:
+			return
+		case <-time.After(m.interval()):
+		}
+	}
+}
+
+// 返回缓存的状态，m为nil时返回零值
+func (m *Monitor) Status() MonitorStatus {
+	if m == nil {
+		return MonitorStatus{}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// 返回缓存的状态是否连通
+func (m *Monitor) Connected() bool {
+	return m.Status().Connected
+}
+
+// 等待网络连通，abort关闭时返回false
+func (m *Monitor) Wait(abort <-chan struct{}) bool {
+	m.mu.Lock()
+	ready := m.readyCh()
+	m.mu.Unlock()
+	select {
+	case <-ready:
+		return true
+	case <-abort:
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package proxypool_test
+
+import (
+	"gospider/proxypool"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+
+	m := proxypool.NewMonitor(ok.URL, ok.URL+"/2", bad.URL)
+	if m.Connected() {
+		t.Fatalf("Monitor failed: connected before probing\n")
+	}
+
+	// 三个目标中两个成功，达到默认的法定数目
+	if s := m.Probe(); !s.Connected || s.Succeeded != 2 || s.Total != 3 {
+		t.Fatalf("Probe failed: expect 2 of 3 connected, get %+v\n", s)
+	}
+	if !m.Wait(nil) {
+		t.Fatalf("Wait failed: expect connected\n")
+	}
+
+	m.Quorum = 3
+	if s := m.Probe(); s.Connected {
+		t.Fatalf("Probe failed: expect disconnected with quorum 3, get %+v\n", s)
+	}
+	abort := make(chan struct{})
+	time.AfterFunc(20*time.Millisecond, func() { close(abort) })
+	if m.Wait(abort) {
+		t.Fatalf("Wait failed: expect abort while disconnected\n")
+	}
+
+	// 网络恢复时等待者继续
+	done := make(chan bool)
+	go func() { done <- m.Wait(nil) }()
+	m.Quorum = 2
+	m.Probe()
+	select {
+	case connected := <-done:
+		if !connected {
+			t.Fatalf("Wait failed: expect connected\n")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Wait failed: not resumed after reconnection\n")
+	}
+}
@@ -0,0 +1,176 @@
+package proxypool
+
+// 代理地址的规范化。不同爬虫输出的同一个代理形式不同，例如"http://1.2.3.4:80"、
+// "1.2.3.4:80"以及带空白的地址，规范化后统一为"协议://主机:端口"，存储中每个代理只保存一次。
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 规范化代理地址的策略
+type NormalizePolicy struct {
+	AllowPrivate    bool     // 允许私有、回环、链路本地等保留地址
+	RejectHostnames bool     // 只允许IP地址
+	Schemes         []string // 允许的协议，为空时允许http、https、socks4和socks5
+}
+
+var DefaultNormalizePolicy = NormalizePolicy{}
+
+// 只解析代理地址，不限制地址范围，用于检测和使用已经存储的代理
+var parsePolicy = NormalizePolicy{AllowPrivate: true}
+
+var defaultSchemes = []string{"http", "https", "socks4", "socks5"}
+
+// 除了netip能够识别的私有、回环、链路本地和组播地址以外的保留地址
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+func isReserved(addr netip.Addr) bool {
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() ||
+		addr.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, p := range reservedPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查主机名，标签由字母、数字和连字符组成，不以连字符开头或结尾
+func validHostname(host string) bool {
+	if len(host) == 0 || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *NormalizePolicy) schemes() []string {
+	if len(p.Schemes) > 0 {
+		return p.Schemes
+	}
+	return defaultSchemes
+}
+
+// 将代理地址规范化为"协议://[用户信息@]主机:端口"，没有协议时使用http，
+// 协议和主机名使用小写，IPv6地址加上方括号
+func (p *NormalizePolicy) Normalize(proxy string) (string, error) {
+	uri, err := p.Parse(proxy)
+	if err != nil {
+		return "", err
+	}
+	return uri.String(), nil
+}
+
+// 解析并检查代理地址，返回规范化的URL
+func (p *NormalizePolicy) Parse(proxy string) (*url.URL, error) {
+	s := strings.TrimSpace(proxy)
+	if s == "" {
+		return nil, fmt.Errorf("empty proxy")
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	uri, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy %q failed: %v", proxy, err)
+	}
+	if (uri.Path != "" && uri.Path != "/") || uri.RawQuery != "" || uri.Fragment != "" {
+		return nil, fmt.Errorf("proxy %q has path or query", proxy)
+	}
+
+	scheme := strings.ToLower(uri.Scheme)
+	allowed := false
+	for _, sc := range p.schemes() {
+		if scheme == sc {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("proxy %q has unsupported scheme %s", proxy, uri.Scheme)
+	}
+
+	host, port := uri.Hostname(), uri.Port()
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return nil, fmt.Errorf("proxy %q has invalid port", proxy)
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		if addr.Zone() != "" {
+			return nil, fmt.Errorf("proxy %q has ipv6 zone", proxy)
+		}
+		if !p.AllowPrivate && isReserved(addr) {
+			return nil, fmt.Errorf("proxy %q is in a reserved range", proxy)
+		}
+		host = addr.String()
+	} else {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		if p.RejectHostnames {
+			return nil, fmt.Errorf("proxy %q is not an ip address", proxy)
+		}
+		if !validHostname(host) {
+			return nil, fmt.Errorf("proxy %q has invalid host", proxy)
+		}
+		if !p.AllowPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+			return nil, fmt.Errorf("proxy %q is in a reserved range", proxy)
+		}
+	}
+
+	return &url.URL{
+		Scheme: scheme,
+		User:   uri.User,
+		Host:   net.JoinHostPort(host, strconv.Itoa(n)),
+	}, nil
+}
+
+// 使用默认策略规范化代理地址
+func NormalizeProxy(proxy string) (string, error) {
+	return DefaultNormalizePolicy.Normalize(proxy)
+}
+
+// 规范化并去重，丢弃无效的代理
+func (p *NormalizePolicy) NormalizeAll(proxies []string) []string {
+	seen := make(map[string]bool, len(proxies))
+	res := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		n, err := p.Normalize(proxy)
+		if err != nil || seen[n] {
+			continue
+		}
+		seen[n] = true
+		res = append(res, n)
+	}
+	return res
+}
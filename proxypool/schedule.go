@@ -0,0 +1,53 @@
+package proxypool
+
+// 检测的安排。每个代理记录下次检测的时间，调度器只检测到期的代理，不再每次检测全部代理。
+// 通过检测的代理连续通过的次数越多检测间隔越长，没有通过检测或者被报告不可用的代理尽快重新检测。
+
+import (
+	"gospider/internal/kv"
+	"time"
+)
+
+const (
+	DefaultDetectInterval    = time.Minute      // 默认的最短检测间隔
+	DefaultMaxDetectInterval = 16 * time.Minute // 默认的最长检测间隔
+)
+
+// 取出最多n个到期需要检测的代理，lease内没有提交结果的代理会被再次取出
+func (s *Storage) ClaimDue(n int, lease time.Duration) ([]string, error) {
+	now := time.Now()
+	return kv.Strings(s.store.Run(claimScript, s.keys()[4:5], unixMilli(now), n, unixMilli(now.Add(lease))))
+}
+
+// 为没有检测时间的代理安排立即检测，例如记录检测时间之前添加的代理，返回安排的代理数目
+func (s *Storage) ScheduleMissing() (int, error) {
+	proxies, err := s.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	t := unixMilli(time.Now())
+	argsList := make([][]any, 0, len(proxies))
+	for _, p := range proxies {
+		argsList = append(argsList, []any{p, t})
+	}
+	res, err := s.store.Batch(scheduleScript, s.keys(), argsList)
+	if err != nil {
+		return 0, err
+	}
+	scheduled := 0
+	for _, r := range res {
+		if n, err := kv.Int(r.Val, r.Err); err == nil && n == 1 {
+			scheduled++
+		}
+	}
+	return scheduled, nil
+}
+
+// 获取代理的下次检测时间，代理不存在时返回错误
+func (s *Storage) NextCheck(proxy string) (time.Time, error) {
+	t, err := s.store.ZScore(s.keys()[4], s.resolve(proxy))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(int64(t)), nil
+}
@@ -0,0 +1,59 @@
+package proxypool_test
+
+import (
+	"gospider/proxypool"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	storage, err := proxypool.NewFileStorage(filepath.Join(t.TempDir(), "proxy.db"), "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+
+	// 新添加的代理立即检测，取出后在租期内不会被再次取出
+	storage.Add("1.1.1.1:80")
+	storage.Add("2.2.2.2:80")
+	if ps, err := storage.ClaimDue(10, time.Hour); err != nil || len(ps) != 2 {
+		t.Fatalf("ClaimDue failed: expect 2, get %v %v\n", ps, err)
+	}
+	if ps, _ := storage.ClaimDue(10, time.Hour); len(ps) != 0 {
+		t.Fatalf("ClaimDue failed: claimed proxies %v are claimed again\n", ps)
+	}
+
+	// 连续通过检测时检测间隔加倍，最长为max
+	intervals := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute}
+	for _, expect := range intervals {
+		start := time.Now()
+		err := storage.UpdateScoresWithInterval([]proxypool.ScoreUpdate{{Proxy: "1.1.1.1:80", OK: true}}, time.Minute, 5*time.Minute)
+		if err != nil {
+			t.Fatalf("UpdateScoresWithInterval failed: %v\n", err)
+		}
+		next, _ := storage.NextCheck("1.1.1.1:80")
+		if d := next.Sub(start); d < expect || d > expect+time.Second {
+			t.Fatalf("UpdateScoresWithInterval failed: expect interval %v, get %v\n", expect, d)
+		}
+	}
+
+	// 没有通过检测时以最短间隔重新检测
+	start := time.Now()
+	storage.UpdateScoresWithInterval([]proxypool.ScoreUpdate{{Proxy: "1.1.1.1:80", OK: false}}, time.Minute, 5*time.Minute)
+	if next, _ := storage.NextCheck("1.1.1.1:80"); next.Sub(start) > time.Minute+time.Second {
+		t.Fatalf("UpdateScoresWithInterval failed: expect interval 1m, get %v\n", next.Sub(start))
+	}
+
+	// 被报告不可用的代理立即检测
+	storage.Report("2.2.2.2:80", false)
+	if ps, _ := storage.ClaimDue(10, time.Hour); len(ps) != 1 || ps[0] != "http://2.2.2.2:80" {
+		t.Fatalf("ClaimDue failed: expect [http://2.2.2.2:80], get %v\n", ps)
+	}
+
+	// 删除的代理不再检测
+	storage.Remove("1.1.1.1:80")
+	if _, err := storage.NextCheck("1.1.1.1:80"); err == nil {
+		t.Fatalf("Remove failed: schedule of removed proxy remains\n")
+	}
+}
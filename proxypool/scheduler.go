@@ -1,9 +1,13 @@
 package proxypool
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gospider"
+	"gospider/auth"
 	"log"
 	"net/http"
-	"runtime"
 	"sync"
 	"time"
 )
@@ -11,49 +15,91 @@ import (
 type Scheduler struct {
 	Storage  *Storage
 	Crawlers []Crawler
+	Jobs     []*CrawlJob // 按照各自计划运行的爬虫，为空时Crawlers中的爬虫每隔CrawlCycle秒运行
 	WebAddr  string
+	Auth     *auth.Guard         // web接口的认证，为nil时不进行认证
+	TLS      *gospider.TLSConfig // web接口的TLS配置，为nil时使用HTTP
+	Monitor  *Monitor            // 网络连接的监视器，为nil时使用默认配置
 
-	Threshold int // database最大存储量
+	Threshold int      // database最大存储量，Capacity.MaxTotal为0时使用
+	Capacity  Capacity // 存储的容量限制和淘汰策略
+	BatchSize int      // 批量写入存储的结果数目，默认为100
 
-	DetectCycle int
-	CrawlCycle  int
+	Policy       *NormalizePolicy // 代理地址的规范化策略，为nil时使用DefaultNormalizePolicy
+	Canonicalize bool             // 启动时按照Policy规范化已经存储的代理，不符合策略的代理会被删除
 
-	webserver *http.Server
-	abort     chan struct{}
-	wg        sync.WaitGroup
+	DetectCycle    int // 代理的最短检测间隔(秒)，没有通过检测的代理按照该间隔检测
+	MaxDetectCycle int // 代理的最长检测间隔(秒)，默认为DetectCycle的16倍
+	CrawlCycle     int // 没有设置计划的爬虫的运行间隔(秒)，默认为2小时
+
+	DetectConcurrency   int  // 检测的最大并发数，默认为20
+	AdaptiveConcurrency bool // 根据检测期间探测目标的结果调整检测的并发数
+	ValidateConcurrency int  // 验证候选代理的并发数，默认为20
+
+	webserver   *http.Server
+	detectLimit int // 当前的检测并发数
+
+	crawlMu     sync.Mutex
+	crawlStates []*crawlState
+	trigger     chan struct{} // 唤醒爬虫的调度循环
+	abort       chan struct{}
+	wg          sync.WaitGroup
 }
 
 func (sch *Scheduler) Serve() {
 	sch.abort = make(chan struct{})
+	if sch.Monitor == nil {
+		sch.Monitor = NewMonitor()
+	}
 
 	sch.wg.Add(1)
 	go func() {
 		defer sch.wg.Done()
-		log.Println("start detect sevice.")
-	loop:
-		for {
-			sch.detect()
-			select {
-			case <-sch.abort:
-				break loop
-			case <-time.After(time.Duration(sch.DetectCycle) * time.Second):
+		log.Println("start monitor sevice.")
+		sch.Monitor.Run(sch.abort)
+	}()
+
+	// 需要时合并之前以不同写法存储的同一个代理，并为之前添加的代理补充验证记录、安排检测
+	for _, storage := range sch.storages() {
+		if sch.Canonicalize {
+			if n, err := storage.Canonicalize(sch.policy()); err != nil {
+				log.Printf("canonicalize storage failed: %v\n", err)
+			} else if n > 0 {
+				log.Printf("canonicalize %d proxies.\n", n)
 			}
 		}
+		if n, err := storage.BackfillVerified(); err != nil {
+			log.Printf("backfill verified proxies failed: %v\n", err)
+		} else if n > 0 {
+			log.Printf("mark %d proxies verified.\n", n)
+		}
+		if n, err := storage.ScheduleMissing(); err != nil {
+			log.Printf("schedule proxies failed: %v\n", err)
+		} else if n > 0 {
+			log.Printf("schedule %d proxies.\n", n)
+		}
+	}
+
+	sch.wg.Add(1)
+	go func() {
+		defer sch.wg.Done()
+		log.Println("start detect sevice.")
+		sch.poll(sch.detect)
+	}()
+
+	sch.wg.Add(1)
+	go func() {
+		defer sch.wg.Done()
+		log.Println("start validate sevice.")
+		sch.poll(sch.validate)
 	}()
 
+	sch.initCrawlStates()
 	sch.wg.Add(1)
 	go func() {
 		defer sch.wg.Done()
 		log.Println("start crawl sevice.")
-	loop:
-		for {
-			sch.crawl()
-			select {
-			case <-sch.abort:
-				break loop
-			case <-time.After(time.Duration(sch.CrawlCycle) * time.Second):
-			}
-		}
+		sch.crawlLoop()
 	}()
 
 	sch.wg.Add(1)
@@ -70,192 +116,351 @@ func (sch *Scheduler) Close() {
 	close(sch.abort)
 }
 
-func (sch *Scheduler) detect() {
-	proxies, err := sch.Storage.GetAll()
-	if err != nil {
-		log.Printf("detect storage failed: %v\n", err)
-		return
+// 默认的存储以及所有令牌命名空间的存储
+func (sch *Scheduler) storages() []*Storage {
+	storages := []*Storage{sch.Storage}
+	for _, ns := range sch.Auth.Namespaces() {
+		storages = append(storages, sch.Storage.Namespace(ns))
+	}
+	return storages
+}
+
+const (
+	pollInterval = 5 * time.Second // 没有需要检测的代理时等待的时间
+	claimLease   = 2 * time.Minute // 取出的代理在该时间内没有提交结果时重新检测
+)
+
+// 反复调用fn直到退出，fn处理了一批代理时立即处理下一批，否则等待pollInterval
+func (sch *Scheduler) poll(fn func() int) {
+	for {
+		select {
+		case <-sch.abort:
+			return
+		default:
+		}
+		if fn() > 0 {
+			continue
+		}
+		select {
+		case <-sch.abort:
+			return
+		case <-time.After(pollInterval):
+		}
 	}
+}
 
-	type result struct {
-		proxy string
-		con   bool
-		err   error
+func (sch *Scheduler) detectInterval() time.Duration {
+	if sch.DetectCycle > 0 {
+		return time.Duration(sch.DetectCycle) * time.Second
 	}
-	resCh := make(chan *result, runtime.NumCPU())
+	return DefaultDetectInterval
+}
 
-	go func() {
-		workCh := make(chan struct{}, 20)
-		var workwg sync.WaitGroup
-
-	loop:
-		for _, proxy := range proxies {
-			for {
-				if IsConnected() {
-					break
-				}
-				log.Print("unable to connect to external network, retry after 1 min.\n")
-				select {
-				case <-sch.abort:
-					break loop
-				case <-time.After(1 * time.Minute):
-				}
-			}
+func (sch *Scheduler) maxDetectInterval() time.Duration {
+	if sch.MaxDetectCycle > 0 {
+		return time.Duration(sch.MaxDetectCycle) * time.Second
+	}
+	return 16 * sch.detectInterval()
+}
+
+func (sch *Scheduler) detectConcurrency() int {
+	if sch.DetectConcurrency > 0 {
+		return sch.DetectConcurrency
+	}
+	return 20
+}
+
+func (sch *Scheduler) validateConcurrency() int {
+	if sch.ValidateConcurrency > 0 {
+		return sch.ValidateConcurrency
+	}
+	return 20
+}
+
+// 根据检测期间Monitor探测目标的结果调整检测的并发数。代理失效是常态，代理的检测错误不能说明
+// 本地网络过载；检测期间已知可用的目标有探测失败时说明并发过高，减半，否则加一。
+// 范围为最大并发数的四分之一到最大并发数
+func (sch *Scheduler) adapt(succeeded, total int) {
+	max := sch.detectConcurrency()
+	if !sch.AdaptiveConcurrency {
+		sch.detectLimit = max
+		return
+	}
+	min := max / 4
+	if min < 1 {
+		min = 1
+	}
+	if sch.detectLimit == 0 {
+		sch.detectLimit = max
+	}
+	if succeeded < total {
+		sch.detectLimit /= 2
+	} else {
+		sch.detectLimit++
+	}
+	if sch.detectLimit < min {
+		sch.detectLimit = min
+	}
+	if sch.detectLimit > max {
+		sch.detectLimit = max
+	}
+}
 
-			select {
-			case <-sch.abort:
-				break loop
-			default:
-				workCh <- struct{}{}
-				workwg.Add(1)
-				go func(proxy string) {
-					con, err := DetectSingleProxy(proxy)
-					r := &result{
-						proxy: proxy,
-						con:   con,
-						err:   err,
-					}
-					select {
-					case <-sch.abort:
-					case resCh <- r:
-					}
-					workwg.Done()
-					<-workCh
-				}(proxy)
+// 从每个存储取出代理，同一个代理只检测一次，结果应用到所有包含该代理的存储
+func (sch *Scheduler) claim(fn func(storage *Storage) ([]string, error)) ([]string, map[string][]*Storage) {
+	var proxies []string
+	owners := map[string][]*Storage{}
+	for _, storage := range sch.storages() {
+		ps, err := fn(storage)
+		if err != nil {
+			log.Printf("claim proxies failed: %v\n", err)
+			continue
+		}
+		for _, p := range ps {
+			if _, ok := owners[p]; !ok {
+				proxies = append(proxies, p)
 			}
+			owners[p] = append(owners[p], storage)
 		}
-		workwg.Wait()
-		close(resCh)
-	}()
+	}
+	return proxies, owners
+}
 
+// 使用不超过limit个并发检测代理，返回检测结果
+func (sch *Scheduler) check(proxies []string, limit int) []ScoreUpdate {
+	results := make([]ScoreUpdate, len(proxies))
+	workCh := make(chan struct{}, limit)
 	var wg sync.WaitGroup
+	for i, proxy := range proxies {
+		workCh <- struct{}{}
+		wg.Add(1)
+		go func(i int, proxy string) {
+			defer func() {
+				<-workCh
+				wg.Done()
+			}()
+			con, err := DetectSingleProxy(proxy)
+			results[i] = ScoreUpdate{Proxy: proxy, OK: err == nil && con}
+		}(i, proxy)
+	}
+	wg.Wait()
+	return results
+}
 
-	addpCh := make(chan string, 10)
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for proxy := range addpCh {
-			select {
-			case <-sch.abort:
-				return
-			default:
-				log.Printf("proxy %s available.\n", proxy)
-				sch.Storage.SetMax(proxy)
-			}
+// 按照所属的存储分组检测结果
+func group(results []ScoreUpdate, owners map[string][]*Storage) map[*Storage][]ScoreUpdate {
+	groups := map[*Storage][]ScoreUpdate{}
+	for _, r := range results {
+		for _, storage := range owners[r.Proxy] {
+			groups[storage] = append(groups[storage], r)
 		}
-	}()
+	}
+	return groups
+}
 
-	delpCh := make(chan string, 10)
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for proxy := range delpCh {
-			select {
-			case <-sch.abort:
-				return
-			default:
-				log.Printf("proxy %s inavailable.\n", proxy)
-				sch.Storage.Decrease(proxy)
-			}
-		}
-	}()
+func (sch *Scheduler) aborted() bool {
+	select {
+	case <-sch.abort:
+		return true
+	default:
+		return false
+	}
+}
 
-resloop:
-	for res := range resCh {
-		select {
-		case <-sch.abort:
-			break resloop
-		default:
-			if res.err != nil {
-				delpCh <- res.proxy
-				continue
-			}
-			switch res.con {
-			case true:
-				addpCh <- res.proxy
-			case false:
-				delpCh <- res.proxy
-			}
-		}
+// 检测一批到期的代理并安排下次检测，返回检测的代理数目
+func (sch *Scheduler) detect() int {
+	// 无法连接外部网络时所有代理都会检测失败，等待网络恢复
+	if !sch.Monitor.Wait(sch.abort) {
+		return 0
+	}
 
+	if sch.detectLimit == 0 {
+		sch.detectLimit = sch.detectConcurrency()
+	}
+	proxies, owners := sch.claim(func(storage *Storage) ([]string, error) {
+		return storage.ClaimDue(sch.batchSize(), claimLease)
+	})
+	if len(proxies) == 0 {
+		return 0
+	}
+	// 自适应并发时在检测期间探测已知可用的目标
+	var probed chan struct{}
+	var succeeded, total int
+	if sch.AdaptiveConcurrency {
+		probed = make(chan struct{})
+		go func() {
+			succeeded, total = sch.Monitor.probe()
+			close(probed)
+		}()
+	}
+	results := sch.check(proxies, sch.detectLimit)
+	// 退出或者检测期间网络断开时不提交结果，租期过后重新检测
+	if sch.aborted() || !sch.Monitor.Connected() {
+		return len(proxies)
 	}
-	close(addpCh)
-	close(delpCh)
 
-	wg.Wait()
+	for _, r := range results {
+		if r.OK {
+			log.Printf("proxy %s available.\n", r.Proxy)
+		} else {
+			log.Printf("proxy %s inavailable.\n", r.Proxy)
+		}
+	}
+	for storage, rs := range group(results, owners) {
+		if err := storage.UpdateScoresWithInterval(rs, sch.detectInterval(), sch.maxDetectInterval()); err != nil {
+			log.Printf("update scores failed: %v\n", err)
+		}
+	}
+	if probed != nil {
+		<-probed
+	}
+	sch.adapt(succeeded, total)
+	return len(proxies)
 }
 
-func (sch *Scheduler) crawl() {
-	c, err := sch.Storage.Count()
-	if err != nil {
-		log.Printf("get count in database failed: %v\n", err)
-		return
-	}
-	if sch.Threshold > 0 && int(c) >= sch.Threshold {
-		log.Printf("exceed the threshold: the threshold of the database is %v\n", sch.Threshold)
-		return
+// 是否有存储的候选队列不为空，出错时认为不为空
+func (sch *Scheduler) hasCandidates() bool {
+	for _, storage := range sch.storages() {
+		if n, err := storage.CountCandidates(); err != nil || n > 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	var crawlers = sch.Crawlers
-	if len(crawlers) == 0 {
-		crawlers = DefaultCrawlers
+// 验证一批候选代理，通过的代理进入代理池，返回验证的代理数目
+func (sch *Scheduler) validate() int {
+	// 候选队列为空时不需要等待网络
+	if !sch.hasCandidates() {
+		return 0
+	}
+	if !sch.Monitor.Wait(sch.abort) {
+		return 0
 	}
 
-	addpCh := make(chan string, 10)
-	var addpwg sync.WaitGroup
+	proxies, owners := sch.claim(func(storage *Storage) ([]string, error) {
+		return storage.ClaimCandidates(sch.batchSize(), claimLease)
+	})
+	if len(proxies) == 0 {
+		return 0
+	}
+	results := sch.check(proxies, sch.validateConcurrency())
+	// 退出或者验证期间网络断开时不提交结果，租期过后重新验证
+	if sch.aborted() || !sch.Monitor.Connected() {
+		return len(proxies)
+	}
 
-	addpwg.Add(1)
-	go func() {
-		defer addpwg.Done()
-	addploop:
-		for proxy := range addpCh {
-			select {
-			case <-sch.abort:
-				break addploop
-			default:
-				log.Printf("add proxy: %s\n", proxy)
-				sch.Storage.Add(proxy)
-			}
+	for storage, rs := range group(results, owners) {
+		n, err := storage.Promote(rs, sch.detectInterval())
+		if err != nil {
+			log.Printf("promote candidates failed: %v\n", err)
+			continue
 		}
-	}()
+		log.Printf("promote %d proxies of %d candidates.\n", n, len(rs))
+	}
+	return len(proxies)
+}
 
-	workCh := make(chan struct{}, runtime.NumCPU())
-	var workwg sync.WaitGroup
+// 缓冲ch中的结果，达到size个或者每隔一秒调用一次flush。ch关闭时写入剩余的结果，
+// abort关闭时丢弃
+func buffer[T any](abort <-chan struct{}, size int, ch <-chan T, flush func([]T)) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
 
-crawlerloop:
-	for _, crawler := range crawlers {
+	var buf []T
+	for {
 		select {
-		case <-sch.abort:
-			break crawlerloop
-		default:
-			workCh <- struct{}{}
-			workwg.Add(1)
-			go func(c Crawler) {
-			loop:
-				for proxy := range c.Crawl() {
-					select {
-					case <-sch.abort:
-						break loop
-					case addpCh <- proxy:
-					}
-				}
-				if c, ok := c.(StoppableCrawler); ok {
-					c.Stop()
+		case <-abort:
+			return
+		case u, ok := <-ch:
+			if !ok {
+				if len(buf) > 0 {
+					flush(buf)
 				}
-				workwg.Done()
-				<-workCh
-			}(crawler)
+				return
+			}
+			buf = append(buf, u)
+			if len(buf) < size {
+				continue
+			}
+		case <-ticker.C:
+			if len(buf) == 0 {
+				continue
+			}
 		}
+		flush(buf)
+		buf = nil
 	}
-	workwg.Wait()
-	close(addpCh)
+}
+
+func (sch *Scheduler) batchSize() int {
+	if sch.BatchSize > 0 {
+		return sch.BatchSize
+	}
+	return 100
+}
 
-	addpwg.Wait()
+func (sch *Scheduler) policy() *NormalizePolicy {
+	if sch.Policy != nil {
+		return sch.Policy
+	}
+	return &DefaultNormalizePolicy
+}
+
+func (sch *Scheduler) capacity() Capacity {
+	c := sch.Capacity
+	if c.MaxTotal == 0 {
+		c.MaxTotal = sch.Threshold
+	}
+	return c
+}
+
+// 调度器的状态
+type SchedulerStatus struct {
+	Network  MonitorStatus   `json:"network"`
+	Crawlers []CrawlerStatus `json:"crawlers"`
+}
+
+func (sch *Scheduler) Status() SchedulerStatus {
+	return SchedulerStatus{Network: sch.Monitor.Status(), Crawlers: sch.CrawlerStatus()}
+}
+
+// 建立调度器的web服务。在NewAuthWebServer的接口之外提供调度器的状态和爬虫的管理
+//
+//	GET  /status        网络和爬虫的状态，需要proxy:read权限
+//	GET  /crawlers      爬虫的状态，需要proxy:read权限
+//	POST /crawlers/run  立即运行参数name指定的爬虫，需要admin权限
+func (sch *Scheduler) NewWebServer() *http.Server {
+	server := NewAuthWebServer(sch.Storage, sch.WebAddr, sch.Auth)
+	mux := server.Handler.(*http.ServeMux)
+	mux.HandleFunc("/status", sch.Auth.Handle(auth.ScopeReadProxy, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(sch.Status())
+	}))
+	mux.HandleFunc("/crawlers", sch.Auth.Handle(auth.ScopeReadProxy, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(sch.CrawlerStatus())
+	}))
+	mux.HandleFunc("/crawlers/run", sch.Auth.Handle(auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := sch.RunCrawler(r.FormValue("name")); errors.Is(err, ErrCrawlerNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	return server
 }
 
 func (sch *Scheduler) webserve() error {
 	if sch.webserver == nil {
-		sch.webserver = NewWebServer(sch.Storage, sch.WebAddr)
+		sch.webserver = sch.NewWebServer()
 	}
 
 	go func() {
@@ -263,5 +468,5 @@ func (sch *Scheduler) webserve() error {
 		sch.webserver.Close()
 	}()
 
-	return sch.webserver.ListenAndServe()
+	return sch.TLS.ListenAndServe(sch.webserver)
 }
@@ -0,0 +1,457 @@
+package proxypool
+
+// 存储的复合操作使用脚本在服务端原子地执行，避免检测、爬取和接口之间的竞争。
+// 每个脚本同时给出Lua版本和行为相同的Go版本，Go版本用于文件后端。
+// 添加时间和验证时间以秒为单位，下次检测时间以整数毫秒为单位，见unixMilli。
+// 除非另外说明，KEYS依次为代理池、添加时间、验证时间、候选队列、下次检测时间和连续通过检测的次数，
+// 见Storage.keys。
+
+import (
+	"gospider/internal/kv"
+	"math"
+	"strconv"
+)
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// 添加代理。ARGV[1]为代理，ARGV[2]为分数，ARGV[3]为1时更新已存在代理的分数，
+// ARGV[4]和ARGV[5]为以秒和毫秒为单位的当前时间，新添加的代理记录添加时间并且立即检测。
+// 新添加时返回1，否则返回0
+var addScript = kv.NewScript(`
+local exists = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if exists and ARGV[3] ~= '1' then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+if exists then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[4], ARGV[1])
+redis.call('ZADD', KEYS[5], ARGV[5], ARGV[1])
+return 1
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	_, err := ops.ZScore(keys[0], args[0])
+	exists := err == nil
+	if exists && args[2] != "1" {
+		return int64(0), nil
+	}
+	ops.ZAdd(keys[0], kv.Z{Member: args[0], Score: parseFloat(args[1])})
+	if exists {
+		return int64(0), nil
+	}
+	ops.ZAdd(keys[1], kv.Z{Member: args[0], Score: parseFloat(args[3])})
+	ops.ZAdd(keys[4], kv.Z{Member: args[0], Score: parseFloat(args[4])})
+	return int64(1), nil
+})
+
+// 减少代理的分数，分数低于ARGV[2]时删除代理以及它的所有记录，否则在当前时间ARGV[3](毫秒)重新检测。
+// 代理不存在时返回nil，删除时返回1，否则返回0
+var decreaseScript = kv.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return false
+end
+if tonumber(score) >= tonumber(ARGV[2]) then
+	redis.call('ZINCRBY', KEYS[1], -1, ARGV[1])
+	redis.call('ZADD', KEYS[5], ARGV[3], ARGV[1])
+	redis.call('ZADD', KEYS[6], 0, ARGV[1])
+	return 0
+end
+for _, key in ipairs(KEYS) do
+	redis.call('ZREM', key, ARGV[1])
+end
+return 1
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	score, err := ops.ZScore(keys[0], args[0])
+	if err != nil {
+		return nil, err
+	}
+	if score >= parseFloat(args[1]) {
+		ops.ZIncrBy(keys[0], -1, args[0])
+		ops.ZAdd(keys[4], kv.Z{Member: args[0], Score: parseFloat(args[2])})
+		ops.ZAdd(keys[5], kv.Z{Member: args[0], Score: 0})
+		return int64(0), nil
+	}
+	removeAll(ops, keys, args[0])
+	return int64(1), nil
+})
+
+// 随机获取分数不低于ARGV[1]的代理，只返回在KEYS[3]中有验证时间的代理，即至少通过过一次检测的代理。
+// ARGV[2]及之后为随机数，由调用者生成使脚本的结果是确定的。每个随机数按照排名选择一个最高分的代理，
+// 不需要检查所有最高分的代理；都没有通过过检测时从分数最高的101个代理中获取
+var randomScript = kv.NewScript(`
+local n = redis.call('ZCOUNT', KEYS[1], ARGV[1], '+inf')
+if n > 0 then
+	for i = 2, #ARGV do
+		local rank = tonumber(ARGV[i]) % n
+		local p = redis.call('ZREVRANGE', KEYS[1], rank, rank)[1]
+		if p and redis.call('ZSCORE', KEYS[3], p) then
+			return p
+		end
+	end
+end
+local proxies = {}
+for _, p in ipairs(redis.call('ZREVRANGE', KEYS[1], 0, 100)) do
+	if redis.call('ZSCORE', KEYS[3], p) then
+		proxies[#proxies + 1] = p
+	end
+end
+if #proxies == 0 then
+	return false
+end
+return proxies[tonumber(ARGV[2]) % #proxies + 1]
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	verified := func(p string) bool {
+		_, err := ops.ZScore(keys[2], p)
+		return err == nil
+	}
+	top, _ := ops.ZRangeByScore(keys[0], parseFloat(args[0]), math.Inf(1))
+	if n := len(top); n > 0 {
+		for _, arg := range args[1:] {
+			r, _ := strconv.Atoi(arg)
+			if p := top[n-1-r%n].Member; verified(p) {
+				return p, nil
+			}
+		}
+	}
+	zs, _ := ops.ZRevRange(keys[0], 0, 100)
+	var proxies []string
+	for _, z := range zs {
+		if verified(z.Member) {
+			proxies = append(proxies, z.Member)
+		}
+	}
+	if len(proxies) == 0 {
+		return nil, kv.Nil
+	}
+	r, _ := strconv.Atoi(args[1])
+	return proxies[r%len(proxies)], nil
+})
+
+// 分数在ARGV[1]和ARGV[2]之间并且通过过检测的代理，返回代理和分数交替组成的列表
+var verifiedScript = kv.NewScript(`
+local zs = redis.call('ZRANGEBYSCORE', KEYS[1], ARGV[1], ARGV[2], 'WITHSCORES')
+local res = {}
+for i = 1, #zs, 2 do
+	if redis.call('ZSCORE', KEYS[3], zs[i]) then
+		res[#res + 1] = zs[i]
+		res[#res + 1] = zs[i + 1]
+	end
+end
+return res
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	zs, _ := ops.ZRangeByScore(keys[0], parseFloat(args[0]), parseFloat(args[1]))
+	res := []any{}
+	for _, z := range zs {
+		if _, err := ops.ZScore(keys[2], z.Member); err == nil {
+			res = append(res, z.Member, strconv.FormatFloat(z.Score, 'g', 17, 64))
+		}
+	}
+	return res, nil
+})
+
+// 报告代理的检测结果，ARGV[5]和ARGV[6]为以秒和毫秒为单位的当前时间，ARGV[7]和ARGV[8]为
+// 以毫秒为单位的最短和最长的检测间隔。
+// ARGV[2]为1时设为最高分ARGV[3]并记录验证时间，连续通过检测的次数每增加一次检测间隔加倍；
+// 否则减少分数并以最短间隔重新检测，分数低于ARGV[4]时删除代理。代理不存在时返回nil
+var reportScript = kv.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return false
+end
+if ARGV[2] == '1' then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+	redis.call('ZADD', KEYS[3], ARGV[5], ARGV[1])
+	local streak = tonumber(redis.call('ZINCRBY', KEYS[6], 1, ARGV[1]))
+	local interval = math.min(tonumber(ARGV[7]) * 2 ^ (streak - 1), tonumber(ARGV[8]))
+	redis.call('ZADD', KEYS[5], tonumber(ARGV[6]) + interval, ARGV[1])
+	return 0
+end
+if tonumber(score) >= tonumber(ARGV[4]) then
+	redis.call('ZINCRBY', KEYS[1], -1, ARGV[1])
+	redis.call('ZADD', KEYS[6], 0, ARGV[1])
+	redis.call('ZADD', KEYS[5], tonumber(ARGV[6]) + tonumber(ARGV[7]), ARGV[1])
+	return 0
+end
+for _, key in ipairs(KEYS) do
+	redis.call('ZREM', key, ARGV[1])
+end
+return 1
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	score, err := ops.ZScore(keys[0], args[0])
+	if err != nil {
+		return nil, err
+	}
+	now, nowMilli := parseFloat(args[4]), parseFloat(args[5])
+	base, max := parseFloat(args[6]), parseFloat(args[7])
+	if args[1] == "1" {
+		ops.ZAdd(keys[0], kv.Z{Member: args[0], Score: parseFloat(args[2])})
+		ops.ZAdd(keys[2], kv.Z{Member: args[0], Score: now})
+		streak, _ := ops.ZIncrBy(keys[5], 1, args[0])
+		interval := math.Min(base*math.Pow(2, streak-1), max)
+		ops.ZAdd(keys[4], kv.Z{Member: args[0], Score: nowMilli + interval})
+		return int64(0), nil
+	}
+	if score >= parseFloat(args[3]) {
+		ops.ZIncrBy(keys[0], -1, args[0])
+		ops.ZAdd(keys[5], kv.Z{Member: args[0], Score: 0})
+		ops.ZAdd(keys[4], kv.Z{Member: args[0], Score: nowMilli + base})
+		return int64(0), nil
+	}
+	removeAll(ops, keys, args[0])
+	return int64(1), nil
+})
+
+// 将代理设为最高分ARGV[2]并记录验证时间ARGV[3]，没有检测时间的代理在ARGV[4](毫秒)检测
+var setMaxScript = kv.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+redis.call('ZADD', KEYS[5], 'NX', ARGV[4], ARGV[1])
+return 0
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	ops.ZAdd(keys[0], kv.Z{Member: args[0], Score: parseFloat(args[1])})
+	ops.ZAdd(keys[2], kv.Z{Member: args[0], Score: parseFloat(args[2])})
+	if _, err := ops.ZScore(keys[4], args[0]); err != nil {
+		ops.ZAdd(keys[4], kv.Z{Member: args[0], Score: parseFloat(args[3])})
+	}
+	return int64(0), nil
+})
+
+// 代理池中的代理ARGV[1]没有检测时间时安排在ARGV[2](毫秒)检测，返回是否安排了检测
+var scheduleScript = kv.NewScript(`
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) or redis.call('ZSCORE', KEYS[5], ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[5], ARGV[2], ARGV[1])
+return 1
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	if _, err := ops.ZScore(keys[0], args[0]); err != nil {
+		return int64(0), nil
+	}
+	if _, err := ops.ZScore(keys[4], args[0]); err == nil {
+		return int64(0), nil
+	}
+	ops.ZAdd(keys[4], kv.Z{Member: args[0], Score: parseFloat(args[1])})
+	return int64(1), nil
+})
+
+// 将代理ARGV[1]替换为规范化的地址ARGV[2]，保留两者中较高的分数，并将添加时间和验证时间
+// 的记录转移到新地址，新地址在当前时间ARGV[3](毫秒)检测。ARGV[2]为空时删除代理。代理不存在时返回nil
+var canonicalScript = kv.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return false
+end
+local added = redis.call('ZSCORE', KEYS[2], ARGV[1])
+local verified = redis.call('ZSCORE', KEYS[3], ARGV[1])
+for _, key in ipairs(KEYS) do
+	redis.call('ZREM', key, ARGV[1])
+end
+if ARGV[2] == '' then
+	return 1
+end
+local other = redis.call('ZSCORE', KEYS[1], ARGV[2])
+if other and tonumber(other) > tonumber(score) then
+	score = other
+end
+redis.call('ZADD', KEYS[1], score, ARGV[2])
+if added then
+	redis.call('ZADD', KEYS[2], 'NX', added, ARGV[2])
+end
+if verified then
+	redis.call('ZADD', KEYS[3], 'NX', verified, ARGV[2])
+end
+redis.call('ZADD', KEYS[5], 'NX', ARGV[3], ARGV[2])
+return 0
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	score, err := ops.ZScore(keys[0], args[0])
+	if err != nil {
+		return nil, err
+	}
+	added, aerr := ops.ZScore(keys[1], args[0])
+	verified, verr := ops.ZScore(keys[2], args[0])
+	removeAll(ops, keys, args[0])
+	if args[1] == "" {
+		return int64(1), nil
+	}
+	if other, err := ops.ZScore(keys[0], args[1]); err == nil && other > score {
+		score = other
+	}
+	ops.ZAdd(keys[0], kv.Z{Member: args[1], Score: score})
+	if _, err := ops.ZScore(keys[1], args[1]); aerr == nil && err != nil {
+		ops.ZAdd(keys[1], kv.Z{Member: args[1], Score: added})
+	}
+	if _, err := ops.ZScore(keys[2], args[1]); verr == nil && err != nil {
+		ops.ZAdd(keys[2], kv.Z{Member: args[1], Score: verified})
+	}
+	if _, err := ops.ZScore(keys[4], args[1]); err != nil {
+		ops.ZAdd(keys[4], kv.Z{Member: args[1], Score: parseFloat(args[2])})
+	}
+	return int64(0), nil
+})
+
+// 将代理ARGV[1]加入候选队列KEYS[4]，分数为可以验证的时间ARGV[2]，同时记录添加时间。
+// 代理已经在代理池或者候选队列中时返回0，否则返回1
+var enqueueScript = kv.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) or redis.call('ZSCORE', KEYS[4], ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+return 1
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	if _, err := ops.ZScore(keys[0], args[0]); err == nil {
+		return int64(0), nil
+	}
+	if _, err := ops.ZScore(keys[3], args[0]); err == nil {
+		return int64(0), nil
+	}
+	ops.ZAdd(keys[3], kv.Z{Member: args[0], Score: parseFloat(args[1])})
+	ops.ZAdd(keys[1], kv.Z{Member: args[0], Score: parseFloat(args[1])})
+	return int64(1), nil
+})
+
+// 从以时间为分数的有序集合KEYS[1]中取出最多ARGV[2]个到期的代理，即分数不大于当前时间ARGV[1]
+// 的代理，并将它们的分数设为ARGV[3]，在此之前不会被再次取出。取出者崩溃时代理在ARGV[3]之后
+// 重新可以取出。用于候选队列和检测时间
+var claimScript = kv.NewScript(`
+local proxies = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, p in ipairs(proxies) do
+	redis.call('ZADD', KEYS[1], ARGV[3], p)
+end
+return proxies
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	zs, _ := ops.ZRangeByScore(keys[0], math.Inf(-1), parseFloat(args[0]))
+	if n, _ := strconv.Atoi(args[1]); len(zs) > n {
+		zs = zs[:n]
+	}
+	proxies := make([]any, 0, len(zs))
+	for _, z := range zs {
+		ops.ZAdd(keys[0], kv.Z{Member: z.Member, Score: parseFloat(args[2])})
+		proxies = append(proxies, z.Member)
+	}
+	return proxies, nil
+})
+
+// 将候选代理ARGV[1]移出候选队列。ARGV[2]为1时以最高分ARGV[3]加入代理池并记录验证时间ARGV[4]，
+// 在ARGV[5](毫秒)再次检测，返回1；否则丢弃代理，返回0。代理不在候选队列中时返回nil
+var promoteScript = kv.NewScript(`
+if not redis.call('ZSCORE', KEYS[4], ARGV[1]) then
+	return false
+end
+redis.call('ZREM', KEYS[4], ARGV[1])
+if ARGV[2] ~= '1' then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[4], ARGV[1])
+redis.call('ZADD', KEYS[5], ARGV[5], ARGV[1])
+redis.call('ZADD', KEYS[6], 1, ARGV[1])
+return 1
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	if _, err := ops.ZScore(keys[3], args[0]); err != nil {
+		return nil, err
+	}
+	ops.ZRem(keys[3], args[0])
+	if args[1] != "1" {
+		ops.ZRem(keys[1], args[0])
+		return int64(0), nil
+	}
+	ops.ZAdd(keys[0], kv.Z{Member: args[0], Score: parseFloat(args[2])})
+	ops.ZAdd(keys[2], kv.Z{Member: args[0], Score: parseFloat(args[3])})
+	ops.ZAdd(keys[4], kv.Z{Member: args[0], Score: parseFloat(args[4])})
+	ops.ZAdd(keys[5], kv.Z{Member: args[0], Score: 1})
+	return int64(1), nil
+})
+
+// 淘汰代理ARGV[1]以及它的所有记录。代理的验证时间与选择淘汰时读取的ARGV[2]不同时不淘汰，
+// 没有验证时间时ARGV[2]为0。淘汰时返回1，代理已经不存在或者验证时间改变时返回0
+var evictScript = kv.NewScript(`
+local verified = tonumber(redis.call('ZSCORE', KEYS[3], ARGV[1])) or 0
+if verified ~= tonumber(ARGV[2]) then
+	return 0
+end
+local n = redis.call('ZREM', KEYS[1], ARGV[1]) + redis.call('ZREM', KEYS[4], ARGV[1])
+for _, key in ipairs(KEYS) do
+	redis.call('ZREM', key, ARGV[1])
+end
+if n > 0 then
+	return 1
+end
+return 0
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	verified, _ := ops.ZScore(keys[2], args[0])
+	if verified != parseFloat(args[1]) {
+		return int64(0), nil
+	}
+	_, err := ops.ZScore(keys[0], args[0])
+	_, qerr := ops.ZScore(keys[3], args[0])
+	removeAll(ops, keys, args[0])
+	if err == nil || qerr == nil {
+		return int64(1), nil
+	}
+	return int64(0), nil
+})
+
+// 验证时间为空时，将代理池中分数高于ARGV[1]、不高于ARGV[2]的代理的验证时间记为ARGV[3]，
+// 返回记录的代理数目
+var backfillScript = kv.NewScript(`
+if redis.call('ZCARD', KEYS[3]) > 0 then
+	return 0
+end
+local proxies = redis.call('ZRANGEBYSCORE', KEYS[1], '(' .. ARGV[1], ARGV[2])
+for _, proxy in ipairs(proxies) do
+	redis.call('ZADD', KEYS[3], ARGV[3], proxy)
+end
+return #proxies
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	if n, _ := ops.ZCard(keys[2]); n > 0 {
+		return int64(0), nil
+	}
+	min := parseFloat(args[0])
+	zs, _ := ops.ZRangeByScore(keys[0], min, parseFloat(args[1]))
+	n := int64(0)
+	for _, z := range zs {
+		if z.Score > min {
+			ops.ZAdd(keys[2], kv.Z{Member: z.Member, Score: parseFloat(args[2])})
+			n++
+		}
+	}
+	return n, nil
+})
+
+// 代理是否以ARGV[1]的写法存储在代理池中，存在时返回1，否则返回0
+var existsScript = kv.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 1
+end
+return 0
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	if _, err := ops.ZScore(keys[0], args[0]); err != nil {
+		return int64(0), nil
+	}
+	return int64(1), nil
+})
+
+// 从代理池和候选队列中删除ARGV中的所有代理以及它们的所有记录
+var removeScript = kv.NewScript(`
+for _, proxy in ipairs(ARGV) do
+	for _, key in ipairs(KEYS) do
+		redis.call('ZREM', key, proxy)
+	end
+end
+return #ARGV
+`, func(ops kv.Ops, keys []string, args []string) (any, error) {
+	removeAll(ops, keys, args...)
+	return int64(len(args)), nil
+})
+
+func removeAll(ops kv.Ops, keys []string, proxies ...string) {
+	for _, key := range keys {
+		ops.ZRem(key, proxies...)
+	}
+}
@@ -1,12 +1,15 @@
 package proxypool
 
 // 存储模块使用Redis的有序集合，用来做代理的去重和状态标识。
+// 另外两个有序集合记录代理的添加时间和最近一次通过检测的时间，用于淘汰代理；
+// 还有两个有序集合记录代理的下次检测时间和连续通过检测的次数，用于安排检测。
+// 不使用Redis时可以使用本地文件作为存储后端，见NewFileStorage。
 
 import (
-	"context"
 	"fmt"
+	"gospider/internal/kv"
+	"log"
 	"math/rand"
-	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -19,132 +22,381 @@ const (
 )
 
 type Storage struct {
-	rdb *redis.Client // redis客户端
-	key string        // 数据库键
+	store kv.Store // 存储后端
+	key   string   // 数据库键
 }
 
 func NewStorage(addr string, password string, key string) (*Storage, error) {
-	s := Storage{
-		rdb: redis.NewClient(&redis.Options{
-			Addr:     addr,
-			Password: password,
-			DB:       0,
-			PoolSize: 100,
-		}),
-		key: key,
+	return NewStorageWithOptions(&redis.UniversalOptions{
+		Addrs:    []string{addr},
+		Password: password,
+		DB:       0,
+		PoolSize: 100,
+	}, key)
+}
+
+// 使用完整的Redis配置建立存储。opts.Addrs包含多个地址时使用集群，
+// 设置opts.MasterName时使用哨兵
+func NewStorageWithOptions(opts *redis.UniversalOptions, key string) (*Storage, error) {
+	store, err := kv.NewRedisWithOptions(opts)
+	if err != nil {
+		return nil, err
 	}
+	return newStorage(store, key), nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// 使用已有的Redis客户端建立存储，集群模式下键会加上哈希标签
+func NewStorageWithClient(rdb redis.UniversalClient, key string) (*Storage, error) {
+	store, err := kv.NewRedis(rdb)
+	if err != nil {
+		return nil, err
+	}
+	return newStorage(store, key), nil
+}
 
-	_, err := s.rdb.Ping(ctx).Result()
+// 使用本地文件建立存储，不需要Redis。同一个进程中的多个存储可以使用同一个文件
+func NewFileStorage(path string, key string) (*Storage, error) {
+	store, err := kv.OpenFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return newStorage(store, key), nil
+}
 
-	return &s, nil
+func newStorage(store kv.Store, key string) *Storage {
+	return &Storage{store: store, key: store.HashTag(key)}
 }
 
-// 添加代理到数据库中，并设定分数
+// 关闭存储后端，使用已有Redis客户端建立的存储不会关闭该客户端
+func (s *Storage) Close() error {
+	return s.store.Close()
+}
+
+// 返回使用命名空间ns的存储，与s共用同一个后端。ns为空时返回s
+func (s *Storage) Namespace(ns string) *Storage {
+	if ns == "" {
+		return s
+	}
+	return &Storage{store: s.store, key: s.key + ":" + ns}
+}
+
+// 代理池、添加时间、验证时间、候选队列、下次检测时间和连续通过检测次数的键
+func (s *Storage) keys() []string {
+	return []string{
+		s.key,
+		s.key + "#added",
+		s.key + "#verified",
+		s.key + "#candidates",
+		s.key + "#schedule",
+		s.key + "#streak",
+	}
+}
+
+// 以秒为单位的时间
+func unixTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+func unixNow() float64 {
+	return unixTime(time.Now())
+}
+
+// 以毫秒为单位的时间，向上取整，用于下次检测时间。整数毫秒在Redis和文件后端中都没有精度损失，
+// 向上取整保证检测不早于安排的时间
+func unixMilli(t time.Time) int64 {
+	return (t.UnixNano() + int64(time.Millisecond) - 1) / int64(time.Millisecond)
+}
+
+// 添加代理的方式
+type AddMode int
+
+const (
+	AddIfAbsent AddMode = iota // 只添加不存在的代理，已存在的代理分数不变
+	AddUpsert                  // 添加不存在的代理，同时更新已存在代理的分数
+)
+
+// 添加代理到数据库中，并设定分数。代理已存在时不改变其分数
 func (s *Storage) Add(proxy string, args ...float64) error {
-	ctx := context.Background()
-	_, err := s.rdb.ZScore(ctx, s.key, proxy).Result()
+	score := initStorageScore
+	if len(args) > 0 {
+		score = args[0]
+	}
+	_, err := s.AddWithMode(proxy, score, AddIfAbsent)
+	return err
+}
+
+// 代理在存储中的写法。代理按照规范化的地址存储，例如"1.2.3.4:80"存储为"http://1.2.3.4:80"；
+// 之前以其他写法存储并且还没有规范化的代理使用原来的写法，见Canonicalize。无法解析的地址不变
+func (s *Storage) resolve(proxy string) string {
+	n, err := parsePolicy.Normalize(proxy)
+	if err != nil || n == proxy {
+		return proxy
+	}
+	if _, err := s.store.ZScore(s.key, proxy); err == nil {
+		return proxy
+	}
+	return n
+}
+
+// 批量获取代理在存储中的写法，与resolve相同。需要查询原来写法的代理在一次批量调用中查询，
+// 不为每个代理单独请求
+func (s *Storage) resolveAll(proxies []string) ([]string, error) {
+	res := make([]string, len(proxies))
+	var idx []int
+	var argsList [][]any
+	for i, p := range proxies {
+		res[i] = p
+		n, err := parsePolicy.Normalize(p)
+		if err != nil || n == p {
+			continue
+		}
+		res[i] = n
+		idx = append(idx, i)
+		argsList = append(argsList, []any{p})
+	}
+	if len(argsList) == 0 {
+		return res, nil
+	}
+	rs, err := s.store.Batch(existsScript, s.keys(), argsList)
 	if err != nil {
-		score := initStorageScore
-		if len(args) > 0 {
-			sc := args[0]
-			if sc < minStorageScore || sc > maxStorageScore {
-				return fmt.Errorf("Add proxy failed: score must in range [%v, %v]", minStorageScore, maxStorageScore)
-			}
-			score = sc
+		return nil, err
+	}
+	for j, r := range rs {
+		if n, err := kv.Int(r.Val, r.Err); err == nil && n == 1 {
+			res[idx[j]] = proxies[idx[j]]
 		}
-		return s.rdb.ZAdd(ctx, s.key, &redis.Z{Score: score, Member: proxy}).Err()
 	}
-	return nil
+	return res, nil
 }
 
-// 获取最高得分的代理
-func (s *Storage) Random() (string, error) {
-	ctx := context.Background()
-	score := strconv.FormatFloat(maxStorageScore, 'f', 1, 64)
-	proxies, err := s.rdb.ZRangeByScore(ctx, s.key,
-		&redis.ZRangeBy{Min: score, Max: score}).Result()
+// 按照mode原子地添加代理，返回代理是否是新添加的
+func (s *Storage) AddWithMode(proxy string, score float64, mode AddMode) (bool, error) {
+	if score < minStorageScore || score > maxStorageScore {
+		return false, fmt.Errorf("Add proxy failed: score must in range [%v, %v]", minStorageScore, maxStorageScore)
+	}
+	upsert := 0
+	if mode == AddUpsert {
+		upsert = 1
+	}
+	now := time.Now()
+	n, err := kv.Int(s.store.Run(addScript, s.keys(), s.resolve(proxy), score, upsert, unixTime(now), unixMilli(now)))
+	return n == 1, err
+}
+
+// 批量添加代理，返回新添加的代理数目
+func (s *Storage) AddMany(proxies []string, score float64, mode AddMode) (int, error) {
+	if score < minStorageScore || score > maxStorageScore {
+		return 0, fmt.Errorf("Add proxy failed: score must in range [%v, %v]", minStorageScore, maxStorageScore)
+	}
+	upsert := 0
+	if mode == AddUpsert {
+		upsert = 1
+	}
+	resolved, err := s.resolveAll(proxies)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	if len(proxies) > 0 {
-		return proxies[rand.Intn(len(proxies))], nil
+	now := time.Now()
+	t, ms := unixTime(now), unixMilli(now)
+	argsList := make([][]any, 0, len(proxies))
+	for _, p := range resolved {
+		argsList = append(argsList, []any{p, score, upsert, t, ms})
 	}
-	proxies, err = s.rdb.ZRevRange(ctx, s.key, 0, 100).Result()
+	res, err := s.store.Batch(addScript, s.keys(), argsList)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
-	if len(proxies) > 0 {
-		return proxies[rand.Intn(len(proxies))], nil
+	added := 0
+	for _, r := range res {
+		if n, err := kv.Int(r.Val, r.Err); err == nil && n == 1 {
+			added++
+		}
 	}
-	return "", fmt.Errorf("no memory in key %s in the db", s.key)
+	return added, nil
 }
 
-// 减少给定代理的分数。如果代理的分数为最低分，则删除代理
-func (s *Storage) Decrease(proxy string) error {
-	ctx := context.Background()
-	score, err := s.rdb.ZScore(ctx, s.key, proxy).Result()
+// 代理的检测或者使用结果
+type ScoreUpdate struct {
+	Proxy string
+	OK    bool // 可用时设为最高分，否则减少分数
+}
+
+func updateProxies(updates []ScoreUpdate) []string {
+	proxies := make([]string, 0, len(updates))
+	for _, u := range updates {
+		proxies = append(proxies, u.Proxy)
+	}
+	return proxies
+}
+
+// 批量更新代理的分数，已经不存在的代理被忽略。使用默认的检测间隔安排下次检测
+func (s *Storage) UpdateScores(updates []ScoreUpdate) error {
+	return s.UpdateScoresWithInterval(updates, DefaultDetectInterval, DefaultMaxDetectInterval)
+}
+
+// 批量更新代理的分数并安排下次检测。没有通过检测的代理间隔base后检测，通过检测的代理
+// 连续通过的次数每增加一次检测间隔加倍，最长为max
+func (s *Storage) UpdateScoresWithInterval(updates []ScoreUpdate, base, max time.Duration) error {
+	resolved, err := s.resolveAll(updateProxies(updates))
 	if err != nil {
 		return err
 	}
-	if score >= minStorageScore+1.0 {
-		err = s.rdb.ZIncrBy(ctx, s.key, -1.0, proxy).Err()
-		if err != nil {
-			return err
+	now := time.Now()
+	t, ms := unixTime(now), unixMilli(now)
+	argsList := make([][]any, 0, len(updates))
+	for i, u := range updates {
+		ok := 0
+		if u.OK {
+			ok = 1
+		}
+		argsList = append(argsList, []any{resolved[i], ok, maxStorageScore, minStorageScore + 1.0, t, ms, base.Milliseconds(), max.Milliseconds()})
+	}
+	res, err := s.store.Batch(reportScript, s.keys(), argsList)
+	if err != nil {
+		return err
+	}
+	for _, r := range res {
+		if r.Err != nil && r.Err != kv.Nil {
+			return r.Err
 		}
-	} else {
-		return s.rdb.ZRem(ctx, s.key, proxy).Err()
 	}
 	return nil
 }
 
+// 随机选择最高分代理的次数，见randomScript
+const randomTries = 8
+
+// 获取最高得分并且通过过检测的代理
+func (s *Storage) Random() (string, error) {
+	args := []any{maxStorageScore}
+	for i := 0; i < randomTries; i++ {
+		args = append(args, rand.Intn(1<<30))
+	}
+	p, err := kv.Text(s.store.Run(randomScript, s.keys(), args...))
+	if err == kv.Nil {
+		return "", fmt.Errorf("no memory in key %s in the db", s.key)
+	}
+	return p, err
+}
+
+type ProxyScore struct {
+	Proxy string  `json:"proxy"`
+	Score float64 `json:"score"`
+}
+
+// 随机获取最多n个分数不低于minScore并且通过过检测的代理，n不大于0时返回所有代理。
+// 筛选在一次脚本调用中完成，不需要读取整个验证时间集合
+func (s *Storage) RandomN(n int, minScore float64) ([]ProxyScore, error) {
+	vs, err := kv.Strings(s.store.Run(verifiedScript, s.keys(), minScore, maxStorageScore))
+	if err != nil {
+		return nil, err
+	}
+	proxies := make([]ProxyScore, 0, len(vs)/2)
+	for i := 0; i+1 < len(vs); i += 2 {
+		proxies = append(proxies, ProxyScore{Proxy: vs[i], Score: parseFloat(vs[i+1])})
+	}
+	rand.Shuffle(len(proxies), func(i, j int) { proxies[i], proxies[j] = proxies[j], proxies[i] })
+	if n > 0 && len(proxies) > n {
+		proxies = proxies[:n]
+	}
+	return proxies, nil
+}
+
+// 减少给定代理的分数。如果代理的分数为最低分，则删除代理
+func (s *Storage) Decrease(proxy string) error {
+	_, err := s.store.Run(decreaseScript, s.keys(), s.resolve(proxy), minStorageScore+1.0, unixMilli(time.Now()))
+	return err
+}
+
 // 判断所给的代理是否存在
 func (s *Storage) Exists(proxy string) (bool, error) {
-	ctx := context.Background()
-	err := s.rdb.ZScore(ctx, s.key, proxy).Err()
+	_, err := s.store.ZScore(s.key, s.resolve(proxy))
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-// 设置所给的代理最高得分
+// 获取代理的分数，代理不存在时返回错误
+func (s *Storage) Score(proxy string) (float64, error) {
+	return s.store.ZScore(s.key, s.resolve(proxy))
+}
+
+// 设置所给的代理最高得分，并记录验证时间
 func (s *Storage) SetMax(proxy string) error {
-	ctx := context.Background()
-	return s.rdb.ZAdd(ctx, s.key, &redis.Z{Score: maxStorageScore, Member: proxy}).Err()
+	now := time.Now()
+	_, err := s.store.Run(setMaxScript, s.keys(), s.resolve(proxy), maxStorageScore, unixTime(now), unixMilli(now))
+	return err
+}
+
+// 报告代理的使用结果，可用时设为最高得分，否则减少分数并尽快重新检测
+func (s *Storage) Report(proxy string, ok bool) error {
+	if ok {
+		return s.SetMax(proxy)
+	}
+	return s.Decrease(proxy)
 }
 
 // 计算数据库中所有代理的数目
 func (s *Storage) Count() (int64, error) {
-	ctx := context.Background()
-	return s.rdb.ZCard(ctx, s.key).Result()
+	return s.store.ZCard(s.key)
 }
 
 // 获得数据库中所有的代理
 func (s *Storage) GetAll() ([]string, error) {
-	ctx := context.Background()
-	proxies, err := s.rdb.ZRangeByScore(ctx, s.key, &redis.ZRangeBy{
-		Min: strconv.FormatFloat(minStorageScore, 'f', 1, 64),
-		Max: strconv.FormatFloat(maxStorageScore, 'f', 1, 64),
-	}).Result()
-	return proxies, err
+	zs, err := s.store.ZRangeByScore(s.key, minStorageScore, maxStorageScore)
+	if err != nil {
+		return nil, err
+	}
+	proxies := make([]string, 0, len(zs))
+	for _, z := range zs {
+		proxies = append(proxies, z.Member)
+	}
+	return proxies, nil
 }
 
-// 删除代理
+// 删除代理，同时删除代理规范化后的写法
 func (s *Storage) Remove(proxies ...string) (bool, error) {
-	ctx := context.Background()
-	var proxiesI []interface{}
+	if len(proxies) == 0 {
+		return true, nil
+	}
+	args := make([]any, 0, len(proxies))
 	for _, p := range proxies {
-		proxiesI = append(proxiesI, p)
+		args = append(args, p)
+		if n, err := parsePolicy.Normalize(p); err == nil && n != p {
+			args = append(args, n)
+		}
 	}
-	err := s.rdb.ZRem(ctx, s.key, proxiesI).Err()
-	if err != nil {
+	if _, err := s.store.Run(removeScript, s.keys(), args...); err != nil {
 		return false, err
 	}
 	return true, nil
 }
+
+// 按照策略规范化已经存储的代理，同一个代理的多种写法合并为一个并保留最高的分数，
+// 不符合策略的代理被删除并记录日志。返回合并或删除的代理数目
+func (s *Storage) Canonicalize(policy *NormalizePolicy) (int, error) {
+	proxies, err := s.GetAll()
+	if err != nil {
+		return 0, err
+	}
+	t := unixMilli(time.Now())
+	var argsList [][]any
+	for _, p := range proxies {
+		n, err := policy.Normalize(p)
+		if err == nil && n == p {
+			continue
+		}
+		if err != nil {
+			log.Printf("canonicalize: remove %s: %v\n", p, err)
+		}
+		argsList = append(argsList, []any{p, n, t})
+	}
+	if len(argsList) == 0 {
+		return 0, nil
+	}
+	if _, err := s.store.Batch(canonicalScript, s.keys(), argsList); err != nil {
+		return 0, err
+	}
+	return len(argsList), nil
+}
@@ -0,0 +1,82 @@
+package proxypool_test
+
+import (
+	"fmt"
+	"gospider/proxypool"
+	"path/filepath"
+	"testing"
+)
+
+const benchProxies = 1000
+
+// 文件存储以及可以连接时的Redis存储
+func benchStorages(b *testing.B) map[string]*proxypool.Storage {
+	storages := map[string]*proxypool.Storage{}
+	fs, err := proxypool.NewFileStorage(filepath.Join(b.TempDir(), "proxy.db"), "spiderproxy_bench")
+	if err != nil {
+		b.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	storages["file"] = fs
+	if rs, err := proxypool.NewStorage("localhost:6379", "", "spiderproxy_bench"); err == nil {
+		storages["redis"] = rs
+	}
+	return storages
+}
+
+func proxyList() []string {
+	proxies := make([]string, benchProxies)
+	for i := range proxies {
+		proxies[i] = fmt.Sprintf("10.0.%d.%d:80", i/256, i%256)
+	}
+	return proxies
+}
+
+func BenchmarkAdd(b *testing.B) {
+	proxies := proxyList()
+	for name, storage := range benchStorages(b) {
+		b.Run(name+"/single", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, p := range proxies {
+					storage.Add(p)
+				}
+				b.StopTimer()
+				storage.Remove(proxies...)
+				b.StartTimer()
+			}
+		})
+		b.Run(name+"/batch", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				storage.AddMany(proxies, 10, proxypool.AddIfAbsent)
+				b.StopTimer()
+				storage.Remove(proxies...)
+				b.StartTimer()
+			}
+		})
+		storage.Close()
+	}
+}
+
+func BenchmarkUpdateScores(b *testing.B) {
+	proxies := proxyList()
+	updates := make([]proxypool.ScoreUpdate, len(proxies))
+	for i, p := range proxies {
+		updates[i] = proxypool.ScoreUpdate{Proxy: p, OK: i%2 == 0}
+	}
+	for name, storage := range benchStorages(b) {
+		storage.AddMany(proxies, 50, proxypool.AddUpsert)
+		b.Run(name+"/single", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, u := range updates {
+					storage.Report(u.Proxy, u.OK)
+				}
+			}
+		})
+		b.Run(name+"/batch", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				storage.UpdateScores(updates)
+			}
+		})
+		storage.Remove(proxies...)
+		storage.Close()
+	}
+}
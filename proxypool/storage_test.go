@@ -1,7 +1,12 @@
 package proxypool_test
 
 import (
+	"fmt"
 	"gospider/proxypool"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -72,3 +77,197 @@ func TestStorage(t *testing.T) {
 		t.Fatalf("Query a proxy failed: expect %s not in the databast\n", addproxy)
 	}
 }
+
+func TestFileStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.db")
+	storage, err := proxypool.NewFileStorage(path, "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	storage.Add("1.1.1.1:80")
+	storage.Add("2.2.2.2:80", 1)
+	storage.SetMax("1.1.1.1:80")
+	storage.Decrease("2.2.2.2:80")
+	storage.Decrease("2.2.2.2:80")
+	storage.Close()
+
+	storage, err = proxypool.NewFileStorage(path, "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+	proxies, err := storage.GetAll()
+	// 代理按照规范化的地址存储
+	if err != nil || len(proxies) != 1 || proxies[0] != "http://1.1.1.1:80" {
+		t.Fatalf("FileStorage failed: expect [http://1.1.1.1:80], get %v %v\n", proxies, err)
+	}
+	if p, _ := storage.Random(); p != "http://1.1.1.1:80" {
+		t.Fatalf("FileStorage failed: expect http://1.1.1.1:80, get %s\n", p)
+	}
+	if ok, _ := storage.Exists(" http://1.1.1.1:80"); !ok {
+		t.Fatalf("FileStorage failed: proxy is not found by another form\n")
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	storage, err := proxypool.NewFileStorage(filepath.Join(t.TempDir(), "proxy.db"), "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+	testConcurrentUpdates(t, storage)
+}
+
+// Redis后端执行Lua脚本，与文件后端的Go函数分别测试
+func TestRedisConcurrentUpdates(t *testing.T) {
+	storage := newRedisStorage(t, "spiderproxy_hammer")
+	defer storage.Close()
+	defer storage.Remove("1.1.1.1:80", "2.2.2.2:80")
+	testConcurrentUpdates(t, storage)
+}
+
+func newRedisStorage(t *testing.T, key string) *proxypool.Storage {
+	const addr = "localhost:6379"
+	storage, err := proxypool.NewStorage(addr, "", key)
+	if err != nil {
+		t.Fatalf("Connect Redis Client failed: addr(%s) key(%s)\n", addr, key)
+	}
+	return storage
+}
+
+func testConcurrentUpdates(t *testing.T, storage *proxypool.Storage) {
+	storage.Remove("1.1.1.1:80", "2.2.2.2:80")
+
+	const workers = 60
+	var wg sync.WaitGroup
+	var added, decreased int32
+
+	// 同时添加同一个代理，只有一次是新添加的
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := storage.AddWithMode("1.1.1.1:80", 50, proxypool.AddIfAbsent); err == nil && ok {
+				atomic.AddInt32(&added, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if added != 1 {
+		t.Fatalf("AddWithMode failed: expect 1 added, get %d\n", added)
+	}
+
+	// 分数从50减到0需要50次，第51次删除代理，其余失败
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := storage.Decrease("1.1.1.1:80"); err == nil {
+				atomic.AddInt32(&decreased, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if decreased != 51 {
+		t.Fatalf("Decrease failed: expect 51 successful decreases, get %d\n", decreased)
+	}
+	if ok, _ := storage.Exists("1.1.1.1:80"); ok {
+		t.Fatalf("Decrease failed: proxy is not removed\n")
+	}
+
+	// 更新已存在代理的分数
+	storage.Add("2.2.2.2:80")
+	if ok, _ := storage.AddWithMode("2.2.2.2:80", 80, proxypool.AddUpsert); ok {
+		t.Fatalf("AddWithMode failed: existing proxy is reported as added\n")
+	}
+	if score, err := storage.Score("2.2.2.2:80"); err != nil || score != 80 {
+		t.Fatalf("AddWithMode failed: expect score 80, get %v %v\n", score, err)
+	}
+}
+
+func TestBatchUpdates(t *testing.T) {
+	storage, err := proxypool.NewFileStorage(filepath.Join(t.TempDir(), "proxy.db"), "spiderproxy_test")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer storage.Close()
+	testBatchUpdates(t, storage)
+}
+
+func TestRedisBatchUpdates(t *testing.T) {
+	storage := newRedisStorage(t, "spiderproxy_hammer")
+	defer storage.Close()
+	defer storage.Remove("1.1.1.1:80", "2.2.2.2:80", "3.3.3.3:80", "4.4.4.4:80")
+	testBatchUpdates(t, storage)
+}
+
+func testBatchUpdates(t *testing.T, storage *proxypool.Storage) {
+	storage.Remove("1.1.1.1:80", "2.2.2.2:80", "3.3.3.3:80", "4.4.4.4:80")
+	storage.Add("1.1.1.1:80", 50)
+	n, err := storage.AddMany([]string{"1.1.1.1:80", "2.2.2.2:80", "3.3.3.3:80"}, 0.5, proxypool.AddIfAbsent)
+	if err != nil || n != 2 {
+		t.Fatalf("AddMany failed: expect 2 added, get %d %v\n", n, err)
+	}
+
+	err = storage.UpdateScores([]proxypool.ScoreUpdate{
+		{Proxy: "1.1.1.1:80", OK: true},
+		{Proxy: "2.2.2.2:80", OK: false},
+		{Proxy: "4.4.4.4:80", OK: true},
+	})
+	if err != nil {
+		t.Fatalf("UpdateScores failed: %v\n", err)
+	}
+	proxies, _ := storage.GetAll()
+	if fmt.Sprint(proxies) != "[http://3.3.3.3:80 http://1.1.1.1:80]" {
+		t.Fatalf("UpdateScores failed: get %v\n", proxies)
+	}
+}
+
+// 同样的操作在Redis的Lua脚本和文件后端的Go函数上得到同样的结果
+func TestScriptAgreement(t *testing.T) {
+	redisStorage := newRedisStorage(t, "spiderproxy_agreement")
+	defer redisStorage.Close()
+	fileStorage, err := proxypool.NewFileStorage(filepath.Join(t.TempDir(), "proxy.db"), "spiderproxy_agreement")
+	if err != nil {
+		t.Fatalf("NewFileStorage failed: %v\n", err)
+	}
+	defer fileStorage.Close()
+
+	proxies := []string{"1.1.1.1:80", "2.2.2.2:80", "3.3.3.3:80", "4.4.4.4:80", "5.5.5.5:80"}
+	others := []string{"6.6.6.6:80", "7.7.7.7:80", "8.8.8.8:80"}
+	defer redisStorage.Remove(append(proxies, others...)...)
+	results := make([]string, 2)
+	for i, storage := range []*proxypool.Storage{redisStorage, fileStorage} {
+		storage.Remove(append(proxies, others...)...)
+		var out []any
+		n, err := storage.AddMany(proxies[:4], 10, proxypool.AddIfAbsent)
+		out = append(out, n, err)
+		ok, err := storage.AddWithMode(proxies[0], 30, proxypool.AddUpsert)
+		out = append(out, ok, err)
+		ok, err = storage.AddWithMode(proxies[4], 1, proxypool.AddIfAbsent)
+		out = append(out, ok, err)
+		out = append(out, storage.UpdateScores([]proxypool.ScoreUpdate{
+			{Proxy: proxies[1], OK: true},
+			{Proxy: proxies[2], OK: false},
+			{Proxy: "6.6.6.6:80", OK: true},
+		}))
+		out = append(out, storage.Decrease(proxies[3]), storage.Decrease(proxies[4]), storage.Decrease(proxies[4]))
+		out = append(out, storage.Report(proxies[3], true))
+		storage.AddCandidates([]string{"7.7.7.7:80", "8.8.8.8:80"})
+		n, err = storage.Evict(proxypool.Capacity{MaxCandidates: 1, MaxTotal: 4})
+		out = append(out, n, err)
+		for _, p := range append(proxies, "6.6.6.6:80") {
+			score, err := storage.Score(p)
+			out = append(out, p, score, err != nil)
+		}
+		all, err := storage.GetAll()
+		out = append(out, all, err)
+		ps, err := storage.RandomN(0, 0)
+		sort.Slice(ps, func(i, j int) bool { return ps[i].Proxy < ps[j].Proxy })
+		out = append(out, ps, err)
+		results[i] = fmt.Sprint(out...)
+	}
+	if results[0] != results[1] {
+		t.Fatalf("Script agreement failed:\nredis %s\nfile  %s\n", results[0], results[1])
+	}
+}
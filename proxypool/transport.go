@@ -0,0 +1,198 @@
+package proxypool
+
+import (
+	"gospider/internal/proxyutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 代理来源，*Storage满足该接口
+type Source interface {
+	Random() (string, error)
+}
+
+// 可以接收代理使用结果的代理来源，*Storage满足该接口
+type Reporter interface {
+	Source
+	Report(proxy string, ok bool) error
+}
+
+// 根据响应判断代理是否被目标网站封禁
+type BanFunc func(resp *http.Response) bool
+
+// 响应状态码为codes之一时认为代理被封禁
+func BanStatus(codes ...int) BanFunc {
+	return func(resp *http.Response) bool {
+		for _, code := range codes {
+			if resp.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// 使用代理池中的代理发送请求的http.RoundTripper。每个代理使用一个Transport，
+// 连接失败或者被封禁时换一个代理重试。Source满足Reporter时报告代理的使用结果，
+// 失败每次都报告，成功在ReportInterval内对同一个代理只报告一次
+type Transport struct {
+	Source         Source
+	PerHost        bool            // 同一个主机使用同一个代理，直到代理失败
+	Ban            BanFunc         // 判断代理是否被封禁，为nil时只处理连接错误
+	MaxRetries     int             // 换代理重试的次数，默认为2，负数时不重试
+	Base           *http.Transport // 创建每个代理的Transport时使用的模板，为nil时使用http.DefaultTransport
+	ReportInterval time.Duration   // 同一个代理报告成功的最小间隔，默认为1分钟
+
+	mu         sync.Mutex
+	transports *proxyutil.TransportCache
+	hosts      map[string]string
+	reported   map[string]time.Time
+}
+
+func (t *Transport) maxRetries() int {
+	if t.MaxRetries == 0 {
+		return 2
+	}
+	if t.MaxRetries < 0 {
+		return 0
+	}
+	return t.MaxRetries
+}
+
+// 选择代理，PerHost时优先使用主机上一次使用的代理
+func (t *Transport) pick(host string) (string, error) {
+	if t.PerHost {
+		t.mu.Lock()
+		proxy, ok := t.hosts[host]
+		t.mu.Unlock()
+		if ok {
+			return proxy, nil
+		}
+	}
+	proxy, err := t.Source.Random()
+	if err != nil {
+		return "", err
+	}
+	if t.PerHost {
+		t.mu.Lock()
+		// 请求的主机很多时清空，与reported相同
+		if t.hosts == nil || len(t.hosts) >= 1000 {
+			t.hosts = map[string]string{}
+		}
+		t.hosts[host] = proxy
+		t.mu.Unlock()
+	}
+	return proxy, nil
+}
+
+func (t *Transport) reportInterval() time.Duration {
+	if t.ReportInterval > 0 {
+		return t.ReportInterval
+	}
+	return time.Minute
+}
+
+func (t *Transport) cache() *proxyutil.TransportCache {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.transports == nil {
+		t.transports = &proxyutil.TransportCache{Base: t.Base}
+	}
+	return t.transports
+}
+
+func (t *Transport) transport(proxy string) (*http.Transport, error) {
+	return t.cache().Get(proxy)
+}
+
+// 代理失败后不再使用它的Transport
+func (t *Transport) drop(host, proxy string) {
+	t.cache().Drop(proxy)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hosts[host] == proxy {
+		delete(t.hosts, host)
+	}
+	delete(t.reported, proxy)
+}
+
+func (t *Transport) report(proxy string, ok bool) {
+	r, isReporter := t.Source.(Reporter)
+	if !isReporter {
+		return
+	}
+	if ok && !t.sample(proxy) {
+		return
+	}
+	r.Report(proxy, ok)
+}
+
+// 成功是否需要报告，同一个代理在ReportInterval内只报告一次
+func (t *Transport) sample(proxy string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if last, ok := t.reported[proxy]; ok && now.Sub(last) < t.reportInterval() {
+		return false
+	}
+	// 代理不断轮换，记录过多时清空
+	if t.reported == nil || len(t.reported) >= 1000 {
+		t.reported = map[string]time.Time{}
+	}
+	t.reported[proxy] = now
+	return true
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := t.maxRetries()
+	// 请求正文无法重新读取时不重试
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		retries = 0
+	}
+
+	host := req.URL.Host
+	for i := 0; ; i++ {
+		proxy, err := t.pick(host)
+		if err != nil {
+			return nil, err
+		}
+		tr, err := t.transport(proxy)
+		if err != nil {
+			t.drop(host, proxy)
+			return nil, err
+		}
+
+		r := req
+		if i > 0 && req.GetBody != nil {
+			r = req.Clone(req.Context())
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := tr.RoundTrip(r)
+		// 请求被取消时不是代理的问题
+		if err != nil && req.Context().Err() != nil {
+			return nil, err
+		}
+		banned := err == nil && t.Ban != nil && t.Ban(resp)
+		if err == nil && !banned {
+			t.report(proxy, true)
+			return resp, nil
+		}
+		t.report(proxy, false)
+		t.drop(host, proxy)
+		if i >= retries {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// 关闭所有代理的空闲连接
+func (t *Transport) CloseIdleConnections() {
+	t.cache().CloseIdleConnections()
+}
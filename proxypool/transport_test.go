@@ -0,0 +1,85 @@
+package proxypool_test
+
+import (
+	"fmt"
+	"gospider/proxypool"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 按顺序返回代理并记录使用结果的代理来源
+type testSource struct {
+	mu      sync.Mutex
+	proxies []string
+	reports []string
+}
+
+func (s *testSource) Random() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.proxies) == 0 {
+		return "", fmt.Errorf("no proxy")
+	}
+	p := s.proxies[0]
+	s.proxies = append(s.proxies[1:], p)
+	return p, nil
+}
+
+func (s *testSource) Report(proxy string, ok bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports = append(s.reports, fmt.Sprintf("%s %v", proxy, ok))
+	return nil
+}
+
+func TestTransport(t *testing.T) {
+	// 作为HTTP代理的服务器，banned代理返回403
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Host)
+	}))
+	defer good.Close()
+	banned := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer banned.Close()
+
+	goodProxy := strings.TrimPrefix(good.URL, "http://")
+	bannedProxy := strings.TrimPrefix(banned.URL, "http://")
+	src := &testSource{proxies: []string{"127.0.0.1:1", bannedProxy, goodProxy}}
+	tr := &proxypool.Transport{
+		Source:  src,
+		PerHost: true,
+		Ban:     proxypool.BanStatus(http.StatusForbidden),
+	}
+	c := &http.Client{Transport: tr}
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get("http://example.com/")
+		if err != nil {
+			t.Fatalf("Transport failed: %v\n", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "example.com" {
+			t.Fatalf("Transport failed: get %s\n", body)
+		}
+	}
+
+	// 第二次请求使用同一个代理，成功只报告一次
+	expect := []string{"127.0.0.1:1 false", bannedProxy + " false", goodProxy + " true"}
+	if fmt.Sprint(src.reports) != fmt.Sprint(expect) {
+		t.Fatalf("Transport failed: expect reports %v, get %v\n", expect, src.reports)
+	}
+
+	// 不重试时返回被封禁的响应
+	tr = &proxypool.Transport{Source: &testSource{proxies: []string{bannedProxy}}, Ban: tr.Ban, MaxRetries: -1}
+	resp, err := (&http.Client{Transport: tr}).Get("http://example.com/")
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Transport failed: expect 403, get %v %v\n", resp, err)
+	}
+	resp.Body.Close()
+}
@@ -1,27 +1,91 @@
 package proxypool
 
 import (
+	"encoding/json"
 	"fmt"
+	"gospider/auth"
 	"net/http"
+	"strconv"
 )
 
 // 建立web服务，提供获取代理的功能
 func NewWebServer(s *Storage, addr string) *http.Server {
+	return NewAuthWebServer(s, addr, nil)
+}
+
+// 建立需要认证的web服务，请求使用令牌对应命名空间的存储。g为nil时不进行认证
+//
+//	GET  /random    获取代理，需要proxy:read权限
+//	GET  /count     代理数目，需要proxy:read权限
+//	GET  /proxies   随机获取多个代理及其分数，参数n(默认10)和min_score，需要proxy:read权限
+//	POST /report    报告代理的使用结果，参数proxy和ok，需要proxy:report权限
+func NewAuthWebServer(s *Storage, addr string, g *auth.Guard) *http.Server {
+	server := &http.Server{Addr: addr, Handler: newServeMux(s, g)}
+
+	return server
+}
+
+func newServeMux(s *Storage, g *auth.Guard) *http.ServeMux {
 	servermux := &http.ServeMux{}
 	servermux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		fmt.Fprintln(w, "<h2>Welcome to Proxy Pool System</h2>")
 	})
-	servermux.HandleFunc("/random", func(w http.ResponseWriter, r *http.Request) {
-		v, _ := s.Random()
+	servermux.HandleFunc("/random", g.Handle(auth.ScopeReadProxy, func(w http.ResponseWriter, r *http.Request) {
+		v, _ := s.Namespace(auth.Namespace(r)).Random()
 		fmt.Fprintf(w, "%v", v)
-	})
-	servermux.HandleFunc("/count", func(w http.ResponseWriter, r *http.Request) {
-		v, _ := s.Count()
+	}))
+	servermux.HandleFunc("/count", g.Handle(auth.ScopeReadProxy, func(w http.ResponseWriter, r *http.Request) {
+		v, _ := s.Namespace(auth.Namespace(r)).Count()
 		fmt.Fprintf(w, "%v", v)
-	})
+	}))
+	servermux.HandleFunc("/proxies", g.Handle(auth.ScopeReadProxy, func(w http.ResponseWriter, r *http.Request) {
+		n, minScore := 10, minStorageScore
+		if v := r.FormValue("n"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "invalid n", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := r.FormValue("min_score"); v != "" {
+			var err error
+			if minScore, err = strconv.ParseFloat(v, 64); err != nil {
+				http.Error(w, "invalid min_score", http.StatusBadRequest)
+				return
+			}
+		}
+		proxies, err := s.Namespace(auth.Namespace(r)).RandomN(n, minScore)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(proxies)
+	}))
+	servermux.HandleFunc("/report", g.Handle(auth.ScopeReport, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		proxy := r.FormValue("proxy")
+		ok, err := strconv.ParseBool(r.FormValue("ok"))
+		if proxy == "" || err != nil {
+			http.Error(w, "proxy and ok are required", http.StatusBadRequest)
+			return
+		}
+		ns := s.Namespace(auth.Namespace(r))
+		if exists, _ := ns.Exists(proxy); !exists {
+			http.Error(w, "no proxy in the pool", http.StatusNotFound)
+			return
+		}
+		if err := ns.Report(proxy, ok); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	g.HandleAdmin(servermux)
 
-	server := &http.Server{Addr: addr, Handler: servermux}
-
-	return server
+	return servermux
 }
@@ -1,26 +1,180 @@
 package main
 
 import (
+	"crypto/tls"
+	"flag"
+	"gospider"
+	"gospider/auth"
 	"gospider/proxypool"
 	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	redisAddrs    = flag.String("redis", "localhost:6379", "comma-separated redis addresses, multiple addresses use cluster")
+	redisUsername = flag.String("redis-username", "", "redis acl username")
+	redisPassword = flag.String("redis-password", "", "redis password")
+	redisDB       = flag.Int("redis-db", 0, "redis database")
+	redisMaster   = flag.String("redis-master", "", "sentinel master name, connect through sentinel when not empty")
+	redisTLS      = flag.Bool("redis-tls", false, "connect to redis with tls")
+	dataFile      = flag.String("file", "", "store proxies and tokens in a local file instead of redis")
+
+	tokenKey       = flag.String("tokens", "", "redis key of the api tokens, authentication is disabled when empty")
+	adminTokenFile = flag.String("admin-token-file", "", "file containing an admin token to create at startup, $"+adminTokenEnv+" is used when empty")
+	certFile       = flag.String("cert", "", "tls certificate file, serve http when empty")
+	keyFile        = flag.String("key", "", "tls private key file")
+	clientCA       = flag.String("client-ca", "", "ca file to verify client certificates, not verify when empty")
+
+	maxCandidates = flag.Int("max-candidates", 0, "max unverified proxies, unlimited when 0")
+	maxVerified   = flag.Int("max-verified", 0, "max verified proxies, unlimited when 0")
+	staleAfter    = flag.Duration("stale-after", 0, "evict proxies not verified within the duration, disabled when 0")
+	evictPolicy   = flag.String("evict", "score", "eviction policy when the pool is full: score, oldest or verified")
+	canonicalize  = flag.Bool("canonicalize", false, "normalize stored proxies at startup and remove those rejected by the policy")
+
+	detectConcurrency = flag.Int("detect-concurrency", 20, "max concurrent proxy checks")
+	adaptive          = flag.Bool("adaptive", false, "adapt the check concurrency to the error rate")
+	probeTargets      = flag.String("probe", "", "comma-separated urls to probe the network, use the defaults when empty")
+
+	schedules = flag.String("schedules", "", `semicolon-separated crawler schedules such as "kdl=@every 10m;ip89=0 3 * * *"`)
+	cooldown  = flag.Duration("cooldown", 10*time.Minute, "wait at least the duration before rerunning a failed crawler")
 )
 
+// 启动时创建的管理员令牌的环境变量。令牌不通过命令行参数传入，避免出现在ps和shell历史中
+const adminTokenEnv = "PROXYSERVER_ADMIN_TOKEN"
+
+// 从-admin-token-file指定的文件或者环境变量中读取管理员令牌
+func adminToken() (string, error) {
+	if *adminTokenFile == "" {
+		return os.Getenv(adminTokenEnv), nil
+	}
+	b, err := os.ReadFile(*adminTokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+var evictPolicies = map[string]proxypool.EvictPolicy{
+	"score":    proxypool.EvictLowestScore,
+	"oldest":   proxypool.EvictOldest,
+	"verified": proxypool.EvictLeastVerified,
+}
+
 func main() {
-	storage, err := proxypool.NewStorage("localhost:6379", "", "spiderproxy")
+	flag.Parse()
+
+	var storage *proxypool.Storage
+	var rdb redis.UniversalClient
+	var err error
+	if *dataFile != "" {
+		storage, err = proxypool.NewFileStorage(*dataFile, "spiderproxy")
+	} else {
+		opts := &redis.UniversalOptions{
+			Addrs:      strings.Split(*redisAddrs, ","),
+			Username:   *redisUsername,
+			Password:   *redisPassword,
+			DB:         *redisDB,
+			MasterName: *redisMaster,
+			PoolSize:   100,
+		}
+		if *redisTLS {
+			opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		rdb = redis.NewUniversalClient(opts)
+		storage, err = proxypool.NewStorageWithClient(rdb, "spiderproxy")
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	crawlers := proxypool.DefaultCrawlers
+	jobs := proxypool.DefaultCrawlJobs()
+	for _, job := range jobs {
+		job.Cooldown = *cooldown
+	}
+	for _, spec := range strings.Split(*schedules, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		name, expr, ok := strings.Cut(spec, "=")
+		if !ok {
+			log.Fatalf("invalid crawler schedule: %s\n", spec)
+		}
+		schedule, err := proxypool.ParseSchedule(expr)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		found := false
+		for _, job := range jobs {
+			if job.Name == strings.TrimSpace(name) {
+				job.Schedule = schedule
+				found = true
+			}
+		}
+		if !found {
+			log.Fatalf("unknown crawler: %s\n", name)
+		}
+	}
+
+	policy, ok := evictPolicies[*evictPolicy]
+	if !ok {
+		log.Fatalf("unknown eviction policy: %s\n", *evictPolicy)
+	}
 
 	scheduler := &proxypool.Scheduler{
 		Storage:     storage,
-		Crawlers:    crawlers,
+		Jobs:        jobs,
 		WebAddr:     "localhost:8090",
 		Threshold:   10000,
 		DetectCycle: 60,
 		CrawlCycle:  2 * 60 * 60, // period (second)
 	}
+	if *probeTargets != "" {
+		scheduler.Monitor = proxypool.NewMonitor(strings.Split(*probeTargets, ",")...)
+	}
+	scheduler.DetectConcurrency = *detectConcurrency
+	scheduler.AdaptiveConcurrency = *adaptive
+	scheduler.Canonicalize = *canonicalize
+	scheduler.Capacity = proxypool.Capacity{
+		MaxCandidates: *maxCandidates,
+		MaxVerified:   *maxVerified,
+		StaleAfter:    *staleAfter,
+		Policy:        policy,
+	}
+
+	if *tokenKey != "" {
+		var store *auth.Store
+		if *dataFile != "" {
+			store, err = auth.NewFileStore(*dataFile, *tokenKey)
+		} else {
+			store, err = auth.NewStoreWithClient(rdb, *tokenKey)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		token, err := adminToken()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if token != "" {
+			err := store.Set(&auth.Token{Token: token, Name: "admin", Scopes: []string{auth.ScopeAdmin}})
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+		scheduler.Auth = auth.NewGuard(store)
+	}
+
+	if *certFile != "" {
+		scheduler.TLS = &gospider.TLSConfig{
+			CertFile:     *certFile,
+			KeyFile:      *keyFile,
+			ClientCAFile: *clientCA,
+		}
+	}
 
 	scheduler.Serve()
 }
@@ -0,0 +1,136 @@
+package gospider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// web接口的TLS配置。证书文件更新后自动重新加载，不需要重启服务。
+// ClientCAFile非空时要求客户端提供由该CA签发的证书(mTLS)
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string        // 客户端证书的CA，为空时不验证客户端证书
+	ReloadInterval time.Duration // 检查证书文件是否更新的间隔，默认为1分钟
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTime   time.Time // 已加载文件中最新的修改时间
+	checked   time.Time // 上一次检查文件的时间
+}
+
+func (c *TLSConfig) reloadInterval() time.Duration {
+	if c.ReloadInterval > 0 {
+		return c.ReloadInterval
+	}
+	return 1 * time.Minute
+}
+
+func (c *TLSConfig) files() []string {
+	files := []string{c.CertFile, c.KeyFile}
+	if c.ClientCAFile != "" {
+		files = append(files, c.ClientCAFile)
+	}
+	return files
+}
+
+// 文件有更新时重新加载证书，调用时需要持有锁
+func (c *TLSConfig) load() error {
+	var modTime time.Time
+	for _, f := range c.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if c.ClientCAFile != "" {
+		b, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates in %s", c.ClientCAFile)
+		}
+	}
+
+	c.cert, c.clientCAs, c.modTime = &cert, pool, modTime
+	return nil
+}
+
+// 当前的证书配置，超过ReloadInterval时检查文件是否更新。
+// 重新加载失败时继续使用旧的证书
+func (c *TLSConfig) current() *tls.Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := time.Now(); now.Sub(c.checked) >= c.reloadInterval() {
+		c.checked = now
+		if err := c.load(); err != nil {
+			log.Printf("reload certificates failed: %v\n", err)
+		}
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*c.cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if c.clientCAs != nil {
+		cfg.ClientCAs = c.clientCAs
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// 建立tls.Config，每次握手时使用最新加载的证书
+func (c *TLSConfig) Config() (*tls.Config, error) {
+	c.mu.Lock()
+	err := c.load()
+	c.checked = time.Now()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &c.current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.current(), nil
+		},
+	}, nil
+}
+
+// 启动web服务，c为nil时使用HTTP
+func (c *TLSConfig) ListenAndServe(srv *http.Server) error {
+	if c == nil {
+		return srv.ListenAndServe()
+	}
+	cfg, err := c.Config()
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = cfg
+	return srv.ListenAndServeTLS("", "")
+}
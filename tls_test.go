@@ -0,0 +1,149 @@
+package gospider_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"gospider"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// 生成证书，parent为nil时生成自签名的CA
+func newTestCert(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c *testCert) keyPEM() []byte {
+	b, _ := x509.MarshalECPrivateKey(c.key)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	cert, _ := tls.X509KeyPair(c.certPEM(), c.keyPEM())
+	return cert
+}
+
+func writeFile(t *testing.T, name string, data []byte, mod time.Time) {
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	ca := newTestCert(t, "ca", nil, x509.ExtKeyUsageAny)
+	server := newTestCert(t, "server1", ca, x509.ExtKeyUsageServerAuth)
+	client := newTestCert(t, "client", ca, x509.ExtKeyUsageClientAuth)
+
+	mod := time.Now().Add(-time.Minute)
+	writeFile(t, certFile, server.certPEM(), mod)
+	writeFile(t, keyFile, server.keyPEM(), mod)
+	writeFile(t, caFile, ca.certPEM(), mod)
+
+	c := &gospider.TLSConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ClientCAFile:   caFile,
+		ReloadInterval: time.Millisecond,
+	}
+	cfg, err := c.Config()
+	if err != nil {
+		t.Fatalf("Config failed: %v\n", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (string, error) {
+		cli := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs, ServerName: "localhost"},
+		}}
+		resp, err := cli.Get(ts.URL)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	if _, err := get(); err == nil {
+		t.Fatalf("TLSConfig failed: client without certificate is accepted\n")
+	}
+	name, err := get(client.tlsCert())
+	if err != nil {
+		t.Fatalf("TLSConfig failed: %v\n", err)
+	}
+	if name != "server1" {
+		t.Fatalf("TLSConfig failed: expect server1, get %s\n", name)
+	}
+
+	// 更新证书后不需要重启
+	server = newTestCert(t, "server2", ca, x509.ExtKeyUsageServerAuth)
+	mod = time.Now()
+	writeFile(t, certFile, server.certPEM(), mod)
+	writeFile(t, keyFile, server.keyPEM(), mod)
+	time.Sleep(10 * time.Millisecond)
+
+	name, err = get(client.tlsCert())
+	if err != nil {
+		t.Fatalf("TLSConfig failed: %v\n", err)
+	}
+	if name != "server2" {
+		t.Fatalf("TLSConfig reload failed: expect server2, get %s\n", name)
+	}
+}